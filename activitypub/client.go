@@ -0,0 +1,358 @@
+package activitypub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxResponseSize limits how much of a remote document is read
+	maxResponseSize = 1 << 20
+	// actorCacheTTL is how long fetched actors, and so their public keys, are trusted before being fetched again
+	actorCacheTTL     = time.Hour
+	deliveryQueueSize = 1000
+)
+
+// RetryDelays is how long a failed delivery waits before each new attempt, it's given up after the last one
+var RetryDelays = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour, 6 * time.Hour}
+
+// Signer is the key outgoing requests are signed with, the key of the local actor sending them
+type Signer struct {
+	KeyId         string
+	PrivateKeyPem string
+}
+
+type cachedActor struct {
+	actor     Actor
+	fetchedAt time.Time
+}
+
+type delivery struct {
+	inbox   string
+	body    []byte
+	signer  Signer
+	attempt int
+}
+
+// Client talks to other servers: it looks accounts up, fetches actors and delivers activities.
+// Deliveries are queued and retried in the background, they're lost if the server restarts
+type Client struct {
+	http *http.Client
+	// allowHTTP lets the client talk to servers without TLS, only meant for running instances locally
+	allowHTTP bool
+
+	mu     sync.Mutex
+	actors map[string]cachedActor
+
+	queue     chan delivery
+	stop      chan struct{}
+	stopOnce  sync.Once
+	workersWg sync.WaitGroup
+}
+
+func NewClient(allowHTTP bool) *Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowHTTP {
+		dialer.Control = refuseInternalAddress
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Through a proxy only the proxy's address would be checked
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &Client{
+		http:      &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		allowHTTP: allowHTTP,
+		actors:    map[string]cachedActor{},
+		queue:     make(chan delivery, deliveryQueueSize),
+		stop:      make(chan struct{}),
+	}
+}
+
+// refuseInternalAddress stops the client from reaching this machine or the network it runs in.
+// Actor urls come from unauthenticated requests, so without it anyone could make the server fetch internal services.
+// It runs for every connection after DNS resolution, so a public name resolving to a private address is refused too
+func refuseInternalAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid address %q", address)
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("address %s is not public", ip)
+	}
+
+	return nil
+}
+
+// checkURL only lets the client reach absolute https urls, or http ones when allowed
+func (c *Client) checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid url %q", rawURL)
+	}
+
+	if u.Scheme != "https" && !(c.allowHTTP && u.Scheme == "http") {
+		return fmt.Errorf("url %q is not https", rawURL)
+	}
+
+	return nil
+}
+
+func (c *Client) getJSON(rawURL string, accept string, v any) error {
+	if err := c.checkURL(rawURL); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", accept)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("error fetching %s: %v", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching %s: status %d", rawURL, resp.StatusCode)
+	}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+	if err != nil {
+		return fmt.Errorf("error decoding %s: %v", rawURL, err)
+	}
+
+	return nil
+}
+
+// WebFinger looks up an account written as user@host, a leading @ is allowed
+func (c *Client) WebFinger(account string) (WebFinger, error) {
+	account = strings.TrimPrefix(account, "@")
+	_, host, ok := strings.Cut(account, "@")
+	if !ok || host == "" {
+		return WebFinger{}, fmt.Errorf("account must look like user@host")
+	}
+
+	scheme := "https"
+	if c.allowHTTP {
+		scheme = "http"
+	}
+
+	wf := WebFinger{}
+	rawURL := fmt.Sprintf("%s://%s/.well-known/webfinger?resource=%s", scheme, host, url.QueryEscape("acct:"+account))
+	if err := c.getJSON(rawURL, "application/jrd+json, application/json", &wf); err != nil {
+		return WebFinger{}, err
+	}
+
+	return wf, nil
+}
+
+// FetchActor returns the actor, from the cache unless refresh is set.
+// The key id of a signature is the actor id with a fragment, which is dropped here
+func (c *Client) FetchActor(id string, refresh bool) (Actor, error) {
+	id, _, _ = strings.Cut(id, "#")
+
+	c.mu.Lock()
+	cached, ok := c.actors[id]
+	c.mu.Unlock()
+
+	if ok && !refresh && time.Since(cached.fetchedAt) < actorCacheTTL {
+		return cached.actor, nil
+	}
+
+	actor := Actor{}
+	if err := c.getJSON(id, ContentType+", "+LDContentType, &actor); err != nil {
+		return Actor{}, err
+	}
+
+	if actor.Id != id {
+		return Actor{}, fmt.Errorf("actor %s claims to be %s", id, actor.Id)
+	}
+
+	c.mu.Lock()
+	c.actors[id] = cachedActor{actor: actor, fetchedAt: time.Now()}
+	c.mu.Unlock()
+
+	return actor, nil
+}
+
+// VerifyRequest checks the request is signed by a key of actorId. The key is fetched again once
+// if the signature doesn't match, in case the actor rotated it since it was cached
+func (c *Client) VerifyRequest(r *http.Request, body []byte, actorId string) error {
+	sig, err := ParseSignature(r)
+	if err != nil {
+		return err
+	}
+
+	keyOwner, _, _ := strings.Cut(sig.KeyId, "#")
+	if keyOwner != actorId {
+		return fmt.Errorf("request is signed by %s, not by %s", sig.KeyId, actorId)
+	}
+
+	actor, err := c.FetchActor(keyOwner, false)
+	if err != nil {
+		return err
+	}
+
+	if err = verifyWithActor(r, body, sig, actor); err == nil {
+		return nil
+	}
+
+	actor, err = c.FetchActor(keyOwner, true)
+	if err != nil {
+		return err
+	}
+
+	return verifyWithActor(r, body, sig, actor)
+}
+
+func verifyWithActor(r *http.Request, body []byte, sig Signature, actor Actor) error {
+	if actor.PublicKey.Id != sig.KeyId || actor.PublicKey.Owner != actor.Id {
+		return fmt.Errorf("key %s doesn't belong to %s", sig.KeyId, actor.Id)
+	}
+
+	return VerifyRequest(r, body, sig, actor.PublicKey.PublicKeyPem)
+}
+
+// Post sends the activity to the inbox right away, signed by signer
+func (c *Client) Post(inbox string, activity Activity, signer Signer) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return fmt.Errorf("error marshalling activity: %v", err)
+	}
+
+	_, err = c.post(inbox, body, signer)
+	return err
+}
+
+// post returns true as well if the error is permanent and retrying won't help
+func (c *Client) post(inbox string, body []byte, signer Signer) (bool, error) {
+	if err := c.checkURL(inbox); err != nil {
+		return true, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Accept", ContentType)
+
+	if err := SignRequest(req, body, signer.KeyId, signer.PrivateKeyPem); err != nil {
+		return true, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("error delivering to %s: %v", inbox, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	// Client errors won't go away by trying again, apart from timeouts and rate limits
+	permanent := resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests
+
+	return permanent, fmt.Errorf("error delivering to %s: status %d", inbox, resp.StatusCode)
+}
+
+// Deliver queues the activity for every inbox, each inbox is only delivered to once
+func (c *Client) Deliver(activity Activity, inboxes []string, signer Signer) {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		log.Printf("Error marshalling activity: %s", err)
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, inbox := range inboxes {
+		if inbox == "" || seen[inbox] {
+			continue
+		}
+		seen[inbox] = true
+
+		c.enqueue(delivery{inbox: inbox, body: body, signer: signer})
+	}
+}
+
+func (c *Client) enqueue(d delivery) {
+	select {
+	case <-c.stop:
+	case c.queue <- d:
+	default:
+		log.Printf("Error delivering to %s: delivery queue is full", d.inbox)
+	}
+}
+
+// Run starts the delivery workers
+func (c *Client) Run(workers int) {
+	for i := 0; i < workers; i++ {
+		c.workersWg.Add(1)
+		go c.worker()
+	}
+}
+
+func (c *Client) worker() {
+	defer c.workersWg.Done()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case d := <-c.queue:
+			c.attempt(d)
+		}
+	}
+}
+
+func (c *Client) attempt(d delivery) {
+	permanent, err := c.post(d.inbox, d.body, d.signer)
+	if err == nil {
+		return
+	}
+
+	if permanent || d.attempt >= len(RetryDelays) {
+		log.Printf("Giving up delivery after %d attempts: %s", d.attempt+1, err)
+		return
+	}
+
+	delay := RetryDelays[d.attempt]
+	log.Printf("Delivery failed, retrying in %s: %s", delay, err)
+
+	d.attempt++
+	time.AfterFunc(delay, func() {
+		c.enqueue(d)
+	})
+}
+
+// Close stops the workers once their current delivery is done, queued and pending retries are dropped
+func (c *Client) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	c.workersWg.Wait()
+}
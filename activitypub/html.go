@@ -0,0 +1,25 @@
+package activitypub
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	lineBreakRe = regexp.MustCompile(`(?i)<br\s*/?>|</p>\s*<p[^>]*>`)
+	tagRe       = regexp.MustCompile(`<[^>]*>`)
+)
+
+// TextToHTML turns a chirp into the HTML content of a note
+func TextToHTML(text string) string {
+	return "<p>" + strings.ReplaceAll(html.EscapeString(text), "\n", "<br>") + "</p>"
+}
+
+// HTMLToText turns the HTML content of a remote note into plain text, so it never has to be rendered as HTML
+func HTMLToText(content string) string {
+	text := lineBreakRe.ReplaceAllString(content, "\n")
+	text = tagRe.ReplaceAllString(text, "")
+
+	return strings.TrimSpace(html.UnescapeString(text))
+}
@@ -0,0 +1,246 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HTTP Signatures as used across the fediverse (draft-cavage-http-signatures-12, rsa-sha256)
+
+// MaxClockSkew is how far the Date of a signed request may be from our clock
+const MaxClockSkew = time.Hour
+
+// GenerateKeyPair returns a new RSA key pair as PEM, public key first
+func GenerateKeyPair() (string, string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", fmt.Errorf("error generating key: %v", err)
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", fmt.Errorf("error marshalling public key: %v", err)
+	}
+
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", fmt.Errorf("error marshalling private key: %v", err)
+	}
+
+	publicPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})
+	privatePem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv})
+
+	return string(publicPem), string(privatePem), nil
+}
+
+func parsePrivateKey(privatePem string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privatePem))
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %v", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
+
+func parsePublicKey(publicPem string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicPem))
+	if block == nil {
+		return nil, fmt.Errorf("invalid public key")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %v", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
+
+// Digest returns the Digest header value for the body
+func Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// signingString builds the string that is signed from the listed headers of the request
+func signingString(r *http.Request, headers []string) (string, error) {
+	lines := make([]string, 0, len(headers))
+
+	for _, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, fmt.Sprintf("(request-target): %s %s", strings.ToLower(r.Method), r.URL.RequestURI()))
+		case "host":
+			host := r.Host
+			if host == "" {
+				host = r.URL.Host
+			}
+			lines = append(lines, "host: "+host)
+		default:
+			v := r.Header.Values(h)
+			if len(v) == 0 {
+				return "", fmt.Errorf("signed header %s is missing", h)
+			}
+			lines = append(lines, h+": "+strings.Join(v, ", "))
+		}
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// SignRequest sets the Date, Digest and Signature headers. body is nil for requests without one
+func SignRequest(r *http.Request, body []byte, keyId string, privatePem string) error {
+	key, err := parsePrivateKey(privatePem)
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers := []string{"(request-target)", "host", "date"}
+
+	if body != nil {
+		r.Header.Set("Digest", Digest(body))
+		headers = append(headers, "digest")
+	}
+
+	s, err := signingString(r, headers)
+	if err != nil {
+		return err
+	}
+
+	hashed := sha256.Sum256([]byte(s))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return fmt.Errorf("error signing request: %v", err)
+	}
+
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyId, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+
+	return nil
+}
+
+// Signature is a parsed Signature header
+type Signature struct {
+	KeyId     string
+	Algorithm string
+	Headers   []string
+	Signature []byte
+}
+
+// ParseSignature reads the Signature header of the request
+func ParseSignature(r *http.Request) (Signature, error) {
+	header := r.Header.Get("Signature")
+	if header == "" {
+		return Signature{}, fmt.Errorf("request is not signed")
+	}
+
+	sig := Signature{Headers: []string{"date"}}
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		v = strings.Trim(v, `"`)
+
+		switch k {
+		case "keyId":
+			sig.KeyId = v
+		case "algorithm":
+			sig.Algorithm = v
+		case "headers":
+			sig.Headers = strings.Fields(strings.ToLower(v))
+		case "signature":
+			dat, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return Signature{}, fmt.Errorf("invalid signature: %v", err)
+			}
+			sig.Signature = dat
+		}
+	}
+
+	if sig.KeyId == "" || len(sig.Signature) == 0 {
+		return Signature{}, fmt.Errorf("invalid signature header")
+	}
+
+	return sig, nil
+}
+
+// VerifyRequest checks the signature against the public key of sig.KeyId.
+// POST requests must sign their body through the Digest header, and every request must sign a recent Date
+func VerifyRequest(r *http.Request, body []byte, sig Signature, publicPem string) error {
+	if sig.Algorithm != "" && sig.Algorithm != "rsa-sha256" && sig.Algorithm != "hs2019" {
+		return fmt.Errorf("unsupported signature algorithm %s", sig.Algorithm)
+	}
+
+	required := []string{"(request-target)", "host", "date"}
+	if r.Method == http.MethodPost {
+		required = append(required, "digest")
+	}
+	for _, h := range required {
+		if !slices.Contains(sig.Headers, h) {
+			return fmt.Errorf("signature doesn't cover %s", h)
+		}
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("invalid Date header")
+	}
+	if skew := time.Since(date); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return fmt.Errorf("request date is too far from now")
+	}
+
+	if r.Method == http.MethodPost && r.Header.Get("Digest") != Digest(body) {
+		return fmt.Errorf("digest doesn't match the body")
+	}
+
+	key, err := parsePublicKey(publicPem)
+	if err != nil {
+		return err
+	}
+
+	s, err := signingString(r, sig.Headers)
+	if err != nil {
+		return err
+	}
+
+	hashed := sha256.Sum256([]byte(s))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig.Signature); err != nil {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package activitypub
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKeyId = "https://chirpy.example.com/users/1#main-key"
+
+func signedRequest(t *testing.T, body []byte, privatePem string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, "https://remote.example.com/users/2/inbox", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SignRequest(req, body, testKeyId, privatePem); err != nil {
+		t.Fatalf("SignRequest: %s", err)
+	}
+
+	return req
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	publicPem, privatePem, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublicPem, _, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"type":"Follow"}`)
+
+	tests := []struct {
+		name      string
+		tamper    func(r *http.Request) []byte
+		publicPem string
+		wantErr   string
+	}{
+		{
+			name:      "valid",
+			tamper:    func(r *http.Request) []byte { return body },
+			publicPem: publicPem,
+		},
+		{
+			name:      "tampered body",
+			tamper:    func(r *http.Request) []byte { return []byte(`{"type":"Undo"}`) },
+			publicPem: publicPem,
+			wantErr:   "digest doesn't match the body",
+		},
+		{
+			name: "tampered digest",
+			tamper: func(r *http.Request) []byte {
+				tampered := []byte(`{"type":"Undo"}`)
+				r.Header.Set("Digest", Digest(tampered))
+				return tampered
+			},
+			publicPem: publicPem,
+			wantErr:   "invalid signature",
+		},
+		{
+			name: "stale date",
+			tamper: func(r *http.Request) []byte {
+				r.Header.Set("Date", time.Now().Add(-2*time.Hour).UTC().Format(http.TimeFormat))
+				return body
+			},
+			publicPem: publicPem,
+			wantErr:   "request date is too far from now",
+		},
+		{
+			name: "changed date",
+			tamper: func(r *http.Request) []byte {
+				r.Header.Set("Date", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+				return body
+			},
+			publicPem: publicPem,
+			wantErr:   "invalid signature",
+		},
+		{
+			name:      "other key",
+			tamper:    func(r *http.Request) []byte { return body },
+			publicPem: otherPublicPem,
+			wantErr:   "invalid signature",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signedRequest(t, body, privatePem)
+			received := tt.tamper(req)
+
+			sig, err := ParseSignature(req)
+			if err != nil {
+				t.Fatalf("ParseSignature: %s", err)
+			}
+			if sig.KeyId != testKeyId {
+				t.Errorf("key id = %q, want %q", sig.KeyId, testKeyId)
+			}
+
+			err = VerifyRequest(req, received, sig, tt.publicPem)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("VerifyRequest: %s", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("VerifyRequest error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRequestRequiresDigest(t *testing.T) {
+	publicPem, privatePem, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed as if it had no body, so the Digest isn't covered
+	req := signedRequest(t, nil, privatePem)
+	sig, err := ParseSignature(req)
+	if err != nil {
+		t.Fatalf("ParseSignature: %s", err)
+	}
+
+	err = VerifyRequest(req, []byte(`{"type":"Follow"}`), sig, publicPem)
+	if err == nil || !strings.Contains(err.Error(), "doesn't cover digest") {
+		t.Fatalf("VerifyRequest error = %v, want the digest to be required", err)
+	}
+}
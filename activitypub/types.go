@@ -0,0 +1,181 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	ContentType = "application/activity+json"
+	// LDContentType is the other media type ActivityPub servers ask for and send
+	LDContentType = `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+	ActivityStreamsContext = "https://www.w3.org/ns/activitystreams"
+	SecurityContext        = "https://w3id.org/security/v1"
+	// Public is the special collection addressing an activity to everybody
+	Public = "https://www.w3.org/ns/activitystreams#Public"
+)
+
+const (
+	TypeFollow = "Follow"
+	TypeUndo   = "Undo"
+	TypeLike   = "Like"
+	TypeCreate = "Create"
+	TypeDelete = "Delete"
+	TypeAccept = "Accept"
+	TypeReject = "Reject"
+	TypeNote   = "Note"
+)
+
+type PublicKey struct {
+	Id           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+type Actor struct {
+	Context           any        `json:"@context,omitempty"`
+	Id                string     `json:"id"`
+	Type              string     `json:"type"`
+	PreferredUsername string     `json:"preferredUsername"`
+	Name              string     `json:"name,omitempty"`
+	Summary           string     `json:"summary,omitempty"`
+	Url               string     `json:"url,omitempty"`
+	Inbox             string     `json:"inbox"`
+	Outbox            string     `json:"outbox,omitempty"`
+	Followers         string     `json:"followers,omitempty"`
+	Following         string     `json:"following,omitempty"`
+	Endpoints         *Endpoints `json:"endpoints,omitempty"`
+	Icon              *Image     `json:"icon,omitempty"`
+	PublicKey         PublicKey  `json:"publicKey"`
+}
+
+type Image struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType,omitempty"`
+	Url       string `json:"url"`
+}
+
+// DeliveryInbox is the shared inbox of the actor's server if it has one, so a server gets each activity once
+func (a Actor) DeliveryInbox() string {
+	if a.Endpoints != nil && a.Endpoints.SharedInbox != "" {
+		return a.Endpoints.SharedInbox
+	}
+
+	return a.Inbox
+}
+
+type Note struct {
+	Context      any       `json:"@context,omitempty"`
+	Id           string    `json:"id"`
+	Type         string    `json:"type"`
+	AttributedTo string    `json:"attributedTo"`
+	Content      string    `json:"content"`
+	InReplyTo    string    `json:"inReplyTo,omitempty"`
+	Published    time.Time `json:"published"`
+	Url          string    `json:"url,omitempty"`
+	To           []string  `json:"to,omitempty"`
+	Cc           []string  `json:"cc,omitempty"`
+	Tag          []Tag     `json:"tag,omitempty"`
+}
+
+type Tag struct {
+	Type string `json:"type"`
+	Href string `json:"href,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// Activity is kept generic, Object is either an id or an embedded object depending on the sender
+type Activity struct {
+	Context   any             `json:"@context,omitempty"`
+	Id        string          `json:"id"`
+	Type      string          `json:"type"`
+	Actor     string          `json:"actor"`
+	Object    json.RawMessage `json:"object"`
+	To        []string        `json:"to,omitempty"`
+	Cc        []string        `json:"cc,omitempty"`
+	Published *time.Time      `json:"published,omitempty"`
+}
+
+// ObjectId returns the id of the activity's object, whether it was embedded or referenced
+func (a Activity) ObjectId() string {
+	var id string
+	if err := json.Unmarshal(a.Object, &id); err == nil {
+		return id
+	}
+
+	var obj struct {
+		Id string `json:"id"`
+	}
+	if err := json.Unmarshal(a.Object, &obj); err == nil {
+		return obj.Id
+	}
+
+	return ""
+}
+
+// EmbeddedActivity returns the object as an activity, for Undo and Accept that wrap another activity
+func (a Activity) EmbeddedActivity() (Activity, bool) {
+	inner := Activity{}
+	if err := json.Unmarshal(a.Object, &inner); err != nil || inner.Type == "" {
+		return Activity{}, false
+	}
+
+	return inner, true
+}
+
+// EmbeddedNote returns the object as a note, for Create
+func (a Activity) EmbeddedNote() (Note, bool) {
+	note := Note{}
+	if err := json.Unmarshal(a.Object, &note); err != nil || note.Type != TypeNote {
+		return Note{}, false
+	}
+
+	return note, true
+}
+
+// NewActivity wraps the object in an activity of the given type, object is marshalled as is
+func NewActivity(id string, activityType string, actor string, object any) (Activity, error) {
+	dat, err := json.Marshal(object)
+	if err != nil {
+		return Activity{}, err
+	}
+
+	return Activity{Context: ActivityStreamsContext, Id: id, Type: activityType, Actor: actor, Object: dat}, nil
+}
+
+type OrderedCollection struct {
+	Context      any    `json:"@context,omitempty"`
+	Id           string `json:"id"`
+	Type         string `json:"type"`
+	TotalItems   int    `json:"totalItems"`
+	OrderedItems []any  `json:"orderedItems"`
+}
+
+// WebFinger is the JRD document returned by /.well-known/webfinger
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases,omitempty"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href,omitempty"`
+}
+
+// ActorURL returns the link to the ActivityPub actor document
+func (wf WebFinger) ActorURL() string {
+	for _, l := range wf.Links {
+		if l.Rel == "self" && (l.Type == ContentType || l.Type == LDContentType) {
+			return l.Href
+		}
+	}
+
+	return ""
+}
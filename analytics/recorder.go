@@ -0,0 +1,134 @@
+package analytics
+
+import (
+	"chirpy/database"
+	"log"
+	"sync"
+	"time"
+)
+
+type viewKey struct {
+	chirpId int
+	viewer  string
+}
+
+// Recorder counts chirp views in memory and writes them to the database in batches,
+// so serving a chirp never writes the database file.
+// A viewer counts once per chirp per hour, and authors viewing their own chirps aren't counted
+type Recorder struct {
+	db *database.DB
+
+	mu sync.Mutex
+	// hour is the unix time of the hour seen belongs to, seen is reset once the hour is over
+	hour int64
+	seen map[viewKey]struct{}
+	// pending holds the views not flushed yet, keyed by chirp id then hour
+	pending map[int]map[int64]int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func NewRecorder(db *database.DB) *Recorder {
+	return &Recorder{
+		db:      db,
+		seen:    map[viewKey]struct{}{},
+		pending: map[int]map[int64]int{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// RecordViews counts a view of every chirp for the viewer, a user id or another key for anonymous viewers
+func (r *Recorder) RecordViews(viewer string, viewerId string, chirps ...database.Chirpy) {
+	if viewer == "" {
+		return
+	}
+
+	hour := time.Now().Truncate(time.Hour).Unix()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if hour != r.hour {
+		r.hour = hour
+		r.seen = map[viewKey]struct{}{}
+	}
+
+	for _, chirp := range chirps {
+		if viewerId != "" && chirp.UserId == viewerId {
+			continue
+		}
+
+		key := viewKey{chirpId: chirp.Id, viewer: viewer}
+		if _, ok := r.seen[key]; ok {
+			continue
+		}
+		r.seen[key] = struct{}{}
+
+		if r.pending[chirp.Id] == nil {
+			r.pending[chirp.Id] = map[int64]int{}
+		}
+		r.pending[chirp.Id][hour]++
+	}
+}
+
+// Flush writes the pending views in one database write, they're kept for the next flush if it fails
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = map[int]map[int64]int{}
+	r.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := r.db.AddChirpViews(pending)
+	if err == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	for chirpId, hours := range pending {
+		if r.pending[chirpId] == nil {
+			r.pending[chirpId] = map[int64]int{}
+		}
+		for hour, n := range hours {
+			r.pending[chirpId][hour] += n
+		}
+	}
+	r.mu.Unlock()
+
+	return err
+}
+
+// Run flushes every interval until Close is called
+func (r *Recorder) Run(interval time.Duration) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				log.Printf("Error flushing chirp views: %s", err)
+			}
+		}
+	}
+}
+
+// Close stops Run and flushes what is left
+func (r *Recorder) Close() error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+
+	return r.Flush()
+}
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Scopes a personal access token can be given, each route declares the ones it needs in main.go
+const (
+	ScopeChirpsRead         = "chirps:read"
+	ScopeChirpsWrite        = "chirps:write"
+	ScopeBookmarksRead      = "bookmarks:read"
+	ScopeBookmarksWrite     = "bookmarks:write"
+	ScopeProfileRead        = "profile:read"
+	ScopeProfileWrite       = "profile:write"
+	ScopeSocialRead         = "social:read"
+	ScopeSocialWrite        = "social:write"
+	ScopeMessagesRead       = "messages:read"
+	ScopeMessagesWrite      = "messages:write"
+	ScopeNotificationsRead  = "notifications:read"
+	ScopeNotificationsWrite = "notifications:write"
+)
+
+var Scopes = []string{
+	ScopeChirpsRead, ScopeChirpsWrite,
+	ScopeBookmarksRead, ScopeBookmarksWrite,
+	ScopeProfileRead, ScopeProfileWrite,
+	ScopeSocialRead, ScopeSocialWrite,
+	ScopeMessagesRead, ScopeMessagesWrite,
+	ScopeNotificationsRead, ScopeNotificationsWrite,
+}
+
+const (
+	// MaxPersonalAccessTokens is how many tokens a user can have at once
+	MaxPersonalAccessTokens = 50
+	// lastUsedPrecision keeps a busy bot from writing the database on every request
+	lastUsedPrecision = time.Minute
+)
+
+// PersonalAccessToken is a long-lived token a user creates for a bot or script, the token is only stored hashed
+type PersonalAccessToken struct {
+	Id         string     `json:"id"`
+	UserId     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"token_hash"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// IsExpired returns true once the token is past its expiry, tokens without one never expire
+func (t PersonalAccessToken) IsExpired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// CreatePersonalAccessToken stores a new token for the user, the handler validated the name and scopes
+func (db *DB) CreatePersonalAccessToken(userId string, name string, tokenHash string, scopes []string, expiresAt *time.Time) (PersonalAccessToken, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return PersonalAccessToken{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	if _, ok := dbstruct.Users[userId]; !ok {
+		return PersonalAccessToken{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	count := 0
+	for _, token := range dbstruct.PersonalAccessTokens {
+		if token.UserId == userId {
+			count++
+		}
+	}
+	if count >= MaxPersonalAccessTokens {
+		return PersonalAccessToken{}, http.StatusConflict, fmt.Errorf("you can't have more than %d tokens", MaxPersonalAccessTokens)
+	}
+
+	newId, err := uuid.NewRandom()
+	if err != nil {
+		return PersonalAccessToken{}, http.StatusInternalServerError, fmt.Errorf("error creating new ID: %v", err)
+	}
+
+	token := PersonalAccessToken{
+		Id:        newId.String(),
+		UserId:    userId,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+	}
+	dbstruct.PersonalAccessTokens[token.Id] = token
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return PersonalAccessToken{}, http.StatusInternalServerError, fmt.Errorf("error writing token: %v", err)
+	}
+
+	return token, http.StatusCreated, nil
+}
+
+// GetPersonalAccessTokens returns the user's tokens, newest first
+func (db *DB) GetPersonalAccessTokens(userId string) ([]PersonalAccessToken, int, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	tokens := make([]PersonalAccessToken, 0)
+	for _, token := range dbstruct.PersonalAccessTokens {
+		if token.UserId == userId {
+			tokens = append(tokens, token)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+
+	return tokens, http.StatusOK, nil
+}
+
+// GetPersonalAccessTokenByHash returns the token with the hash, unless it expired or its user is suspended
+func (db *DB) GetPersonalAccessTokenByHash(tokenHash string) (PersonalAccessToken, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return PersonalAccessToken{}, fmt.Errorf("error loading database: %v", err)
+	}
+
+	for _, token := range dbstruct.PersonalAccessTokens {
+		if token.TokenHash != tokenHash {
+			continue
+		}
+
+		if token.IsExpired() {
+			return PersonalAccessToken{}, fmt.Errorf("token has expired")
+		}
+
+		if dbstruct.Users[token.UserId].IsSuspended() {
+			return PersonalAccessToken{}, fmt.Errorf("account suspended")
+		}
+
+		return token, nil
+	}
+
+	return PersonalAccessToken{}, fmt.Errorf("token not found")
+}
+
+// TouchPersonalAccessToken records that the token was just used, to the minute
+func (db *DB) TouchPersonalAccessToken(tokenId string) error {
+	now := time.Now().UTC()
+
+	// Checked without the lock first, most requests have nothing to write
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+	if token, ok := dbstruct.PersonalAccessTokens[tokenId]; !ok || (token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < lastUsedPrecision) {
+		return nil
+	}
+
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err = db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	token, ok := dbstruct.PersonalAccessTokens[tokenId]
+	if !ok {
+		return nil
+	}
+
+	token.LastUsedAt = &now
+	dbstruct.PersonalAccessTokens[tokenId] = token
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return fmt.Errorf("error writing token: %v", err)
+	}
+
+	return nil
+}
+
+// RevokePersonalAccessToken deletes one of the user's tokens
+func (db *DB) RevokePersonalAccessToken(tokenId string, userId string) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	token, ok := dbstruct.PersonalAccessTokens[tokenId]
+	if !ok || token.UserId != userId {
+		return http.StatusNotFound, fmt.Errorf("token not found")
+	}
+
+	delete(dbstruct.PersonalAccessTokens, tokenId)
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing token: %v", err)
+	}
+
+	return http.StatusNoContent, nil
+}
@@ -0,0 +1,279 @@
+package database
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// DeletedUserId replaces the id of a deleted user in records kept for other users, like the messages they sent
+const DeletedUserId = "deleted"
+
+// DeletedUser is what DeleteUser removed, for the cleanup that happens outside the database
+type DeletedUser struct {
+	User            User
+	Chirps          []Chirpy
+	Exports         []DataExport
+	ActorKey        *ActorKey
+	RemoteFollowers []RemoteFollower
+	RemoteFollowing []RemoteFollowing
+}
+
+// anonymousId keys a record that has to stay distinct from the others once its user is deleted, like a poll vote
+func anonymousId() string {
+	return DeletedUserId + ":" + uuid.New().String()
+}
+
+// ScheduleUserDeletion deletes the user at the given time unless it's cancelled before
+func (db *DB) ScheduleUserDeletion(userId string, at time.Time) (User, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return User{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if user.DeletionScheduledAt != nil {
+		return user, http.StatusConflict, fmt.Errorf("account deletion is already scheduled")
+	}
+
+	at = at.UTC()
+	user.DeletionScheduledAt = &at
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	return user, http.StatusAccepted, nil
+}
+
+func (db *DB) CancelUserDeletion(userId string) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if user.DeletionScheduledAt == nil {
+		return http.StatusNotFound, fmt.Errorf("no account deletion is scheduled")
+	}
+
+	user.DeletionScheduledAt = nil
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	return http.StatusNoContent, nil
+}
+
+// GetUsersDueForDeletion returns the users whose grace period is over
+func (db *DB) GetUsersDueForDeletion(now time.Time) ([]string, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("error loading database: %v", err)
+	}
+
+	due := make([]string, 0)
+	for id, user := range dbstruct.Users {
+		if user.DeletionScheduledAt != nil && !user.DeletionScheduledAt.After(now) {
+			due = append(due, id)
+		}
+	}
+
+	return due, nil
+}
+
+// DeleteUser removes the user with their chirps, sessions and everything only they could see, in one write.
+// What other users keep, like poll votes, reports and messages in shared conversations, is anonymised instead
+func (db *DB) DeleteUser(userId string) (DeletedUser, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return DeletedUser{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return DeletedUser{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	deleted := DeletedUser{
+		User:            user,
+		Chirps:          make([]Chirpy, 0),
+		Exports:         make([]DataExport, 0),
+		RemoteFollowers: make([]RemoteFollower, 0),
+		RemoteFollowing: make([]RemoteFollowing, 0),
+	}
+
+	for id, chirp := range dbstruct.Chirps {
+		if chirp.UserId == userId {
+			deleted.Chirps = append(deleted.Chirps, chirp)
+			removeChirp(dbstruct, id)
+		}
+	}
+	sortChirps("asc", deleted.Chirps)
+
+	for token, refreshToken := range dbstruct.Tokens {
+		if refreshToken.UserId == userId {
+			delete(dbstruct.Tokens, token)
+		}
+	}
+
+	removeUserRelations(dbstruct, userId)
+	anonymiseUserActivity(dbstruct, userId)
+
+	delete(dbstruct.Timelines, userId)
+	delete(dbstruct.Bookmarks, userId)
+	delete(dbstruct.BookmarkFolders, userId)
+	delete(dbstruct.Notifications, userId)
+	delete(dbstruct.EmailVerifications, userId)
+	delete(dbstruct.PasswordResets, userId)
+	for id, token := range dbstruct.PersonalAccessTokens {
+		if token.UserId == userId {
+			delete(dbstruct.PersonalAccessTokens, id)
+		}
+	}
+
+	if key, ok := dbstruct.ActorKeys[userId]; ok {
+		deleted.ActorKey = &key
+	}
+	for _, follower := range dbstruct.RemoteFollowers[userId] {
+		deleted.RemoteFollowers = append(deleted.RemoteFollowers, follower)
+	}
+	for _, following := range dbstruct.RemoteFollowing[userId] {
+		deleted.RemoteFollowing = append(deleted.RemoteFollowing, following)
+	}
+	delete(dbstruct.ActorKeys, userId)
+	delete(dbstruct.RemoteFollowers, userId)
+	delete(dbstruct.RemoteFollowing, userId)
+	delete(dbstruct.RemoteNotes, userId)
+
+	for id, export := range dbstruct.DataExports {
+		if export.UserId == userId {
+			deleted.Exports = append(deleted.Exports, export)
+			delete(dbstruct.DataExports, id)
+		}
+	}
+
+	delete(dbstruct.Users, userId)
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return DeletedUser{}, http.StatusInternalServerError, fmt.Errorf("error deleting user: %v", err)
+	}
+
+	return deleted, http.StatusOK, nil
+}
+
+// removeUserRelations removes the user from both sides of follows, blocks and mutes
+func removeUserRelations(dbstruct DBStruct, userId string) {
+	for followeeId := range dbstruct.Follows[userId] {
+		delete(dbstruct.Followers[followeeId], userId)
+	}
+	for followerId := range dbstruct.Followers[userId] {
+		delete(dbstruct.Follows[followerId], userId)
+	}
+	delete(dbstruct.Follows, userId)
+	delete(dbstruct.Followers, userId)
+
+	delete(dbstruct.Blocks, userId)
+	delete(dbstruct.Mutes, userId)
+	for _, blocked := range dbstruct.Blocks {
+		delete(blocked, userId)
+	}
+	for _, muted := range dbstruct.Mutes {
+		delete(muted, userId)
+	}
+}
+
+// anonymiseUserActivity cuts the user out of the records other users still need
+func anonymiseUserActivity(dbstruct DBStruct, userId string) {
+	// Votes keep counting, so the results of a poll don't change after the fact
+	for _, votes := range dbstruct.PollVotes {
+		if option, ok := votes[userId]; ok {
+			delete(votes, userId)
+			votes[anonymousId()] = option
+		}
+	}
+
+	for convId, conv := range dbstruct.Conversations {
+		if !conv.isParticipant(userId) {
+			continue
+		}
+
+		conv.ParticipantIds = slices.DeleteFunc(conv.ParticipantIds, func(id string) bool {
+			return id == userId
+		})
+		delete(conv.ReadUpTo, userId)
+
+		if len(conv.ParticipantIds) == 0 {
+			delete(dbstruct.Conversations, convId)
+			delete(dbstruct.Messages, convId)
+			continue
+		}
+		dbstruct.Conversations[convId] = conv
+
+		for i, message := range dbstruct.Messages[convId] {
+			if message.SenderId == userId {
+				dbstruct.Messages[convId][i].SenderId = DeletedUserId
+			}
+		}
+	}
+
+	for recipientId, notifications := range dbstruct.Notifications {
+		kept := notifications[:0]
+		for _, n := range notifications {
+			n.ActorIds = slices.DeleteFunc(n.ActorIds, func(id string) bool {
+				return id == userId
+			})
+			if len(n.ActorIds) > 0 {
+				kept = append(kept, n)
+			}
+		}
+		dbstruct.Notifications[recipientId] = kept
+	}
+
+	// Moderation cases are kept for the record, without the chirp body or who reported it
+	for chirpId, c := range dbstruct.ModerationCases {
+		if c.AuthorId == userId {
+			c.AuthorId = DeletedUserId
+			c.ChirpBody = ""
+		}
+
+		if report, ok := c.Reports[userId]; ok {
+			delete(c.Reports, userId)
+			report.ReporterId = DeletedUserId
+			c.Reports[anonymousId()] = report
+		}
+
+		for i, action := range c.Actions {
+			if action.AdminId == userId {
+				c.Actions[i].AdminId = DeletedUserId
+			}
+		}
+
+		dbstruct.ModerationCases[chirpId] = c
+	}
+}
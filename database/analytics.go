@@ -0,0 +1,215 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// MaxAnalyticsHistory is how long view counts are kept, older hours are pruned when new views are added
+const MaxAnalyticsHistory = 90 * 24 * time.Hour
+
+// AnalyticsTotals counts impressions, reactions (likes) and replies
+type AnalyticsTotals struct {
+	Impressions int `json:"impressions"`
+	Reactions   int `json:"reactions"`
+	Replies     int `json:"replies"`
+}
+
+// AnalyticsBucket counts what happened to chirps during the bucket starting at Start
+type AnalyticsBucket struct {
+	Start time.Time `json:"start"`
+	AnalyticsTotals
+}
+
+// ChirpAnalytics is one chirp over the requested range. Votes is the poll's total, votes aren't timestamped
+type ChirpAnalytics struct {
+	ChirpId   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+	AnalyticsTotals
+	Votes   int               `json:"votes"`
+	Buckets []AnalyticsBucket `json:"buckets"`
+}
+
+// AuthorAnalytics sums every chirp of the author, Chirps is sorted by impressions, most first
+type AuthorAnalytics struct {
+	From    time.Time         `json:"from"`
+	To      time.Time         `json:"to"`
+	Totals  AnalyticsTotals   `json:"totals"`
+	Buckets []AnalyticsBucket `json:"buckets"`
+	Chirps  []ChirpAnalytics  `json:"chirps"`
+}
+
+// AddChirpViews adds the views counted since the last flush, keyed by chirp id then by the unix time of the hour.
+// Views of chirps deleted in the meantime are dropped
+func (db *DB) AddChirpViews(views map[int]map[int64]int) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	for chirpId, hours := range views {
+		if _, ok := dbstruct.Chirps[chirpId]; !ok {
+			continue
+		}
+
+		counts, ok := dbstruct.ChirpViews[chirpId]
+		if !ok {
+			counts = map[int64]int{}
+			dbstruct.ChirpViews[chirpId] = counts
+		}
+
+		for hour, n := range hours {
+			counts[hour] += n
+		}
+	}
+
+	cutoff := time.Now().Add(-MaxAnalyticsHistory).Unix()
+	for chirpId, counts := range dbstruct.ChirpViews {
+		for hour := range counts {
+			if hour < cutoff {
+				delete(counts, hour)
+			}
+		}
+
+		if len(counts) == 0 {
+			delete(dbstruct.ChirpViews, chirpId)
+		}
+	}
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return fmt.Errorf("error writing views: %v", err)
+	}
+
+	return nil
+}
+
+// chirpViewCount returns every view the chirp got that is still kept
+func chirpViewCount(dbstruct DBStruct, chirpId int) int {
+	total := 0
+	for _, n := range dbstruct.ChirpViews[chirpId] {
+		total += n
+	}
+
+	return total
+}
+
+// GetAuthorAnalytics returns impressions, reactions and replies of the user's chirps between from and to,
+// counted in buckets of bucketSize. Buckets are aligned to UTC, bucketSize is an hour or a day
+func (db *DB) GetAuthorAnalytics(userId string, from time.Time, to time.Time, bucketSize time.Duration) (AuthorAnalytics, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return AuthorAnalytics{}, fmt.Errorf("error loading database: %v", err)
+	}
+
+	from = from.UTC().Truncate(bucketSize)
+	to = to.UTC()
+
+	starts := make([]time.Time, 0)
+	for start := from; !start.After(to); start = start.Add(bucketSize) {
+		starts = append(starts, start)
+	}
+
+	newBuckets := func() []AnalyticsBucket {
+		buckets := make([]AnalyticsBucket, len(starts))
+		for i, start := range starts {
+			buckets[i].Start = start
+		}
+		return buckets
+	}
+
+	// bucketIndex returns -1 for times outside the range
+	bucketIndex := func(t time.Time) int {
+		t = t.UTC()
+		if t.Before(from) || t.After(to) {
+			return -1
+		}
+		return int(t.Sub(from) / bucketSize)
+	}
+
+	analytics := AuthorAnalytics{
+		From:    from,
+		To:      to,
+		Buckets: newBuckets(),
+		Chirps:  make([]ChirpAnalytics, 0),
+	}
+
+	byChirp := map[int]*ChirpAnalytics{}
+	for _, chirp := range dbstruct.Chirps {
+		if chirp.UserId != userId {
+			continue
+		}
+
+		analytics.Chirps = append(analytics.Chirps, ChirpAnalytics{
+			ChirpId:   chirp.Id,
+			CreatedAt: chirp.CreatedAt,
+			Votes:     len(dbstruct.PollVotes[chirp.Id]),
+			Buckets:   newBuckets(),
+		})
+	}
+	for i := range analytics.Chirps {
+		byChirp[analytics.Chirps[i].ChirpId] = &analytics.Chirps[i]
+	}
+
+	add := func(chirpId int, at time.Time, field func(*AnalyticsBucket) *int, n int) {
+		chirp, ok := byChirp[chirpId]
+		if !ok {
+			return
+		}
+
+		i := bucketIndex(at)
+		if i < 0 {
+			return
+		}
+
+		*field(&chirp.Buckets[i]) += n
+		*field(&analytics.Buckets[i]) += n
+	}
+
+	impressions := func(b *AnalyticsBucket) *int { return &b.Impressions }
+	reactions := func(b *AnalyticsBucket) *int { return &b.Reactions }
+	replies := func(b *AnalyticsBucket) *int { return &b.Replies }
+
+	for chirpId := range byChirp {
+		for hour, n := range dbstruct.ChirpViews[chirpId] {
+			add(chirpId, time.Unix(hour, 0), impressions, n)
+		}
+
+		for _, likedAt := range dbstruct.RemoteLikes[chirpId] {
+			add(chirpId, likedAt, reactions, 1)
+		}
+	}
+
+	for _, note := range dbstruct.RemoteNotes[userId] {
+		if note.InReplyToChirpId != 0 {
+			add(note.InReplyToChirpId, note.ReceivedAt, replies, 1)
+		}
+	}
+
+	for i := range analytics.Chirps {
+		chirp := &analytics.Chirps[i]
+		for _, bucket := range chirp.Buckets {
+			chirp.Impressions += bucket.Impressions
+			chirp.Reactions += bucket.Reactions
+			chirp.Replies += bucket.Replies
+		}
+
+		analytics.Totals.Impressions += chirp.Impressions
+		analytics.Totals.Reactions += chirp.Reactions
+		analytics.Totals.Replies += chirp.Replies
+	}
+
+	slices.SortFunc(analytics.Chirps, func(a, b ChirpAnalytics) int {
+		if c := cmp.Compare(b.Impressions, a.Impressions); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ChirpId, a.ChirpId)
+	})
+
+	return analytics, nil
+}
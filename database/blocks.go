@@ -0,0 +1,199 @@
+package database
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Blocks are mutual: neither user sees the other's chirps, and they can't follow each other.
+// Mutes are one-sided and only hide the muted user from the muter's timeline and chirp listing
+
+// UserListEntry is a user in a block or mute list, with the time they were added to it
+type UserListEntry struct {
+	UserId string    `json:"user_id"`
+	Since  time.Time `json:"since"`
+}
+
+// isBlockedEither returns true if either user blocked the other
+func isBlockedEither(dbstruct DBStruct, a string, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+
+	_, aBlockedB := dbstruct.Blocks[a][b]
+	_, bBlockedA := dbstruct.Blocks[b][a]
+	return aBlockedB || bBlockedA
+}
+
+// isMuted returns true if muterId muted userId
+func isMuted(dbstruct DBStruct, muterId string, userId string) bool {
+	_, ok := dbstruct.Mutes[muterId][userId]
+	return ok
+}
+
+// blockedEither returns the users who blocked userId or were blocked by them
+func blockedEither(dbstruct DBStruct, userId string) map[string]bool {
+	blocked := map[string]bool{}
+	for id := range dbstruct.Blocks[userId] {
+		blocked[id] = true
+	}
+
+	for blockerId, blocks := range dbstruct.Blocks {
+		if _, ok := blocks[userId]; ok {
+			blocked[blockerId] = true
+		}
+	}
+
+	return blocked
+}
+
+// GetBlockedEither returns the users blocked either way with userId, for checking one event
+// against many clients without loading the database for each of them
+func (db *DB) GetBlockedEither(userId string) (map[string]bool, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("error loading database: %v", err)
+	}
+
+	return blockedEither(dbstruct, userId), nil
+}
+
+// Block blocks the user and removes any follow between the two of them
+func (db *DB) Block(blockerId string, blockedId string) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	if blockerId == blockedId {
+		return http.StatusBadRequest, fmt.Errorf("you can't block yourself")
+	}
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	if _, ok := dbstruct.Users[blockedId]; !ok {
+		return http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if _, ok := dbstruct.Blocks[blockerId]; !ok {
+		dbstruct.Blocks[blockerId] = map[string]time.Time{}
+	}
+
+	if _, ok := dbstruct.Blocks[blockerId][blockedId]; !ok {
+		dbstruct.Blocks[blockerId][blockedId] = time.Now().UTC()
+	}
+
+	removeFollow(dbstruct, blockerId, blockedId)
+	removeFollow(dbstruct, blockedId, blockerId)
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing block: %v", err)
+	}
+
+	return http.StatusNoContent, nil
+}
+
+// Unblock removes the block, follows removed by the block are not restored
+func (db *DB) Unblock(blockerId string, blockedId string) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	delete(dbstruct.Blocks[blockerId], blockedId)
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return fmt.Errorf("error writing block: %v", err)
+	}
+
+	return nil
+}
+
+// Mute hides the user from the muter's timeline and chirp listing
+func (db *DB) Mute(muterId string, mutedId string) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	if muterId == mutedId {
+		return http.StatusBadRequest, fmt.Errorf("you can't mute yourself")
+	}
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	if _, ok := dbstruct.Users[mutedId]; !ok {
+		return http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if _, ok := dbstruct.Mutes[muterId]; !ok {
+		dbstruct.Mutes[muterId] = map[string]time.Time{}
+	}
+
+	if _, ok := dbstruct.Mutes[muterId][mutedId]; !ok {
+		dbstruct.Mutes[muterId][mutedId] = time.Now().UTC()
+	}
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing mute: %v", err)
+	}
+
+	return http.StatusNoContent, nil
+}
+
+func (db *DB) Unmute(muterId string, mutedId string) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	delete(dbstruct.Mutes[muterId], mutedId)
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return fmt.Errorf("error writing mute: %v", err)
+	}
+
+	return nil
+}
+
+// GetBlocks returns a page of the users blocked by userId, most recent first, and the total count
+func (db *DB) GetBlocks(userId string, offset int, limit int) ([]UserListEntry, int, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return []UserListEntry{}, 0, fmt.Errorf("error loading database: %v", err)
+	}
+
+	return pageUserListEntries(dbstruct.Blocks[userId], offset, limit), len(dbstruct.Blocks[userId]), nil
+}
+
+// GetMutes returns a page of the users muted by userId, most recent first, and the total count
+func (db *DB) GetMutes(userId string, offset int, limit int) ([]UserListEntry, int, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return []UserListEntry{}, 0, fmt.Errorf("error loading database: %v", err)
+	}
+
+	return pageUserListEntries(dbstruct.Mutes[userId], offset, limit), len(dbstruct.Mutes[userId]), nil
+}
+
+func pageUserListEntries(list map[string]time.Time, offset int, limit int) []UserListEntry {
+	entries := []UserListEntry{}
+	for _, id := range pageUserIds(list, offset, limit) {
+		entries = append(entries, UserListEntry{UserId: id, Since: list[id]})
+	}
+
+	return entries
+}
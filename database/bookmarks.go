@@ -0,0 +1,232 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// MaxBookmarkFolders is how many folders a Chirpy Red user can create
+const MaxBookmarkFolders = 50
+
+type Bookmark struct {
+	ChirpId   int       `json:"chirp_id"`
+	FolderId  string    `json:"folder_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BookmarkFolder struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BookmarkedChirp is a bookmark together with the chirp it points to
+type BookmarkedChirp struct {
+	Bookmark
+	Chirp Chirpy `json:"chirp"`
+}
+
+// AddBookmark bookmarks a chirp for the user, bookmarking it again moves it to the given folder.
+// Bookmarks are private, nothing about them is ever shown to the chirp author
+func (db *DB) AddBookmark(userId string, chirpId int, folderId string) (Bookmark, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return Bookmark{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	chirp, ok := dbstruct.Chirps[chirpId]
+	if !ok || !canView(dbstruct, chirp, userId) {
+		return Bookmark{}, http.StatusNotFound, fmt.Errorf("chirp with id %v not found", chirpId)
+	}
+
+	if folderId != "" {
+		if _, ok := dbstruct.BookmarkFolders[userId][folderId]; !ok {
+			return Bookmark{}, http.StatusNotFound, fmt.Errorf("bookmark folder not found")
+		}
+	}
+
+	if _, ok := dbstruct.Bookmarks[userId]; !ok {
+		dbstruct.Bookmarks[userId] = map[int]Bookmark{}
+	}
+
+	bookmark, ok := dbstruct.Bookmarks[userId][chirpId]
+	if !ok {
+		bookmark = Bookmark{ChirpId: chirpId, CreatedAt: time.Now().UTC()}
+	}
+
+	bookmark.FolderId = folderId
+	dbstruct.Bookmarks[userId][chirpId] = bookmark
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return Bookmark{}, http.StatusInternalServerError, fmt.Errorf("error writing bookmark: %v", err)
+	}
+
+	return bookmark, http.StatusCreated, nil
+}
+
+// RemoveBookmark removes the bookmark, removing a chirp that is not bookmarked is a no-op
+func (db *DB) RemoveBookmark(userId string, chirpId int) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	delete(dbstruct.Bookmarks[userId], chirpId)
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return fmt.Errorf("error writing bookmark: %v", err)
+	}
+
+	return nil
+}
+
+// GetBookmarks returns a page of the user's bookmarks, newest first, and the total number of bookmarks.
+// An empty folderId returns bookmarks from every folder
+func (db *DB) GetBookmarks(userId string, folderId string, offset int, limit int) ([]BookmarkedChirp, int, error) {
+	bookmarks := make([]BookmarkedChirp, 0)
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return bookmarks, 0, fmt.Errorf("error loading database: %v", err)
+	}
+
+	for _, v := range dbstruct.Bookmarks[userId] {
+		if folderId != "" && v.FolderId != folderId {
+			continue
+		}
+
+		// The chirp may have been deleted or hidden from the user since it was bookmarked
+		chirp, ok := dbstruct.Chirps[v.ChirpId]
+		if !ok || !canView(dbstruct, chirp, userId) {
+			continue
+		}
+
+		bookmarks = append(bookmarks, BookmarkedChirp{Bookmark: v, Chirp: viewChirp(dbstruct, chirp, userId)})
+	}
+
+	slices.SortFunc(bookmarks, func(a, b BookmarkedChirp) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ChirpId, a.ChirpId)
+	})
+
+	total := len(bookmarks)
+	if offset >= total {
+		return []BookmarkedChirp{}, total, nil
+	}
+
+	return bookmarks[offset:min(offset+limit, total)], total, nil
+}
+
+// CreateBookmarkFolder creates a new named folder, names are unique per user
+func (db *DB) CreateBookmarkFolder(userId string, name string) (BookmarkFolder, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return BookmarkFolder{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	folders, ok := dbstruct.BookmarkFolders[userId]
+	if !ok {
+		folders = map[string]BookmarkFolder{}
+		dbstruct.BookmarkFolders[userId] = folders
+	}
+
+	if len(folders) >= MaxBookmarkFolders {
+		return BookmarkFolder{}, http.StatusBadRequest, fmt.Errorf("you can create at most %d folders", MaxBookmarkFolders)
+	}
+
+	for _, v := range folders {
+		if v.Name == name {
+			return BookmarkFolder{}, http.StatusBadRequest, fmt.Errorf("folder %q already exists", name)
+		}
+	}
+
+	newId, err := uuid.NewRandom()
+	if err != nil {
+		return BookmarkFolder{}, http.StatusInternalServerError, fmt.Errorf("error creating new ID: %v", err)
+	}
+
+	folder := BookmarkFolder{Id: newId.String(), Name: name, CreatedAt: time.Now().UTC()}
+	folders[folder.Id] = folder
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return BookmarkFolder{}, http.StatusInternalServerError, fmt.Errorf("error writing bookmark folder: %v", err)
+	}
+
+	return folder, http.StatusCreated, nil
+}
+
+// GetBookmarkFolders returns the user's folders sorted by name
+func (db *DB) GetBookmarkFolders(userId string) ([]BookmarkFolder, error) {
+	folders := make([]BookmarkFolder, 0)
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return folders, fmt.Errorf("error loading database: %v", err)
+	}
+
+	for _, v := range dbstruct.BookmarkFolders[userId] {
+		folders = append(folders, v)
+	}
+
+	slices.SortFunc(folders, func(a, b BookmarkFolder) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return folders, nil
+}
+
+// DeleteBookmarkFolder deletes the folder, the bookmarks inside it are kept without a folder
+func (db *DB) DeleteBookmarkFolder(userId string, folderId string) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	if _, ok := dbstruct.BookmarkFolders[userId][folderId]; !ok {
+		return http.StatusNotFound, fmt.Errorf("bookmark folder not found")
+	}
+
+	delete(dbstruct.BookmarkFolders[userId], folderId)
+
+	for chirpId, v := range dbstruct.Bookmarks[userId] {
+		if v.FolderId == folderId {
+			v.FolderId = ""
+			dbstruct.Bookmarks[userId][chirpId] = v
+		}
+	}
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing bookmark folder: %v", err)
+	}
+
+	return http.StatusNoContent, nil
+}
+
+// removeDeletedChirpBookmarks drops every bookmark pointing to a deleted chirp
+func removeDeletedChirpBookmarks(dbstruct DBStruct, chirpId int) {
+	for _, bookmarks := range dbstruct.Bookmarks {
+		delete(bookmarks, chirpId)
+	}
+}
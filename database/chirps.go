@@ -3,44 +3,173 @@ package database
 import (
 	"cmp"
 	"fmt"
+	"net/http"
 	"slices"
-	"sync"
+	"time"
+)
+
+const (
+	VisibilityPublic    = "public"
+	VisibilityFollowers = "followers"
+	VisibilityPrivate   = "private"
 )
 
 type Chirpy struct {
-	Id     int    `json:"id"`
-	Body   string `json:"body"`
-	UserId string `json:"user_id"`
+	Id         int    `json:"id"`
+	Body       string `json:"body"`
+	UserId     string `json:"user_id"`
+	Visibility string `json:"visibility"`
+	Poll       *Poll  `json:"poll,omitempty"`
+	// CreatedAt is zero for chirps created before it was recorded
+	CreatedAt time.Time `json:"created_at"`
+	// Views is filled in when the chirp is served, views not flushed yet aren't counted
+	Views int `json:"views"`
+	// Moderation is the verdict of the moderation pipeline, nil if nothing matched
+	Moderation *ModerationVerdict `json:"moderation,omitempty"`
 }
 
-// CreateChirps creates a new chirp and saves it to disk
-func (db *DB) CreateChirps(body string, userId string) (Chirpy, error) {
-	mu := new(sync.Mutex)
-	mu.Lock()
-	defer mu.Unlock()
+const (
+	ModerationMasked = "mask"
+	ModerationHeld   = "hold"
+	// ModerationHidden is set by an admin, or automatically once enough users report the chirp
+	ModerationHidden = "hidden"
+	// ModerationApproved is set when an admin dismisses the reports on a held or hidden chirp
+	ModerationApproved = "approved"
+)
+
+type ModerationVerdict struct {
+	Action  string    `json:"action"`
+	Filters []string  `json:"filters"`
+	At      time.Time `json:"moderated_at"`
+}
 
+// IsValidVisibility returns true if v is one of the supported visibility levels
+func IsValidVisibility(v string) bool {
+	return v == VisibilityPublic || v == VisibilityFollowers || v == VisibilityPrivate
+}
+
+// canView returns true if the viewer is allowed to see the chirp,
+// viewerId is empty for anonymous requests
+func canView(dbstruct DBStruct, chirp Chirpy, viewerId string) bool {
+	if viewerId != "" && chirp.UserId == viewerId {
+		return true
+	}
+
+	// Chirps held for review or hidden by moderation are only shown to their author
+	if isModerationHidden(chirp) {
+		return false
+	}
+
+	if isBlockedEither(dbstruct, viewerId, chirp.UserId) {
+		return false
+	}
+
+	switch chirp.Visibility {
+	case VisibilityPublic, "":
+		return true
+	case VisibilityFollowers:
+		return viewerId != "" && isFollowing(dbstruct, viewerId, chirp.UserId)
+	default:
+		return false
+	}
+}
+
+// ChirpAudience is a snapshot of who may see a chirp, taken when an event about the chirp is published.
+// Every connected client is checked against it without loading the database again
+type ChirpAudience struct {
+	chirp     Chirpy
+	hidden    bool
+	blocked   map[string]bool
+	followers map[string]bool
+}
+
+// GetChirpAudience takes the snapshot of who may see the chirp right now
+func (db *DB) GetChirpAudience(chirp Chirpy) (ChirpAudience, error) {
 	dbstruct, err := db.loadDB()
 	if err != nil {
-		return Chirpy{}, fmt.Errorf("error loading database: %v", err)
+		return ChirpAudience{}, fmt.Errorf("error loading database: %v", err)
+	}
+
+	audience := ChirpAudience{
+		chirp:     chirp,
+		hidden:    isModerationHidden(chirp),
+		blocked:   blockedEither(dbstruct, chirp.UserId),
+		followers: map[string]bool{},
+	}
+	if chirp.Visibility == VisibilityFollowers {
+		for id := range dbstruct.Followers[chirp.UserId] {
+			audience.followers[id] = true
+		}
+	}
+
+	return audience, nil
+}
+
+// CanView follows the same rules as canView, against the snapshot
+func (a ChirpAudience) CanView(viewerId string) bool {
+	if viewerId != "" && a.chirp.UserId == viewerId {
+		return true
+	}
+
+	if a.hidden || a.blocked[viewerId] {
+		return false
+	}
+
+	switch a.chirp.Visibility {
+	case VisibilityPublic, "":
+		return true
+	case VisibilityFollowers:
+		return viewerId != "" && a.followers[viewerId]
+	default:
+		return false
+	}
+}
+
+// CreateChirps creates a new chirp and saves it to disk, poll and verdict are nil if the chirp has none.
+// The users with the mentioned handles who can see the chirp are notified, their ids are returned
+func (db *DB) CreateChirps(body string, userId string, visibility string, poll *Poll, verdict *ModerationVerdict, mentions []string) (Chirpy, []string, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return Chirpy{}, nil, fmt.Errorf("error loading database: %v", err)
 	}
 
 	newId := dbstruct.Id
 	dbstruct.Id += 1
 
-	chirpy := Chirpy{Id: newId, Body: body, UserId: userId}
+	chirpy := Chirpy{Id: newId, Body: body, UserId: userId, Visibility: visibility, Poll: poll, CreatedAt: time.Now().UTC(), Moderation: verdict}
 
 	dbstruct.Chirps[newId] = chirpy
+	fanOutChirp(dbstruct, chirpy)
+
+	// Chirps held by the moderation pipeline go straight to the admin queue
+	if isModerationHidden(chirpy) {
+		openModerationCase(dbstruct, chirpy)
+	}
+
+	mentioned := []string{}
+	for _, handle := range mentions {
+		user, ok := findUserByHandle(dbstruct, handle)
+		if !ok || user.Id == userId || !canView(dbstruct, chirpy, user.Id) {
+			continue
+		}
+
+		notify(dbstruct, user.Id, NotificationMention, userId, chirpy.Id)
+		mentioned = append(mentioned, user.Id)
+	}
+
 	if err = db.writeDB(dbstruct); err != nil {
-		return Chirpy{}, fmt.Errorf("error writing chirps: %v", err)
+		return Chirpy{}, nil, fmt.Errorf("error writing chirps: %v", err)
 	}
 
-	return chirpy, nil
+	return chirpy, mentioned, nil
 }
 
 func (db *DB) DeleteChirpy(chirpyId int) error {
-	mu := new(sync.Mutex)
-	mu.Lock()
-	defer mu.Unlock()
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
 
 	dbstruct, err := db.loadDB()
 	if err != nil {
@@ -48,7 +177,7 @@ func (db *DB) DeleteChirpy(chirpyId int) error {
 	}
 
 	// Authorization is in the damn handler, I don't give a fuck right now
-	delete(dbstruct.Chirps, chirpyId)
+	removeChirp(dbstruct, chirpyId)
 
 	if err = db.writeDB(dbstruct); err != nil {
 		return fmt.Errorf("error writing chirps: %v", err)
@@ -57,6 +186,20 @@ func (db *DB) DeleteChirpy(chirpyId int) error {
 	return nil
 }
 
+// removeChirp deletes the chirp and everything that points to it from the loaded database
+func removeChirp(dbstruct DBStruct, chirpyId int) {
+	if chirp, ok := dbstruct.Chirps[chirpyId]; ok {
+		unpinDeletedChirp(dbstruct, chirp)
+	}
+
+	removeDeletedChirpBookmarks(dbstruct, chirpyId)
+	delete(dbstruct.PollVotes, chirpyId)
+	delete(dbstruct.RemoteLikes, chirpyId)
+	delete(dbstruct.ChirpViews, chirpyId)
+
+	delete(dbstruct.Chirps, chirpyId)
+}
+
 func sortChirps(method string, slice []Chirpy) {
 	// Reminders that slices are passed by reference
 	if method == "asc" || method == "" {
@@ -70,12 +213,8 @@ func sortChirps(method string, slice []Chirpy) {
 	}
 }
 
-// loadAndFilterChirps returns all chirps in the database and filer by option
-func (db *DB) loadAndFilterChirps(method string, filterFunc func(Chirpy) bool) ([]Chirpy, error) {
-	mu := new(sync.RWMutex)
-	mu.RLock()
-	defer mu.RUnlock()
-
+// loadAndFilterChirps returns all chirps visible to the viewer and filer by option
+func (db *DB) loadAndFilterChirps(method string, viewerId string, filterFunc func(DBStruct, Chirpy) bool) ([]Chirpy, error) {
 	sliceChirps := make([]Chirpy, 0)
 
 	dbstruct, err := db.loadDB()
@@ -84,8 +223,8 @@ func (db *DB) loadAndFilterChirps(method string, filterFunc func(Chirpy) bool) (
 	}
 
 	for _, v := range dbstruct.Chirps {
-		if filterFunc(v) {
-			sliceChirps = append(sliceChirps, v)
+		if canView(dbstruct, v, viewerId) && filterFunc(dbstruct, v) {
+			sliceChirps = append(sliceChirps, viewChirp(dbstruct, v, viewerId))
 		}
 	}
 
@@ -94,23 +233,20 @@ func (db *DB) loadAndFilterChirps(method string, filterFunc func(Chirpy) bool) (
 	return sliceChirps, nil
 }
 
-func (db *DB) GetChirps(method string) ([]Chirpy, error) {
-	return db.loadAndFilterChirps(method, func(_ Chirpy) bool {
-		return true
+// GetChirps returns every chirp the viewer can see, leaving out users they muted
+func (db *DB) GetChirps(method string, viewerId string) ([]Chirpy, error) {
+	return db.loadAndFilterChirps(method, viewerId, func(dbstruct DBStruct, chirp Chirpy) bool {
+		return !isMuted(dbstruct, viewerId, chirp.UserId)
 	})
 }
 
-func (db *DB) GetChirpByAuthor(id string, method string) ([]Chirpy, error) {
-	return db.loadAndFilterChirps(method, func(chirp Chirpy) bool {
+func (db *DB) GetChirpByAuthor(id string, method string, viewerId string) ([]Chirpy, error) {
+	return db.loadAndFilterChirps(method, viewerId, func(_ DBStruct, chirp Chirpy) bool {
 		return chirp.UserId == id
 	})
 }
 
 func (db *DB) GetChirp(id int) (Chirpy, error) {
-	mu := new(sync.RWMutex)
-	mu.RLock()
-	defer mu.RUnlock()
-
 	dbstruct, err := db.loadDB()
 	if err != nil {
 		return Chirpy{}, fmt.Errorf("error loading database: %v", err)
@@ -122,3 +258,19 @@ func (db *DB) GetChirp(id int) (Chirpy, error) {
 
 	return dbstruct.Chirps[id], nil
 }
+
+// GetVisibleChirp returns the chirp only if the viewer is allowed to see it.
+// Hidden chirps are reported as not found, so their existence is not leaked
+func (db *DB) GetVisibleChirp(id int, viewerId string) (Chirpy, int, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return Chirpy{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	chirp, ok := dbstruct.Chirps[id]
+	if !ok || !canView(dbstruct, chirp, viewerId) {
+		return Chirpy{}, http.StatusNotFound, fmt.Errorf("chirp with id %v not found", id)
+	}
+
+	return viewChirp(dbstruct, chirp, viewerId), http.StatusOK, nil
+}
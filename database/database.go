@@ -5,11 +5,16 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
 )
 
 type DB struct {
 	path string
-	mu   *sync.RWMutex
+	// mu guards the file itself, so a read never sees a half written file
+	mu *sync.RWMutex
+	// txMu serializes every load-modify-write, otherwise two concurrent writers
+	// both load the same state and the last write silently drops the other one
+	txMu *sync.Mutex
 }
 
 // ChirpyCounter To generate the correct chirpyId
@@ -22,14 +27,90 @@ type DBStruct struct {
 	Chirps map[int]Chirpy          `json:"chirps"`
 	Users  map[string]User         `json:"users"`
 	Tokens map[string]RefreshToken `json:"refresh_tokens"`
+	// Follows maps a follower id to the set of user ids they follow,
+	// Followers is the same graph keyed by the followed user
+	Follows   map[string]map[string]time.Time `json:"follows"`
+	Followers map[string]map[string]time.Time `json:"followers"`
+	// Blocks and Mutes map a user id to the set of users they blocked or muted
+	Blocks map[string]map[string]time.Time `json:"blocks"`
+	Mutes  map[string]map[string]time.Time `json:"mutes"`
+	// Timelines maps a user id to the chirp ids of their home timeline, see timeline.go
+	Timelines map[string][]int `json:"timelines"`
+	// Bookmarks and BookmarkFolders are keyed by the id of the user who owns them
+	Bookmarks       map[string]map[int]Bookmark          `json:"bookmarks"`
+	BookmarkFolders map[string]map[string]BookmarkFolder `json:"bookmark_folders"`
+	// PollVotes maps a chirp id to the option each user voted for
+	PollVotes map[int]map[string]int `json:"poll_votes"`
+	// Conversations are keyed by conversation id, Messages holds each conversation's messages in order
+	Conversations map[string]Conversation `json:"conversations"`
+	Messages      map[string][]Message    `json:"messages"`
+	// Notifications maps a user id to their notifications, oldest first
+	Notifications map[string][]Notification `json:"notifications"`
+	// ModerationCases are keyed by the reported chirp id
+	ModerationCases map[int]ModerationCase `json:"moderation_cases"`
+	// ActorKeys, RemoteFollowers, RemoteFollowing and RemoteNotes are keyed by local user id, see federation.go
+	ActorKeys       map[string]ActorKey                   `json:"actor_keys"`
+	RemoteFollowers map[string]map[string]RemoteFollower  `json:"remote_followers"`
+	RemoteFollowing map[string]map[string]RemoteFollowing `json:"remote_following"`
+	RemoteNotes     map[string][]RemoteNote               `json:"remote_notes"`
+	// RemoteLikes maps a chirp id to the remote actors who liked it
+	RemoteLikes map[int]map[string]time.Time `json:"remote_likes"`
+	// ChirpViews maps a chirp id to its views per hour, keyed by the unix time the hour starts
+	ChirpViews map[int]map[int64]int `json:"chirp_views"`
+	// DataExports are keyed by export id, see exports.go
+	DataExports map[string]DataExport `json:"data_exports"`
+	// EmailVerifications are keyed by user id, see verifications.go
+	EmailVerifications map[string]EmailVerification `json:"email_verifications"`
+	// PasswordResets are keyed by user id, see password_resets.go
+	PasswordResets map[string]PasswordReset `json:"password_resets"`
+	// PersonalAccessTokens are keyed by token id, see access_tokens.go
+	PersonalAccessTokens map[string]PersonalAccessToken `json:"personal_access_tokens"`
 	ChirpyCounter
 }
 
+// newDBStruct returns an empty database with every map initialized
+func newDBStruct() DBStruct {
+	return DBStruct{
+		Chirps:               map[int]Chirpy{},
+		Users:                map[string]User{},
+		Tokens:               map[string]RefreshToken{},
+		Follows:              map[string]map[string]time.Time{},
+		Followers:            map[string]map[string]time.Time{},
+		Timelines:            map[string][]int{},
+		Blocks:               map[string]map[string]time.Time{},
+		Mutes:                map[string]map[string]time.Time{},
+		Bookmarks:            map[string]map[int]Bookmark{},
+		BookmarkFolders:      map[string]map[string]BookmarkFolder{},
+		PollVotes:            map[int]map[string]int{},
+		ModerationCases:      map[int]ModerationCase{},
+		Conversations:        map[string]Conversation{},
+		Messages:             map[string][]Message{},
+		Notifications:        map[string][]Notification{},
+		ActorKeys:            map[string]ActorKey{},
+		RemoteFollowers:      map[string]map[string]RemoteFollower{},
+		RemoteFollowing:      map[string]map[string]RemoteFollowing{},
+		RemoteNotes:          map[string][]RemoteNote{},
+		RemoteLikes:          map[int]map[string]time.Time{},
+		ChirpViews:           map[int]map[int64]int{},
+		DataExports:          map[string]DataExport{},
+		EmailVerifications:   map[string]EmailVerification{},
+		PasswordResets:       map[string]PasswordReset{},
+		PersonalAccessTokens: map[string]PersonalAccessToken{},
+		ChirpyCounter:        ChirpyCounter{Id: 1},
+	}
+}
+
 // NewDB creates a new database connection
 func NewDB() (*DB, error) {
+	return NewDBAt("database.json")
+}
+
+// NewDBAt creates a new database connection to the file at path
+func NewDBAt(path string) (*DB, error) {
 	db := DB{
-		path: "database.json",
+		path: path,
 		mu:   &sync.RWMutex{},
+		txMu: &sync.Mutex{},
 	}
 
 	// Check if the JSON file exists; otherwise create a new JSON file
@@ -49,12 +130,7 @@ func (db *DB) ensureDB() error {
 			return fmt.Errorf("error creating database file: %v", err)
 		}
 
-		dbstruct := DBStruct{
-			Chirps:        map[int]Chirpy{},
-			Users:         map[string]User{},
-			Tokens:        map[string]RefreshToken{},
-			ChirpyCounter: ChirpyCounter{Id: 1},
-		}
+		dbstruct := newDBStruct()
 
 		err = db.writeDB(dbstruct)
 		if err != nil {
@@ -89,12 +165,7 @@ func (db *DB) loadDB() (DBStruct, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	dbstruct := DBStruct{
-		Chirps:        map[int]Chirpy{},
-		Users:         map[string]User{},
-		Tokens:        map[string]RefreshToken{},
-		ChirpyCounter: ChirpyCounter{Id: 1},
-	}
+	dbstruct := newDBStruct()
 
 	dat, err := os.ReadFile(db.path)
 	if err != nil {
@@ -106,5 +177,13 @@ func (db *DB) loadDB() (DBStruct, error) {
 		return dbstruct, fmt.Errorf("error unmarshalling json: %v", err)
 	}
 
+	// Chirps written before visibility existed are public
+	for id, chirp := range dbstruct.Chirps {
+		if chirp.Visibility == "" {
+			chirp.Visibility = VisibilityPublic
+			dbstruct.Chirps[id] = chirp
+		}
+	}
+
 	return dbstruct, nil
 }
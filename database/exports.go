@@ -0,0 +1,339 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// DataExport is a request for a copy of everything stored about a user, the archive is built in the background
+type DataExport struct {
+	Id          string     `json:"id"`
+	UserId      string     `json:"user_id"`
+	Status      string     `json:"status"`
+	RequestedAt time.Time  `json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// File is where the archive is stored once it's ready
+	File  string `json:"file,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// UserData is what goes into an export. It's built from the stored records field by field,
+// so secrets like the password hash and refresh tokens can't end up in it by accident
+type UserData struct {
+	Profile         ExportedProfile       `json:"profile"`
+	Chirps          []Chirpy              `json:"chirps"`
+	PollVotes       []ExportedVote        `json:"poll_votes"`
+	Bookmarks       []Bookmark            `json:"bookmarks"`
+	BookmarkFolders []BookmarkFolder      `json:"bookmark_folders"`
+	Sessions        []ExportedSession     `json:"sessions"`
+	AccessTokens    []ExportedAccessToken `json:"access_tokens"`
+	Following       []ExportedRelation    `json:"following"`
+	Followers       []ExportedRelation    `json:"followers"`
+	Blocks          []ExportedRelation    `json:"blocks"`
+	Mutes           []ExportedRelation    `json:"mutes"`
+}
+
+type ExportedProfile struct {
+	Id            string `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Profile
+	IsChirpyRed             bool            `json:"is_chirpy_red"`
+	PinnedChirps            []int           `json:"pinned_chirps"`
+	SuspendedUntil          *time.Time      `json:"suspended_until,omitempty"`
+	NotificationPreferences map[string]bool `json:"notification_preferences"`
+	DeletionScheduledAt     *time.Time      `json:"deletion_scheduled_at,omitempty"`
+	TwoFactorEnabled        bool            `json:"two_factor_enabled"`
+	Role                    string          `json:"role"`
+}
+
+type ExportedVote struct {
+	ChirpId int `json:"chirp_id"`
+	Option  int `json:"option"`
+}
+
+// ExportedSession describes a refresh token without the token itself
+type ExportedSession struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ExportedAccessToken describes a personal access token without its hash
+type ExportedAccessToken struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type ExportedRelation struct {
+	UserId string    `json:"user_id"`
+	Since  time.Time `json:"since"`
+}
+
+// CreateDataExport queues an export for the user, only one can be pending at a time
+func (db *DB) CreateDataExport(userId string) (DataExport, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return DataExport{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	if _, ok := dbstruct.Users[userId]; !ok {
+		return DataExport{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	for _, export := range dbstruct.DataExports {
+		if export.UserId == userId && export.Status == ExportPending {
+			return DataExport{}, http.StatusConflict, fmt.Errorf("an export is already being prepared")
+		}
+	}
+
+	export := DataExport{
+		Id:          uuid.New().String(),
+		UserId:      userId,
+		Status:      ExportPending,
+		RequestedAt: time.Now().UTC(),
+	}
+	dbstruct.DataExports[export.Id] = export
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return DataExport{}, http.StatusInternalServerError, fmt.Errorf("error writing export: %v", err)
+	}
+
+	return export, http.StatusAccepted, nil
+}
+
+// GetDataExport returns one of the user's exports, other users' exports are not found
+func (db *DB) GetDataExport(exportId string, userId string) (DataExport, int, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return DataExport{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	export, ok := dbstruct.DataExports[exportId]
+	if !ok || export.UserId != userId {
+		return DataExport{}, http.StatusNotFound, fmt.Errorf("export not found")
+	}
+
+	return export, http.StatusOK, nil
+}
+
+// GetReadyDataExport returns the export if its archive can be downloaded
+func (db *DB) GetReadyDataExport(exportId string) (DataExport, int, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return DataExport{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	export, ok := dbstruct.DataExports[exportId]
+	if !ok || export.Status != ExportReady {
+		return DataExport{}, http.StatusNotFound, fmt.Errorf("export not found")
+	}
+
+	return export, http.StatusOK, nil
+}
+
+// GetPendingDataExports returns the exports still to be built, oldest first
+func (db *DB) GetPendingDataExports() ([]DataExport, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("error loading database: %v", err)
+	}
+
+	pending := make([]DataExport, 0)
+	for _, export := range dbstruct.DataExports {
+		if export.Status == ExportPending {
+			pending = append(pending, export)
+		}
+	}
+
+	slices.SortFunc(pending, func(a, b DataExport) int {
+		return a.RequestedAt.Compare(b.RequestedAt)
+	})
+
+	return pending, nil
+}
+
+// FinishDataExport marks the export ready with the archive at file, or failed if exportErr is set
+func (db *DB) FinishDataExport(exportId string, file string, exportErr error) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	export, ok := dbstruct.DataExports[exportId]
+	if !ok {
+		return fmt.Errorf("export not found")
+	}
+
+	now := time.Now().UTC()
+	export.CompletedAt = &now
+	if exportErr != nil {
+		export.Status = ExportFailed
+		export.Error = exportErr.Error()
+	} else {
+		export.Status = ExportReady
+		export.File = file
+	}
+	dbstruct.DataExports[exportId] = export
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return fmt.Errorf("error writing export: %v", err)
+	}
+
+	return nil
+}
+
+// RemoveDataExportsBefore removes the exports completed before the time, and returns them so their archives can be deleted
+func (db *DB) RemoveDataExportsBefore(before time.Time) ([]DataExport, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("error loading database: %v", err)
+	}
+
+	removed := make([]DataExport, 0)
+	for id, export := range dbstruct.DataExports {
+		if export.CompletedAt != nil && export.CompletedAt.Before(before) {
+			removed = append(removed, export)
+			delete(dbstruct.DataExports, id)
+		}
+	}
+
+	if len(removed) == 0 {
+		return removed, nil
+	}
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return nil, fmt.Errorf("error writing exports: %v", err)
+	}
+
+	return removed, nil
+}
+
+// relations turns a set of user ids with the time each was added into a list, oldest first
+func relations(set map[string]time.Time) []ExportedRelation {
+	list := make([]ExportedRelation, 0, len(set))
+	for userId, since := range set {
+		list = append(list, ExportedRelation{UserId: userId, Since: since})
+	}
+
+	slices.SortFunc(list, func(a, b ExportedRelation) int {
+		return a.Since.Compare(b.Since)
+	})
+
+	return list
+}
+
+// GetUserData collects everything the user is entitled to get a copy of
+func (db *DB) GetUserData(userId string) (UserData, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return UserData{}, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return UserData{}, fmt.Errorf("user not found")
+	}
+
+	data := UserData{
+		Profile: ExportedProfile{
+			Id:                      user.Id,
+			Email:                   user.Email,
+			EmailVerified:           user.EmailVerified,
+			Profile:                 user.Profile,
+			IsChirpyRed:             user.IsChirpyRed,
+			PinnedChirps:            append([]int{}, user.PinnedChirps...),
+			SuspendedUntil:          user.SuspendedUntil,
+			NotificationPreferences: user.AllNotificationPreferences(),
+			DeletionScheduledAt:     user.DeletionScheduledAt,
+			TwoFactorEnabled:        user.HasTwoFactor(),
+			Role:                    user.RoleName(),
+		},
+		Chirps:          make([]Chirpy, 0),
+		PollVotes:       make([]ExportedVote, 0),
+		Bookmarks:       make([]Bookmark, 0),
+		BookmarkFolders: make([]BookmarkFolder, 0),
+		Sessions:        make([]ExportedSession, 0),
+		AccessTokens:    make([]ExportedAccessToken, 0),
+		Following:       relations(dbstruct.Follows[userId]),
+		Followers:       relations(dbstruct.Followers[userId]),
+		Blocks:          relations(dbstruct.Blocks[userId]),
+		Mutes:           relations(dbstruct.Mutes[userId]),
+	}
+
+	for _, chirp := range dbstruct.Chirps {
+		if chirp.UserId == userId {
+			data.Chirps = append(data.Chirps, viewChirp(dbstruct, chirp, userId))
+		}
+	}
+	sortChirps("asc", data.Chirps)
+
+	for chirpId, votes := range dbstruct.PollVotes {
+		if option, ok := votes[userId]; ok {
+			data.PollVotes = append(data.PollVotes, ExportedVote{ChirpId: chirpId, Option: option})
+		}
+	}
+	slices.SortFunc(data.PollVotes, func(a, b ExportedVote) int {
+		return cmp.Compare(a.ChirpId, b.ChirpId)
+	})
+
+	for _, bookmark := range dbstruct.Bookmarks[userId] {
+		data.Bookmarks = append(data.Bookmarks, bookmark)
+	}
+	slices.SortFunc(data.Bookmarks, func(a, b Bookmark) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	for _, folder := range dbstruct.BookmarkFolders[userId] {
+		data.BookmarkFolders = append(data.BookmarkFolders, folder)
+	}
+	slices.SortFunc(data.BookmarkFolders, func(a, b BookmarkFolder) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	for _, token := range dbstruct.Tokens {
+		if token.UserId == userId {
+			data.Sessions = append(data.Sessions, ExportedSession{ExpiresAt: token.ExpireAt})
+		}
+	}
+
+	for _, token := range dbstruct.PersonalAccessTokens {
+		if token.UserId == userId {
+			data.AccessTokens = append(data.AccessTokens, ExportedAccessToken{
+				Name:       token.Name,
+				Scopes:     append([]string{}, token.Scopes...),
+				CreatedAt:  token.CreatedAt,
+				ExpiresAt:  token.ExpiresAt,
+				LastUsedAt: token.LastUsedAt,
+			})
+		}
+	}
+	slices.SortFunc(data.AccessTokens, func(a, b ExportedAccessToken) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return data, nil
+}
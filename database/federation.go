@@ -0,0 +1,391 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Remote actors live on other ActivityPub servers and are known by their actor id, a url.
+// They never become users, the federation maps below are kept apart from the local graph
+
+// MaxRemoteNotes is how many notes from remote actors are kept per user, the oldest are dropped
+const MaxRemoteNotes = 500
+
+// ActorKey is the key pair a user signs their ActivityPub requests with
+type ActorKey struct {
+	PublicKeyPem  string `json:"public_key_pem"`
+	PrivateKeyPem string `json:"private_key_pem"`
+}
+
+// RemoteFollower is a remote actor following a local user
+type RemoteFollower struct {
+	ActorId    string    `json:"actor_id"`
+	Inbox      string    `json:"inbox"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// RemoteFollowing is a remote actor a local user follows, Accepted once the remote server accepted the follow
+type RemoteFollowing struct {
+	ActorId string `json:"actor_id"`
+	Account string `json:"account"`
+	Inbox   string `json:"inbox"`
+	// FollowId is the id of the Follow activity, an Undo has to refer to it
+	FollowId    string    `json:"follow_id"`
+	Accepted    bool      `json:"accepted"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// RemoteNote is a note a remote actor sent to a local user, Content is plain text
+type RemoteNote struct {
+	Id      string `json:"id"`
+	ActorId string `json:"actor_id"`
+	Content string `json:"content"`
+	Url     string `json:"url,omitempty"`
+	// InReplyToChirpId is set if the note replies to a chirp of this server
+	InReplyToChirpId int       `json:"in_reply_to_chirp_id,omitempty"`
+	Published        time.Time `json:"published"`
+	ReceivedAt       time.Time `json:"received_at"`
+}
+
+// GetActorKey returns the user's key pair, false if none was created yet
+func (db *DB) GetActorKey(userId string) (ActorKey, bool, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return ActorKey{}, false, fmt.Errorf("error loading database: %v", err)
+	}
+
+	key, ok := dbstruct.ActorKeys[userId]
+	return key, ok, nil
+}
+
+// StoreActorKey saves the key pair unless the user already has one, the key in use is returned
+func (db *DB) StoreActorKey(userId string, key ActorKey) (ActorKey, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return ActorKey{}, fmt.Errorf("error loading database: %v", err)
+	}
+
+	if existing, ok := dbstruct.ActorKeys[userId]; ok {
+		return existing, nil
+	}
+
+	dbstruct.ActorKeys[userId] = key
+
+	if err = db.writeDB(dbstruct); err != nil {
+		return ActorKey{}, fmt.Errorf("error writing actor key: %v", err)
+	}
+
+	return key, nil
+}
+
+// AddRemoteFollower records a remote actor following the user, following again is not an error
+func (db *DB) AddRemoteFollower(userId string, follower RemoteFollower) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	if _, ok := dbstruct.Users[userId]; !ok {
+		return http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if dbstruct.RemoteFollowers[userId] == nil {
+		dbstruct.RemoteFollowers[userId] = map[string]RemoteFollower{}
+	}
+
+	_, already := dbstruct.RemoteFollowers[userId][follower.ActorId]
+	dbstruct.RemoteFollowers[userId][follower.ActorId] = follower
+
+	if !already {
+		notify(dbstruct, userId, NotificationFollow, follower.ActorId, 0)
+	}
+
+	if err = db.writeDB(dbstruct); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing remote follower: %v", err)
+	}
+
+	return http.StatusOK, nil
+}
+
+func (db *DB) RemoveRemoteFollower(userId string, actorId string) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	delete(dbstruct.RemoteFollowers[userId], actorId)
+
+	if err = db.writeDB(dbstruct); err != nil {
+		return fmt.Errorf("error writing remote follower: %v", err)
+	}
+
+	return nil
+}
+
+// GetRemoteFollowers returns the user's remote followers, oldest first
+func (db *DB) GetRemoteFollowers(userId string) ([]RemoteFollower, error) {
+	followers := make([]RemoteFollower, 0)
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return followers, fmt.Errorf("error loading database: %v", err)
+	}
+
+	for _, f := range dbstruct.RemoteFollowers[userId] {
+		followers = append(followers, f)
+	}
+
+	slices.SortFunc(followers, func(a, b RemoteFollower) int {
+		if c := a.FollowedAt.Compare(b.FollowedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ActorId, b.ActorId)
+	})
+
+	return followers, nil
+}
+
+// AddRemoteFollowing records a follow request the user sent to a remote actor
+func (db *DB) AddRemoteFollowing(userId string, following RemoteFollowing) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	if dbstruct.RemoteFollowing[userId] == nil {
+		dbstruct.RemoteFollowing[userId] = map[string]RemoteFollowing{}
+	}
+	dbstruct.RemoteFollowing[userId][following.ActorId] = following
+
+	if err = db.writeDB(dbstruct); err != nil {
+		return fmt.Errorf("error writing remote following: %v", err)
+	}
+
+	return nil
+}
+
+// AnswerRemoteFollowing marks the follow accepted, or forgets it if the remote actor rejected it.
+// followId is the Follow activity being answered, empty if the answer only named the actor
+func (db *DB) AnswerRemoteFollowing(userId string, actorId string, followId string, accepted bool) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	following, ok := dbstruct.RemoteFollowing[userId][actorId]
+	if !ok || (followId != "" && followId != following.FollowId) {
+		return fmt.Errorf("no follow request to %s", actorId)
+	}
+
+	if accepted {
+		following.Accepted = true
+		dbstruct.RemoteFollowing[userId][actorId] = following
+	} else {
+		delete(dbstruct.RemoteFollowing[userId], actorId)
+	}
+
+	if err = db.writeDB(dbstruct); err != nil {
+		return fmt.Errorf("error writing remote following: %v", err)
+	}
+
+	return nil
+}
+
+// RemoveRemoteFollowing forgets the follow and returns it, so the remote server can be told to undo it
+func (db *DB) RemoveRemoteFollowing(userId string, actorId string) (RemoteFollowing, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return RemoteFollowing{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	following, ok := dbstruct.RemoteFollowing[userId][actorId]
+	if !ok {
+		return RemoteFollowing{}, http.StatusNotFound, fmt.Errorf("you don't follow %s", actorId)
+	}
+	delete(dbstruct.RemoteFollowing[userId], actorId)
+
+	if err = db.writeDB(dbstruct); err != nil {
+		return RemoteFollowing{}, http.StatusInternalServerError, fmt.Errorf("error writing remote following: %v", err)
+	}
+
+	return following, http.StatusOK, nil
+}
+
+// GetRemoteFollowing returns the remote actors the user follows or asked to follow, most recent first
+func (db *DB) GetRemoteFollowing(userId string) ([]RemoteFollowing, error) {
+	following := make([]RemoteFollowing, 0)
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return following, fmt.Errorf("error loading database: %v", err)
+	}
+
+	for _, f := range dbstruct.RemoteFollowing[userId] {
+		following = append(following, f)
+	}
+
+	slices.SortFunc(following, func(a, b RemoteFollowing) int {
+		if c := b.RequestedAt.Compare(a.RequestedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ActorId, b.ActorId)
+	})
+
+	return following, nil
+}
+
+// AddRemoteLike records a remote actor liking a public chirp
+func (db *DB) AddRemoteLike(chirpId int, actorId string) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	// Remote actors are anonymous as far as visibility goes
+	chirp, ok := dbstruct.Chirps[chirpId]
+	if !ok || !canView(dbstruct, chirp, "") {
+		return http.StatusNotFound, fmt.Errorf("chirp with id %v not found", chirpId)
+	}
+
+	if dbstruct.RemoteLikes[chirpId] == nil {
+		dbstruct.RemoteLikes[chirpId] = map[string]time.Time{}
+	}
+
+	if _, already := dbstruct.RemoteLikes[chirpId][actorId]; !already {
+		dbstruct.RemoteLikes[chirpId][actorId] = time.Now().UTC()
+		notify(dbstruct, chirp.UserId, NotificationLike, actorId, chirpId)
+	}
+
+	if err = db.writeDB(dbstruct); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing remote like: %v", err)
+	}
+
+	return http.StatusOK, nil
+}
+
+func (db *DB) RemoveRemoteLike(chirpId int, actorId string) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	delete(dbstruct.RemoteLikes[chirpId], actorId)
+	if len(dbstruct.RemoteLikes[chirpId]) == 0 {
+		delete(dbstruct.RemoteLikes, chirpId)
+	}
+
+	if err = db.writeDB(dbstruct); err != nil {
+		return fmt.Errorf("error writing remote like: %v", err)
+	}
+
+	return nil
+}
+
+// IsFollowingRemote returns true if the user follows the remote actor, or asked to
+func (db *DB) IsFollowingRemote(userId string, actorId string) (bool, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return false, fmt.Errorf("error loading database: %v", err)
+	}
+
+	_, ok := dbstruct.RemoteFollowing[userId][actorId]
+	return ok, nil
+}
+
+// AddRemoteNote stores a note delivered to the user, a note delivered twice is only kept once.
+// A note replying to one of the user's chirps notifies them
+func (db *DB) AddRemoteNote(userId string, note RemoteNote) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	notes := dbstruct.RemoteNotes[userId]
+	if slices.ContainsFunc(notes, func(n RemoteNote) bool { return n.Id == note.Id }) {
+		return nil
+	}
+
+	notes = append(notes, note)
+	if len(notes) > MaxRemoteNotes {
+		notes = notes[len(notes)-MaxRemoteNotes:]
+	}
+	dbstruct.RemoteNotes[userId] = notes
+
+	if chirp, ok := dbstruct.Chirps[note.InReplyToChirpId]; ok && chirp.UserId == userId {
+		notify(dbstruct, userId, NotificationReply, note.ActorId, chirp.Id)
+	}
+
+	if err = db.writeDB(dbstruct); err != nil {
+		return fmt.Errorf("error writing remote note: %v", err)
+	}
+
+	return nil
+}
+
+// RemoveRemoteNote forgets a note its author deleted, actorId must be the author
+func (db *DB) RemoveRemoteNote(userId string, noteId string, actorId string) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	dbstruct.RemoteNotes[userId] = slices.DeleteFunc(dbstruct.RemoteNotes[userId], func(n RemoteNote) bool {
+		return n.Id == noteId && n.ActorId == actorId
+	})
+
+	if err = db.writeDB(dbstruct); err != nil {
+		return fmt.Errorf("error writing remote note: %v", err)
+	}
+
+	return nil
+}
+
+// GetRemoteNotes returns a page of the notes delivered to the user, newest first, and how many there are
+func (db *DB) GetRemoteNotes(userId string, offset int, limit int) ([]RemoteNote, int, error) {
+	page := make([]RemoteNote, 0, limit)
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return page, 0, fmt.Errorf("error loading database: %v", err)
+	}
+
+	notes := dbstruct.RemoteNotes[userId]
+	for i := len(notes) - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, notes[i])
+	}
+
+	return page, len(notes), nil
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+type FollowEntry struct {
+	UserId     string    `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// isFollowing returns true if followerId follows followeeId
+func isFollowing(dbstruct DBStruct, followerId string, followeeId string) bool {
+	following, ok := dbstruct.Follows[followerId]
+	if !ok {
+		return false
+	}
+
+	_, ok = following[followeeId]
+	return ok
+}
+
+// Follow makes followerId follow followeeId and copies the followee's recent chirps into the follower's timeline
+func (db *DB) Follow(followerId string, followeeId string) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	if followerId == followeeId {
+		return http.StatusBadRequest, fmt.Errorf("you can't follow yourself")
+	}
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	if _, ok := dbstruct.Users[followeeId]; !ok {
+		return http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if isBlockedEither(dbstruct, followerId, followeeId) {
+		return http.StatusForbidden, fmt.Errorf("you can't follow this user")
+	}
+
+	if isFollowing(dbstruct, followerId, followeeId) {
+		return http.StatusNoContent, nil
+	}
+
+	now := time.Now().UTC()
+	if _, ok := dbstruct.Follows[followerId]; !ok {
+		dbstruct.Follows[followerId] = map[string]time.Time{}
+	}
+	dbstruct.Follows[followerId][followeeId] = now
+
+	if _, ok := dbstruct.Followers[followeeId]; !ok {
+		dbstruct.Followers[followeeId] = map[string]time.Time{}
+	}
+	dbstruct.Followers[followeeId][followerId] = now
+
+	backfillTimeline(dbstruct, followerId, followeeId)
+	notify(dbstruct, followeeId, NotificationFollow, followerId, 0)
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing follow: %v", err)
+	}
+
+	return http.StatusNoContent, nil
+}
+
+// Unfollow removes the follow and the followee's chirps from the follower's timeline, it's a no-op if not following
+func (db *DB) Unfollow(followerId string, followeeId string) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return fmt.Errorf("error loading database: %v", err)
+	}
+
+	if !isFollowing(dbstruct, followerId, followeeId) {
+		return nil
+	}
+
+	removeFollow(dbstruct, followerId, followeeId)
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return fmt.Errorf("error writing follow: %v", err)
+	}
+
+	return nil
+}
+
+// removeFollow deletes the follow from both directions of the graph and cleans the follower's timeline
+func removeFollow(dbstruct DBStruct, followerId string, followeeId string) {
+	delete(dbstruct.Follows[followerId], followeeId)
+	delete(dbstruct.Followers[followeeId], followerId)
+
+	// Without a timeline there is nothing to clean, it gets built from the follows that are left
+	timeline, ok := dbstruct.Timelines[followerId]
+	if !ok {
+		return
+	}
+
+	dbstruct.Timelines[followerId] = slices.DeleteFunc(timeline, func(id int) bool {
+		chirp, ok := dbstruct.Chirps[id]
+		return ok && chirp.UserId == followeeId
+	})
+}
+
+// GetFollowers returns a page of the users following userId, most recent first, and the total count
+func (db *DB) GetFollowers(userId string, offset int, limit int) ([]FollowEntry, int, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return []FollowEntry{}, 0, fmt.Errorf("error loading database: %v", err)
+	}
+
+	return pageFollowEntries(dbstruct.Followers[userId], offset, limit), len(dbstruct.Followers[userId]), nil
+}
+
+// GetFollowing returns a page of the users userId follows, most recent first, and the total count
+func (db *DB) GetFollowing(userId string, offset int, limit int) ([]FollowEntry, int, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return []FollowEntry{}, 0, fmt.Errorf("error loading database: %v", err)
+	}
+
+	return pageFollowEntries(dbstruct.Follows[userId], offset, limit), len(dbstruct.Follows[userId]), nil
+}
+
+func pageFollowEntries(follows map[string]time.Time, offset int, limit int) []FollowEntry {
+	entries := []FollowEntry{}
+	for _, id := range pageUserIds(follows, offset, limit) {
+		entries = append(entries, FollowEntry{UserId: id, FollowedAt: follows[id]})
+	}
+
+	return entries
+}
+
+// pageUserIds returns a page of the user ids in a follow, block or mute list, most recently added first
+func pageUserIds(list map[string]time.Time, offset int, limit int) []string {
+	ids := make([]string, 0, len(list))
+	for id := range list {
+		ids = append(ids, id)
+	}
+
+	slices.SortFunc(ids, func(a, b string) int {
+		if c := list[b].Compare(list[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+
+	if offset >= len(ids) {
+		return []string{}
+	}
+
+	return ids[offset:min(offset+limit, len(ids))]
+}
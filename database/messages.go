@@ -0,0 +1,295 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// MaxConversationParticipants is the size limit of a group conversation, creator included
+const MaxConversationParticipants = 8
+
+// Conversation is a private one-to-one or small group conversation.
+// Messages are stored per conversation in DBStruct.Messages, apart from chirps
+type Conversation struct {
+	Id             string   `json:"id"`
+	ParticipantIds []string `json:"participant_ids"`
+	// ReadUpTo is the id of the last message each participant has read
+	ReadUpTo      map[string]int `json:"read_up_to"`
+	LastMessageId int            `json:"last_message_id"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+type Message struct {
+	Id        int       `json:"id"`
+	SenderId  string    `json:"sender_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ConversationSummary is a conversation as listed for one participant
+type ConversationSummary struct {
+	Conversation
+	LastMessage *Message `json:"last_message,omitempty"`
+	UnreadCount int      `json:"unread_count"`
+}
+
+func (c Conversation) isParticipant(userId string) bool {
+	return slices.Contains(c.ParticipantIds, userId)
+}
+
+func (c Conversation) isGroup() bool {
+	return len(c.ParticipantIds) > 2
+}
+
+// canMessage returns true if nobody in the conversation has a block with the sender.
+// Blocks in a group conversation only stop the two users involved from messaging each other directly
+func canMessage(dbstruct DBStruct, c Conversation, senderId string) bool {
+	if c.isGroup() {
+		return true
+	}
+
+	for _, id := range c.ParticipantIds {
+		if id != senderId && isBlockedEither(dbstruct, senderId, id) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// unreadCount counts the messages from others the user hasn't read yet
+func unreadCount(dbstruct DBStruct, c Conversation, userId string) int {
+	count := 0
+	for _, m := range dbstruct.Messages[c.Id] {
+		if m.Id > c.ReadUpTo[userId] && m.SenderId != userId && !isBlockedEither(dbstruct, userId, m.SenderId) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// CreateConversation starts a conversation between the creator and the other participants.
+// Starting a one-to-one conversation that already exists returns the existing one
+func (db *DB) CreateConversation(creatorId string, otherIds []string) (Conversation, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return Conversation{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	participants := []string{creatorId}
+	for _, id := range otherIds {
+		if id == creatorId || slices.Contains(participants, id) {
+			continue
+		}
+
+		if _, ok := dbstruct.Users[id]; !ok {
+			return Conversation{}, http.StatusNotFound, fmt.Errorf("user %s not found", id)
+		}
+
+		if isBlockedEither(dbstruct, creatorId, id) {
+			return Conversation{}, http.StatusForbidden, fmt.Errorf("you can't message user %s", id)
+		}
+
+		participants = append(participants, id)
+	}
+
+	if len(participants) < 2 {
+		return Conversation{}, http.StatusBadRequest, fmt.Errorf("a conversation needs at least one other participant")
+	}
+
+	if len(participants) > MaxConversationParticipants {
+		return Conversation{}, http.StatusBadRequest, fmt.Errorf("a conversation can have at most %d participants", MaxConversationParticipants)
+	}
+
+	if len(participants) == 2 {
+		for _, c := range dbstruct.Conversations {
+			if !c.isGroup() && c.isParticipant(participants[0]) && c.isParticipant(participants[1]) {
+				return c, http.StatusOK, nil
+			}
+		}
+	}
+
+	newId, err := uuid.NewRandom()
+	if err != nil {
+		return Conversation{}, http.StatusInternalServerError, fmt.Errorf("error creating new ID: %v", err)
+	}
+
+	now := time.Now().UTC()
+	conversation := Conversation{
+		Id:             newId.String(),
+		ParticipantIds: participants,
+		ReadUpTo:       map[string]int{},
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	dbstruct.Conversations[conversation.Id] = conversation
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return Conversation{}, http.StatusInternalServerError, fmt.Errorf("error writing conversation: %v", err)
+	}
+
+	return conversation, http.StatusCreated, nil
+}
+
+// SendMessage adds a message to the conversation, sending also marks the conversation read for the sender
+func (db *DB) SendMessage(conversationId string, senderId string, body string) (Message, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return Message{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	c, ok := dbstruct.Conversations[conversationId]
+	if !ok || !c.isParticipant(senderId) {
+		return Message{}, http.StatusNotFound, fmt.Errorf("conversation not found")
+	}
+
+	if !canMessage(dbstruct, c, senderId) {
+		return Message{}, http.StatusForbidden, fmt.Errorf("you can't message this user")
+	}
+
+	now := time.Now().UTC()
+	message := Message{Id: c.LastMessageId + 1, SenderId: senderId, Body: body, CreatedAt: now}
+	dbstruct.Messages[conversationId] = append(dbstruct.Messages[conversationId], message)
+
+	c.LastMessageId = message.Id
+	c.ReadUpTo[senderId] = message.Id
+	c.UpdatedAt = now
+	dbstruct.Conversations[conversationId] = c
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return Message{}, http.StatusInternalServerError, fmt.Errorf("error writing message: %v", err)
+	}
+
+	return message, http.StatusCreated, nil
+}
+
+// GetConversation returns the conversation if the user takes part in it
+func (db *DB) GetConversation(conversationId string, userId string) (Conversation, int, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return Conversation{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	c, ok := dbstruct.Conversations[conversationId]
+	if !ok || !c.isParticipant(userId) {
+		return Conversation{}, http.StatusNotFound, fmt.Errorf("conversation not found")
+	}
+
+	return c, http.StatusOK, nil
+}
+
+// GetConversations returns the user's conversations, most recently active first
+func (db *DB) GetConversations(userId string) ([]ConversationSummary, error) {
+	summaries := make([]ConversationSummary, 0)
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return summaries, fmt.Errorf("error loading database: %v", err)
+	}
+
+	for _, c := range dbstruct.Conversations {
+		if !c.isParticipant(userId) {
+			continue
+		}
+
+		summary := ConversationSummary{Conversation: c, UnreadCount: unreadCount(dbstruct, c, userId)}
+
+		messages := dbstruct.Messages[c.Id]
+		for i := len(messages) - 1; i >= 0; i-- {
+			if !isBlockedEither(dbstruct, userId, messages[i].SenderId) {
+				summary.LastMessage = &messages[i]
+				break
+			}
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	slices.SortFunc(summaries, func(a, b ConversationSummary) int {
+		if c := b.UpdatedAt.Compare(a.UpdatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Id, b.Id)
+	})
+
+	return summaries, nil
+}
+
+// GetMessages returns up to limit messages older than before, newest first, 0 starts from the newest.
+// Messages from users blocked either way are left out of the history
+func (db *DB) GetMessages(conversationId string, userId string, before int, limit int) ([]Message, int, error) {
+	messages := make([]Message, 0, limit)
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return messages, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	c, ok := dbstruct.Conversations[conversationId]
+	if !ok || !c.isParticipant(userId) {
+		return messages, http.StatusNotFound, fmt.Errorf("conversation not found")
+	}
+
+	history := dbstruct.Messages[conversationId]
+	for i := len(history) - 1; i >= 0 && len(messages) < limit; i-- {
+		m := history[i]
+		if before > 0 && m.Id >= before {
+			continue
+		}
+
+		if m.SenderId != userId && isBlockedEither(dbstruct, userId, m.SenderId) {
+			continue
+		}
+
+		messages = append(messages, m)
+	}
+
+	return messages, http.StatusOK, nil
+}
+
+// MarkConversationRead marks every message up to messageId as read, 0 marks the whole conversation read.
+// Read receipts never move backwards
+func (db *DB) MarkConversationRead(conversationId string, userId string, messageId int) (Conversation, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return Conversation{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	c, ok := dbstruct.Conversations[conversationId]
+	if !ok || !c.isParticipant(userId) {
+		return Conversation{}, http.StatusNotFound, fmt.Errorf("conversation not found")
+	}
+
+	if messageId <= 0 || messageId > c.LastMessageId {
+		messageId = c.LastMessageId
+	}
+
+	if messageId > c.ReadUpTo[userId] {
+		c.ReadUpTo[userId] = messageId
+		dbstruct.Conversations[conversationId] = c
+
+		err = db.writeDB(dbstruct)
+		if err != nil {
+			return Conversation{}, http.StatusInternalServerError, fmt.Errorf("error writing read receipt: %v", err)
+		}
+	}
+
+	return c, http.StatusOK, nil
+}
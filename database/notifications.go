@@ -0,0 +1,221 @@
+package database
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	NotificationReply   = "reply"
+	NotificationLike    = "like"
+	NotificationMention = "mention"
+	NotificationFollow  = "follow"
+)
+
+var NotificationTypes = []string{NotificationReply, NotificationLike, NotificationMention, NotificationFollow}
+
+const (
+	// MaxNotifications is how many notifications are kept per user, the oldest are dropped
+	MaxNotifications = 500
+	// notificationGroupWindow is how long an unread notification keeps absorbing similar ones
+	notificationGroupWindow = 24 * time.Hour
+)
+
+// Notification can group several actors doing the same thing, e.g. five people liking the same chirp
+type Notification struct {
+	Id       string   `json:"id"`
+	Type     string   `json:"type"`
+	ActorIds []string `json:"actor_ids"`
+	// ChirpId is the chirp the notification is about, 0 for follows
+	ChirpId   int       `json:"chirp_id,omitempty"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Summary describes the notification for display, e.g. "5 people liked your chirp"
+func (n Notification) Summary() string {
+	who := "Someone"
+	if len(n.ActorIds) > 1 {
+		who = fmt.Sprintf("%d people", len(n.ActorIds))
+	}
+
+	switch n.Type {
+	case NotificationReply:
+		return who + " replied to your chirp"
+	case NotificationLike:
+		return who + " liked your chirp"
+	case NotificationMention:
+		return who + " mentioned you"
+	case NotificationFollow:
+		return who + " followed you"
+	default:
+		return who + " interacted with you"
+	}
+}
+
+// IsValidNotificationType returns true if t is one of NotificationTypes
+func IsValidNotificationType(t string) bool {
+	return slices.Contains(NotificationTypes, t)
+}
+
+// notify adds a notification for the recipient, or groups it with a recent unread one of the same kind.
+// It's called from inside the transaction of the event that triggers it
+func notify(dbstruct DBStruct, recipientId string, notificationType string, actorId string, chirpId int) {
+	if recipientId == actorId {
+		return
+	}
+
+	recipient, ok := dbstruct.Users[recipientId]
+	if !ok || !recipient.WantsNotification(notificationType) {
+		return
+	}
+
+	if isBlockedEither(dbstruct, recipientId, actorId) || isMuted(dbstruct, recipientId, actorId) {
+		return
+	}
+
+	now := time.Now().UTC()
+	notifications := dbstruct.Notifications[recipientId]
+
+	for i := len(notifications) - 1; i >= 0; i-- {
+		n := notifications[i]
+		if now.Sub(n.UpdatedAt) > notificationGroupWindow {
+			break
+		}
+
+		if n.Read || n.Type != notificationType || n.ChirpId != chirpId {
+			continue
+		}
+
+		if !slices.Contains(n.ActorIds, actorId) {
+			n.ActorIds = append(n.ActorIds, actorId)
+		}
+		n.UpdatedAt = now
+
+		// Move the group to the end, so it's listed as the newest notification
+		notifications = append(slices.Delete(notifications, i, i+1), n)
+		dbstruct.Notifications[recipientId] = notifications
+		return
+	}
+
+	newId, err := uuid.NewRandom()
+	if err != nil {
+		return
+	}
+
+	notifications = append(notifications, Notification{
+		Id:        newId.String(),
+		Type:      notificationType,
+		ActorIds:  []string{actorId},
+		ChirpId:   chirpId,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+
+	if len(notifications) > MaxNotifications {
+		notifications = notifications[len(notifications)-MaxNotifications:]
+	}
+
+	dbstruct.Notifications[recipientId] = notifications
+}
+
+// GetNotifications returns a page of the user's notifications, newest first,
+// with the total number of matching notifications and the number of unread ones
+func (db *DB) GetNotifications(userId string, unreadOnly bool, offset int, limit int) ([]Notification, int, int, error) {
+	page := make([]Notification, 0)
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return page, 0, 0, fmt.Errorf("error loading database: %v", err)
+	}
+
+	matching := make([]Notification, 0)
+	unread := 0
+
+	notifications := dbstruct.Notifications[userId]
+	for i := len(notifications) - 1; i >= 0; i-- {
+		n := notifications[i]
+		if !n.Read {
+			unread++
+		}
+
+		if unreadOnly && n.Read {
+			continue
+		}
+
+		matching = append(matching, n)
+	}
+
+	if offset < len(matching) {
+		page = matching[offset:min(offset+limit, len(matching))]
+	}
+
+	return page, len(matching), unread, nil
+}
+
+// MarkNotificationsRead marks the given notification as read, an empty id marks all of them
+func (db *DB) MarkNotificationsRead(userId string, notificationId string) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	found := false
+	notifications := dbstruct.Notifications[userId]
+	for i := range notifications {
+		if notificationId == "" || notifications[i].Id == notificationId {
+			notifications[i].Read = true
+			found = true
+		}
+	}
+
+	if notificationId != "" && !found {
+		return http.StatusNotFound, fmt.Errorf("notification not found")
+	}
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing notifications: %v", err)
+	}
+
+	return http.StatusNoContent, nil
+}
+
+// UpdateNotificationPreferences turns notification types on or off for the user
+func (db *DB) UpdateNotificationPreferences(userId string, preferences map[string]bool) (map[string]bool, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if user.NotificationPreferences == nil {
+		user.NotificationPreferences = map[string]bool{}
+	}
+
+	for t, enabled := range preferences {
+		user.NotificationPreferences[t] = enabled
+	}
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	return user.AllNotificationPreferences(), http.StatusOK, nil
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// PasswordReset is the outstanding password reset of a user, the token is only stored hashed.
+// A new reset replaces the previous one, and the token can be used once
+type PasswordReset struct {
+	UserId    string      `json:"user_id"`
+	Email     string      `json:"email"`
+	TokenHash string      `json:"token_hash"`
+	ExpiresAt time.Time   `json:"expires_at"`
+	Sent      SendHistory `json:"sent"`
+}
+
+// CreatePasswordReset starts a password reset for the user with the email, valid for ttl.
+// It returns 404 if no user has the email, and 429 with the previous reset if the last email
+// went out less than interval ago or perDay emails went out over the last day
+func (db *DB) CreatePasswordReset(email string, tokenHash string, ttl time.Duration, interval time.Duration, perDay int) (PasswordReset, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return PasswordReset{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := db.isEmailExist(dbstruct, email)
+	if !ok {
+		return PasswordReset{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	now := time.Now().UTC()
+	reset := dbstruct.PasswordResets[user.Id]
+
+	reset.Sent = reset.Sent.recent(now)
+	if now.Before(reset.Sent.NextSendAt(interval, perDay)) {
+		return reset, http.StatusTooManyRequests, fmt.Errorf("too many password reset emails, try again later")
+	}
+
+	reset.UserId = user.Id
+	reset.Email = user.Email
+	reset.TokenHash = tokenHash
+	reset.ExpiresAt = now.Add(ttl)
+	reset.Sent = append(reset.Sent, now)
+	dbstruct.PasswordResets[user.Id] = reset
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return PasswordReset{}, http.StatusInternalServerError, fmt.Errorf("error writing password reset: %v", err)
+	}
+
+	return reset, http.StatusAccepted, nil
+}
+
+// ResetPassword sets the password of the user the token was sent to, and signs them out everywhere.
+// The token is used up, and it no longer works once it expired or the user changed their email since it was sent.
+// Following the link proves the user owns the email, so it counts as verified too
+func (db *DB) ResetPassword(tokenHash string, password []byte) (User, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	for userId, reset := range dbstruct.PasswordResets {
+		if reset.TokenHash == "" || reset.TokenHash != tokenHash {
+			continue
+		}
+
+		user, ok := dbstruct.Users[userId]
+		if !ok || user.Email != reset.Email || time.Now().After(reset.ExpiresAt) {
+			return User{}, http.StatusBadRequest, fmt.Errorf("reset token is invalid or has expired")
+		}
+
+		setPassword(dbstruct, &user, password)
+		user.EmailVerified = true
+		dbstruct.Users[userId] = user
+
+		// The send history stays for rate limiting, only the token is used up
+		reset.TokenHash = ""
+		dbstruct.PasswordResets[userId] = reset
+		delete(dbstruct.EmailVerifications, userId)
+
+		err = db.writeDB(dbstruct)
+		if err != nil {
+			return User{}, http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+		}
+
+		return user, http.StatusOK, nil
+	}
+
+	return User{}, http.StatusBadRequest, fmt.Errorf("reset token is invalid or has expired")
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+const (
+	MaxPinnedChirps          = 3
+	MaxPinnedChirpsChirpyRed = 10
+)
+
+// PinChirp pins one of the user's own chirps to the top of their profile,
+// the most recently pinned chirp comes first
+func (db *DB) PinChirp(userId string, chirpId int) (User, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return User{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	chirp, ok := dbstruct.Chirps[chirpId]
+	if !ok || !canView(dbstruct, chirp, userId) {
+		return User{}, http.StatusNotFound, fmt.Errorf("chirp with id %v not found", chirpId)
+	}
+
+	if chirp.UserId != userId {
+		return User{}, http.StatusForbidden, fmt.Errorf("you can only pin your own chirps")
+	}
+
+	if slices.Contains(user.PinnedChirps, chirpId) {
+		return user, http.StatusOK, nil
+	}
+
+	limit := MaxPinnedChirps
+	if user.IsChirpyRed {
+		limit = MaxPinnedChirpsChirpyRed
+	}
+
+	if len(user.PinnedChirps) >= limit {
+		return User{}, http.StatusBadRequest, fmt.Errorf("you can pin at most %d chirps", limit)
+	}
+
+	user.PinnedChirps = append([]int{chirpId}, user.PinnedChirps...)
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	return user, http.StatusOK, nil
+}
+
+// UnpinChirp removes the chirp from the user's pinned chirps, unpinning a chirp that is not pinned is a no-op
+func (db *DB) UnpinChirp(userId string, chirpId int) (User, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return User{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	user.PinnedChirps = slices.DeleteFunc(user.PinnedChirps, func(id int) bool {
+		return id == chirpId
+	})
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	return user, http.StatusOK, nil
+}
+
+// GetPinnedChirps returns the author's pinned chirps in pinned order, skipping the ones the viewer can't see
+func (db *DB) GetPinnedChirps(authorId string, viewerId string) ([]Chirpy, error) {
+	pinned := make([]Chirpy, 0)
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return pinned, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[authorId]
+	if !ok {
+		return pinned, nil
+	}
+
+	for _, id := range user.PinnedChirps {
+		chirp, ok := dbstruct.Chirps[id]
+		if !ok || !canView(dbstruct, chirp, viewerId) {
+			continue
+		}
+
+		pinned = append(pinned, viewChirp(dbstruct, chirp, viewerId))
+	}
+
+	return pinned, nil
+}
+
+// unpinDeletedChirp removes a deleted chirp from its author's pinned chirps
+func unpinDeletedChirp(dbstruct DBStruct, chirp Chirpy) {
+	user, ok := dbstruct.Users[chirp.UserId]
+	if !ok {
+		return
+	}
+
+	user.PinnedChirps = slices.DeleteFunc(user.PinnedChirps, func(id int) bool {
+		return id == chirp.Id
+	})
+	dbstruct.Users[chirp.UserId] = user
+}
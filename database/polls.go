@@ -0,0 +1,125 @@
+package database
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	MinPollOptions = 2
+	MaxPollOptions = 4
+)
+
+// Poll is stored on the chirp, the votes live in DBStruct.PollVotes.
+// Votes, TotalVotes, UserVote and Closed are only filled in when the chirp is read
+type Poll struct {
+	Options    []PollOption `json:"options"`
+	ClosesAt   time.Time    `json:"closes_at"`
+	Closed     bool         `json:"closed,omitempty"`
+	TotalVotes *int         `json:"total_votes,omitempty"`
+	UserVote   *int         `json:"user_vote,omitempty"`
+}
+
+type PollOption struct {
+	Id    int    `json:"id"`
+	Text  string `json:"text"`
+	Votes *int   `json:"votes,omitempty"`
+}
+
+// NewPoll builds a poll from the option texts, option ids are their position in the list
+func NewPoll(options []string, closesAt time.Time) *Poll {
+	poll := Poll{Options: make([]PollOption, 0, len(options)), ClosesAt: closesAt.UTC()}
+	for i, text := range options {
+		poll.Options = append(poll.Options, PollOption{Id: i, Text: text})
+	}
+
+	return &poll
+}
+
+// VotePoll records the user's vote, voting again changes the vote until the poll closes
+func (db *DB) VotePoll(chirpId int, userId string, optionId int) (Chirpy, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return Chirpy{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	chirp, ok := dbstruct.Chirps[chirpId]
+	if !ok || !canView(dbstruct, chirp, userId) {
+		return Chirpy{}, http.StatusNotFound, fmt.Errorf("chirp with id %v not found", chirpId)
+	}
+
+	if chirp.Poll == nil {
+		return Chirpy{}, http.StatusNotFound, fmt.Errorf("chirp with id %v has no poll", chirpId)
+	}
+
+	if !time.Now().Before(chirp.Poll.ClosesAt) {
+		return Chirpy{}, http.StatusConflict, fmt.Errorf("poll is closed")
+	}
+
+	if optionId < 0 || optionId >= len(chirp.Poll.Options) {
+		return Chirpy{}, http.StatusBadRequest, fmt.Errorf("invalid poll option")
+	}
+
+	if _, ok := dbstruct.PollVotes[chirpId]; !ok {
+		dbstruct.PollVotes[chirpId] = map[string]int{}
+	}
+
+	dbstruct.PollVotes[chirpId][userId] = optionId
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return Chirpy{}, http.StatusInternalServerError, fmt.Errorf("error writing vote: %v", err)
+	}
+
+	return viewChirp(dbstruct, chirp, userId), http.StatusOK, nil
+}
+
+// viewChirp prepares a chirp to be shown to the viewer, every read path goes through it.
+// View counts are filled in, and poll results are tallied here, and hidden until the viewer has voted or the poll has closed
+func viewChirp(dbstruct DBStruct, chirp Chirpy, viewerId string) Chirpy {
+	chirp.Views = chirpViewCount(dbstruct, chirp.Id)
+
+	if chirp.Poll == nil {
+		return chirp
+	}
+
+	// Copy the poll, so the stored chirp is never touched
+	poll := Poll{
+		Options:  make([]PollOption, len(chirp.Poll.Options)),
+		ClosesAt: chirp.Poll.ClosesAt,
+		Closed:   !time.Now().Before(chirp.Poll.ClosesAt),
+	}
+	copy(poll.Options, chirp.Poll.Options)
+	chirp.Poll = &poll
+
+	votes := dbstruct.PollVotes[chirp.Id]
+
+	userVote, hasVoted := votes[viewerId]
+	if viewerId != "" && hasVoted {
+		poll.UserVote = &userVote
+	}
+
+	if !poll.Closed && poll.UserVote == nil {
+		return chirp
+	}
+
+	counts := make([]int, len(poll.Options))
+	for _, option := range votes {
+		if option >= 0 && option < len(counts) {
+			counts[option]++
+		}
+	}
+
+	total := 0
+	for i := range poll.Options {
+		poll.Options[i].Votes = &counts[i]
+		total += counts[i]
+	}
+	poll.TotalVotes = &total
+
+	return chirp
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Profile is the part of a user anyone can see, fields are empty until the user fills them in
+type Profile struct {
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	Location    string `json:"location"`
+	Website     string `json:"website"`
+}
+
+// PublicUser is a user as shown to other users, it never carries the email or anything private
+type PublicUser struct {
+	Id string `json:"id"`
+	Profile
+	// AvatarURL is filled in by the handler, Avatar is the stored file name
+	AvatarURL      string `json:"avatar_url,omitempty"`
+	Avatar         string `json:"-"`
+	IsChirpyRed    bool   `json:"is_chirpy_red"`
+	ChirpCount     int    `json:"chirp_count"`
+	FollowerCount  int    `json:"follower_count"`
+	FollowingCount int    `json:"following_count"`
+}
+
+func publicUser(dbstruct DBStruct, user User) PublicUser {
+	chirps := 0
+	for _, chirp := range dbstruct.Chirps {
+		if chirp.UserId == user.Id && chirp.Visibility == VisibilityPublic && !isModerationHidden(chirp) {
+			chirps++
+		}
+	}
+
+	return PublicUser{
+		Id:             user.Id,
+		Profile:        user.Profile,
+		Avatar:         user.Avatar,
+		IsChirpyRed:    user.IsChirpyRed,
+		ChirpCount:     chirps,
+		FollowerCount:  len(dbstruct.Followers[user.Id]),
+		FollowingCount: len(dbstruct.Follows[user.Id]),
+	}
+}
+
+// findUserByHandle matches handles without case, so @Alice and @alice are the same user
+func findUserByHandle(dbstruct DBStruct, handle string) (User, bool) {
+	if handle == "" {
+		return User{}, false
+	}
+
+	for _, user := range dbstruct.Users {
+		if strings.EqualFold(user.Handle, handle) {
+			return user, true
+		}
+	}
+
+	return User{}, false
+}
+
+func (db *DB) GetPublicUser(userId string) (PublicUser, int, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return PublicUser{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return PublicUser{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	return publicUser(dbstruct, user), http.StatusOK, nil
+}
+
+func (db *DB) GetPublicUserByHandle(handle string) (PublicUser, int, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return PublicUser{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := findUserByHandle(dbstruct, handle)
+	if !ok {
+		return PublicUser{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	return publicUser(dbstruct, user), http.StatusOK, nil
+}
+
+// UpdateProfile replaces the user's profile, the handler validates the fields and the handle is checked to be free here
+func (db *DB) UpdateProfile(userId string, profile Profile) (User, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return User{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if other, taken := findUserByHandle(dbstruct, profile.Handle); taken && other.Id != userId {
+		return User{}, http.StatusConflict, fmt.Errorf("handle is already taken")
+	}
+
+	user.Profile = profile
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	return user, http.StatusOK, nil
+}
+
+// SetAvatar stores the file name of the user's avatar, empty to remove it, and returns the previous one
+func (db *DB) SetAvatar(userId string, avatar string) (string, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return "", http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	previous := user.Avatar
+	user.Avatar = avatar
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	return previous, http.StatusOK, nil
+}
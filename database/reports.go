@@ -0,0 +1,240 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	CaseOpen     = "open"
+	CaseResolved = "resolved"
+)
+
+const (
+	AdminActionDismiss = "dismiss"
+	AdminActionHide    = "hide"
+	AdminActionDelete  = "delete"
+	AdminActionSuspend = "suspend"
+)
+
+var ReportReasons = []string{"spam", "harassment", "hate", "violence", "sexual", "misinformation", "other"}
+
+type Report struct {
+	ReporterId string    `json:"reporter_id"`
+	Reason     string    `json:"reason"`
+	Details    string    `json:"details,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ModerationAction struct {
+	Action  string    `json:"action"`
+	AdminId string    `json:"admin_id"`
+	Note    string    `json:"note,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// ModerationCase groups every report on a chirp, so the admin queue has one entry per chirp.
+// The chirp body is copied, the case outlives the chirp if it gets deleted
+type ModerationCase struct {
+	ChirpId   int    `json:"chirp_id"`
+	AuthorId  string `json:"author_id"`
+	ChirpBody string `json:"chirp_body"`
+	Status    string `json:"status"`
+	// Reports are keyed by reporter id, reporting the same chirp twice updates the report
+	Reports       map[string]Report  `json:"reports"`
+	HeldByFilters bool               `json:"held_by_filters,omitempty"`
+	AutoHidden    bool               `json:"auto_hidden,omitempty"`
+	OpenedAt      time.Time          `json:"opened_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+	Actions       []ModerationAction `json:"actions"`
+}
+
+type ModerationQueueEntry struct {
+	ModerationCase
+	ReportCount  int            `json:"report_count"`
+	ReasonCounts map[string]int `json:"reason_counts"`
+}
+
+// IsValidReportReason returns true if reason is one of ReportReasons
+func IsValidReportReason(reason string) bool {
+	return slices.Contains(ReportReasons, reason)
+}
+
+// isModerationHidden returns true if the chirp is held or hidden pending review
+func isModerationHidden(chirp Chirpy) bool {
+	return chirp.Moderation != nil && (chirp.Moderation.Action == ModerationHeld || chirp.Moderation.Action == ModerationHidden)
+}
+
+// openModerationCase returns the case for the chirp, (re)opening it if needed
+func openModerationCase(dbstruct DBStruct, chirp Chirpy) ModerationCase {
+	now := time.Now().UTC()
+
+	c, ok := dbstruct.ModerationCases[chirp.Id]
+	if !ok {
+		c = ModerationCase{
+			ChirpId:       chirp.Id,
+			AuthorId:      chirp.UserId,
+			Reports:       map[string]Report{},
+			HeldByFilters: chirp.Moderation != nil && chirp.Moderation.Action == ModerationHeld,
+			OpenedAt:      now,
+			Actions:       []ModerationAction{},
+		}
+	}
+
+	c.ChirpBody = chirp.Body
+	c.Status = CaseOpen
+	c.UpdatedAt = now
+	dbstruct.ModerationCases[chirp.Id] = c
+
+	return c
+}
+
+// ReportChirp records a user's report, once hideThreshold different users have reported
+// the chirp it is hidden until an admin reviews it
+func (db *DB) ReportChirp(chirpId int, reporterId string, reason string, details string, hideThreshold int) (Report, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return Report{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	chirp, ok := dbstruct.Chirps[chirpId]
+	if !ok || !canView(dbstruct, chirp, reporterId) {
+		return Report{}, http.StatusNotFound, fmt.Errorf("chirp with id %v not found", chirpId)
+	}
+
+	if chirp.UserId == reporterId {
+		return Report{}, http.StatusBadRequest, fmt.Errorf("you can't report your own chirp")
+	}
+
+	c := openModerationCase(dbstruct, chirp)
+
+	report := Report{ReporterId: reporterId, Reason: reason, Details: details, CreatedAt: time.Now().UTC()}
+	c.Reports[reporterId] = report
+
+	if len(c.Reports) >= hideThreshold && !isModerationHidden(chirp) && !isApproved(chirp) {
+		chirp.Moderation = &ModerationVerdict{Action: ModerationHidden, Filters: []string{"reports"}, At: report.CreatedAt}
+		dbstruct.Chirps[chirpId] = chirp
+		c.AutoHidden = true
+	}
+
+	dbstruct.ModerationCases[chirpId] = c
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return Report{}, http.StatusInternalServerError, fmt.Errorf("error writing report: %v", err)
+	}
+
+	return report, http.StatusCreated, nil
+}
+
+// isApproved returns true if an admin already dismissed reports on the chirp,
+// so more reports put it back in the queue without hiding it again
+func isApproved(chirp Chirpy) bool {
+	return chirp.Moderation != nil && chirp.Moderation.Action == ModerationApproved
+}
+
+// GetModerationQueue returns the cases with the given status, most reported first
+func (db *DB) GetModerationQueue(status string) ([]ModerationQueueEntry, error) {
+	queue := make([]ModerationQueueEntry, 0)
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return queue, fmt.Errorf("error loading database: %v", err)
+	}
+
+	for _, c := range dbstruct.ModerationCases {
+		if status != "" && c.Status != status {
+			continue
+		}
+
+		entry := ModerationQueueEntry{ModerationCase: c, ReportCount: len(c.Reports), ReasonCounts: map[string]int{}}
+		for _, r := range c.Reports {
+			entry.ReasonCounts[r.Reason]++
+		}
+
+		queue = append(queue, entry)
+	}
+
+	slices.SortFunc(queue, func(a, b ModerationQueueEntry) int {
+		if c := cmp.Compare(b.ReportCount, a.ReportCount); c != 0 {
+			return c
+		}
+		return a.OpenedAt.Compare(b.OpenedAt)
+	})
+
+	return queue, nil
+}
+
+// ResolveModerationCase applies an admin action to the reported chirp and records who did it.
+// Only authors with a lower role than adminRole can be suspended
+func (db *DB) ResolveModerationCase(chirpId int, adminId string, adminRole string, action string, note string, suspendFor time.Duration) (ModerationCase, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return ModerationCase{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	c, ok := dbstruct.ModerationCases[chirpId]
+	if !ok {
+		return ModerationCase{}, http.StatusNotFound, fmt.Errorf("no moderation case for chirp %v", chirpId)
+	}
+
+	now := time.Now().UTC()
+	chirp, chirpExists := dbstruct.Chirps[chirpId]
+
+	switch action {
+	case AdminActionDismiss:
+		if chirpExists && isModerationHidden(chirp) {
+			chirp.Moderation = &ModerationVerdict{Action: ModerationApproved, Filters: chirp.Moderation.Filters, At: now}
+			dbstruct.Chirps[chirpId] = chirp
+		}
+	case AdminActionHide:
+		if !chirpExists {
+			return ModerationCase{}, http.StatusNotFound, fmt.Errorf("chirp with id %v not found", chirpId)
+		}
+		chirp.Moderation = &ModerationVerdict{Action: ModerationHidden, Filters: []string{"admin"}, At: now}
+		dbstruct.Chirps[chirpId] = chirp
+	case AdminActionDelete:
+		removeChirp(dbstruct, chirpId)
+	case AdminActionSuspend:
+		author, ok := dbstruct.Users[c.AuthorId]
+		if !ok {
+			return ModerationCase{}, http.StatusNotFound, fmt.Errorf("user not found")
+		}
+		if !RoleOutranks(adminRole, author.RoleName()) {
+			return ModerationCase{}, http.StatusForbidden, fmt.Errorf("you can't suspend a user whose role is the same as or higher than yours")
+		}
+		until := now.Add(suspendFor)
+		author.SuspendedUntil = &until
+		dbstruct.Users[c.AuthorId] = author
+		revokeRefreshTokens(dbstruct, c.AuthorId)
+
+		// The reported chirp shouldn't stay up while its author is suspended for it
+		if chirpExists && !isModerationHidden(chirp) {
+			chirp.Moderation = &ModerationVerdict{Action: ModerationHidden, Filters: []string{"admin"}, At: now}
+			dbstruct.Chirps[chirpId] = chirp
+		}
+	default:
+		return ModerationCase{}, http.StatusBadRequest, fmt.Errorf("unknown action %q", action)
+	}
+
+	c.Status = CaseResolved
+	c.UpdatedAt = now
+	c.Actions = append(c.Actions, ModerationAction{Action: action, AdminId: adminId, Note: note, At: now})
+	dbstruct.ModerationCases[chirpId] = c
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return ModerationCase{}, http.StatusInternalServerError, fmt.Errorf("error writing moderation case: %v", err)
+	}
+
+	return c, http.StatusOK, nil
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResolveModerationCaseSuspendNeedsHigherRole(t *testing.T) {
+	db, err := NewDBAt(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := map[string]User{}
+	for _, role := range Roles {
+		user, _, err := db.CreateUsers(role+"@example.com", []byte("hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user, _, err = db.SetUserRole(user.Id, role); err != nil {
+			t.Fatal(err)
+		}
+		users[role] = user
+	}
+
+	reporter, _, err := db.CreateUsers("reporter@example.com", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// In order, an author is only suspended by the last case about them
+	tests := []struct {
+		actor  string
+		author string
+		want   int
+	}{
+		{RoleModerator, RoleAdmin, http.StatusForbidden},
+		{RoleModerator, RoleModerator, http.StatusForbidden},
+		{RoleAdmin, RoleAdmin, http.StatusForbidden},
+		{RoleModerator, RoleUser, http.StatusOK},
+		{RoleAdmin, RoleModerator, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		chirp, _, err := db.CreateChirps("reported", users[tt.author].Id, VisibilityPublic, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := db.ReportChirp(chirp.Id, reporter.Id, "spam", "", 5); err != nil {
+			t.Fatal(err)
+		}
+
+		_, code, err := db.ResolveModerationCase(chirp.Id, users[tt.actor].Id, tt.actor, AdminActionSuspend, "", 24*time.Hour)
+		if code != tt.want {
+			t.Errorf("%s suspending %s = %d, %v, want %d", tt.actor, tt.author, code, err, tt.want)
+		}
+
+		author, _, err := db.GetUserById(users[tt.author].Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if suspended := author.SuspendedUntil != nil && author.SuspendedUntil.After(time.Now()); suspended != (tt.want == http.StatusOK) {
+			t.Errorf("%s suspending %s left the author suspended = %v", tt.actor, tt.author, suspended)
+		}
+	}
+}
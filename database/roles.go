@@ -0,0 +1,128 @@
+package database
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Roles a user can have, users without one stored are RoleUser
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// Permissions a role grants, admin routes declare the ones they need in main.go
+const (
+	PermissionMetricsRead     = "metrics:read"
+	PermissionMetricsReset    = "metrics:reset"
+	PermissionModerationRead  = "moderation:read"
+	PermissionModerationWrite = "moderation:write"
+	PermissionUsersDelete     = "users:delete"
+	PermissionRolesWrite      = "roles:write"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser:      {},
+	RoleModerator: {PermissionModerationRead, PermissionModerationWrite},
+	RoleAdmin: {
+		PermissionMetricsRead, PermissionMetricsReset,
+		PermissionModerationRead, PermissionModerationWrite,
+		PermissionUsersDelete, PermissionRolesWrite,
+	},
+}
+
+// RolePermissions returns the permissions the role grants, none for an unknown role
+func RolePermissions(role string) []string {
+	return slices.Clone(rolePermissions[role])
+}
+
+// RoleOutranks returns true if role is higher than other, in the order of Roles
+func RoleOutranks(role string, other string) bool {
+	return slices.Index(Roles, role) > slices.Index(Roles, other)
+}
+
+// RoleName returns the user's role, RoleUser for users stored without one
+func (u User) RoleName() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+
+	return u.Role
+}
+
+// SetUserRole changes the user's role, the access tokens of the user carry the old role and stop working
+func (db *DB) SetUserRole(userId string, role string) (User, int, error) {
+	if !slices.Contains(Roles, role) {
+		return User{}, http.StatusBadRequest, fmt.Errorf("unknown role %q", role)
+	}
+
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return User{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	user.Role = role
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	return user, http.StatusOK, nil
+}
+
+// BootstrapAdmins makes the users admins, so a new server has someone to hand out roles.
+// Ids of users that don't exist are returned, not treated as an error
+func (db *DB) BootstrapAdmins(userIds []string) ([]string, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return nil, fmt.Errorf("error loading database: %v", err)
+	}
+
+	unknown := []string{}
+	changed := false
+	for _, id := range userIds {
+		if id == "" {
+			continue
+		}
+
+		user, ok := dbstruct.Users[id]
+		if !ok {
+			unknown = append(unknown, id)
+			continue
+		}
+
+		if user.Role != RoleAdmin {
+			user.Role = RoleAdmin
+			dbstruct.Users[id] = user
+			changed = true
+		}
+	}
+
+	if !changed {
+		return unknown, nil
+	}
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return nil, fmt.Errorf("error writing users: %v", err)
+	}
+
+	return unknown, nil
+}
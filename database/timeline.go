@@ -0,0 +1,125 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+)
+
+// MaxTimelineLength is how many chirp ids are kept in each home timeline, older ones fall off
+const MaxTimelineLength = 1000
+
+// Home timelines are built on write: a new chirp id is pushed to the author's and every follower's
+// timeline, so reading a page never depends on how many accounts the reader follows.
+// Timelines hold chirp ids in ascending order; visibility is still checked when they are read
+
+// fanOutChirp pushes a new chirp into the author's and their followers' timelines
+func fanOutChirp(dbstruct DBStruct, chirp Chirpy) {
+	pushToTimeline(dbstruct, chirp.UserId, chirp.Id)
+
+	// Private chirps would be filtered out on read anyway
+	if chirp.Visibility == VisibilityPrivate {
+		return
+	}
+
+	for followerId := range dbstruct.Followers[chirp.UserId] {
+		pushToTimeline(dbstruct, followerId, chirp.Id)
+	}
+}
+
+func pushToTimeline(dbstruct DBStruct, userId string, chirpId int) {
+	timeline, ok := dbstruct.Timelines[userId]
+	if !ok {
+		// The chirp is already stored, so the built timeline holds it
+		timeline = buildTimeline(dbstruct, userId)
+	}
+
+	if !slices.Contains(timeline, chirpId) {
+		timeline = append(timeline, chirpId)
+	}
+	if len(timeline) > MaxTimelineLength {
+		timeline = timeline[len(timeline)-MaxTimelineLength:]
+	}
+
+	dbstruct.Timelines[userId] = timeline
+}
+
+// backfillTimeline merges the followee's most recent chirps into the follower's timeline
+func backfillTimeline(dbstruct DBStruct, followerId string, followeeId string) {
+	timeline, ok := dbstruct.Timelines[followerId]
+	if ok {
+		timeline = slices.Clone(timeline)
+	} else {
+		timeline = buildTimeline(dbstruct, followerId)
+	}
+
+	for id, chirp := range dbstruct.Chirps {
+		if chirp.UserId == followeeId && chirp.Visibility != VisibilityPrivate {
+			timeline = append(timeline, id)
+		}
+	}
+
+	slices.Sort(timeline)
+	timeline = slices.Compact(timeline)
+	if len(timeline) > MaxTimelineLength {
+		timeline = timeline[len(timeline)-MaxTimelineLength:]
+	}
+
+	dbstruct.Timelines[followerId] = timeline
+}
+
+// buildTimeline builds a timeline from scratch, for accounts created before timelines existed
+func buildTimeline(dbstruct DBStruct, userId string) []int {
+	timeline := make([]int, 0)
+	for id, chirp := range dbstruct.Chirps {
+		if chirp.UserId == userId || isFollowing(dbstruct, userId, chirp.UserId) {
+			timeline = append(timeline, id)
+		}
+	}
+
+	slices.Sort(timeline)
+	if len(timeline) > MaxTimelineLength {
+		timeline = timeline[len(timeline)-MaxTimelineLength:]
+	}
+
+	return timeline
+}
+
+// GetTimeline returns up to limit chirps from the user's home timeline, newest first.
+// Only chirps with an id lower than before are returned, 0 starts from the newest chirp.
+// The second value is the cursor for the next page, 0 if there are no more chirps
+func (db *DB) GetTimeline(userId string, before int, limit int) ([]Chirpy, int, error) {
+	chirps := make([]Chirpy, 0, limit)
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return chirps, 0, fmt.Errorf("error loading database: %v", err)
+	}
+
+	timeline, ok := dbstruct.Timelines[userId]
+	if !ok {
+		timeline = buildTimeline(dbstruct, userId)
+	}
+
+	// Skip to the first chirp older than the cursor
+	end := len(timeline)
+	if before > 0 {
+		end, _ = slices.BinarySearch(timeline, before)
+	}
+
+	i := end - 1
+	for ; i >= 0 && len(chirps) < limit; i-- {
+		// Deleted chirps are left in timelines and skipped here
+		chirp, ok := dbstruct.Chirps[timeline[i]]
+		if !ok || !canView(dbstruct, chirp, userId) || isMuted(dbstruct, userId, chirp.UserId) {
+			continue
+		}
+
+		chirps = append(chirps, viewChirp(dbstruct, chirp, userId))
+	}
+
+	if i < 0 || len(chirps) == 0 {
+		return chirps, 0, nil
+	}
+
+	return chirps, chirps[len(chirps)-1].Id, nil
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Accounts from before timelines existed have no entry, writes must not start them from scratch
+func TestTimelineWithoutEntryKeepsOlderChirps(t *testing.T) {
+	db, err := NewDBAt(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _, err := db.CreateUsers("alice@example.com", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, _, err := db.CreateUsers("bob@example.com", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	carol, _, err := db.CreateUsers("carol@example.com", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Follow(alice.Id, bob.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{}
+	carolChirpId := 0
+	for _, author := range []User{alice, bob, carol} {
+		chirp, _, err := db.CreateChirps("older", author.Id, VisibilityPublic, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if author.Id == carol.Id {
+			carolChirpId = chirp.Id
+		} else {
+			want = append(want, chirp.Id)
+		}
+	}
+
+	dropTimeline := func() {
+		t.Helper()
+		dbstruct, err := db.loadDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		delete(dbstruct.Timelines, alice.Id)
+		if err := db.writeDB(dbstruct); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(what string) {
+		t.Helper()
+		chirps, _, err := db.GetTimeline(alice.Id, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := []int{}
+		for _, chirp := range chirps {
+			got = append(got, chirp.Id)
+		}
+		slices.Sort(got)
+
+		if !slices.Equal(got, want) {
+			t.Errorf("after %s timeline = %v, want %v", what, got, want)
+		}
+	}
+
+	// A followee's new chirp
+	dropTimeline()
+	chirp, _, err := db.CreateChirps("newer", bob.Id, VisibilityPublic, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, chirp.Id)
+	check("a new chirp")
+
+	// A new follow
+	dropTimeline()
+	if _, err := db.Follow(alice.Id, carol.Id); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, carolChirpId)
+	slices.Sort(want)
+	check("a follow")
+
+	// An unfollow
+	dropTimeline()
+	if err := db.Unfollow(alice.Id, carol.Id); err != nil {
+		t.Fatal(err)
+	}
+	want = slices.DeleteFunc(want, func(id int) bool { return id == carolChirpId })
+	check("an unfollow")
+}
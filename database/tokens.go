@@ -2,14 +2,12 @@ package database
 
 import (
 	"errors"
-	"sync"
 	"time"
 )
 
 func (db *DB) StoreRefreshToken(refreshToken RefreshToken) error {
-	mu := new(sync.Mutex)
-	mu.Lock()
-	defer mu.Unlock()
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
 
 	dbstruct, err := db.loadDB()
 	if err != nil {
@@ -17,17 +15,15 @@ func (db *DB) StoreRefreshToken(refreshToken RefreshToken) error {
 	}
 
 	// If the user with that id already have a refresh token,
-	// we revoke the previous refresh token to avoid duplicates
+	// we revoke the previous refresh token to avoid duplicates.
+	// It is deleted from the loaded struct, revoking it through RevokeRefreshToken
+	// would be overwritten by the write below
 	for _, v := range dbstruct.Tokens {
 		if v.UserId != refreshToken.UserId {
 			continue
 		}
 
-		err = db.RevokeRefreshToken(v.Token)
-		if err != nil {
-			return err
-		}
-
+		delete(dbstruct.Tokens, v.Token)
 		break
 	}
 
@@ -45,9 +41,8 @@ func (db *DB) StoreRefreshToken(refreshToken RefreshToken) error {
 
 // RevokeRefreshToken Takes refresh token string as a key to revoke a refresh token and return an error
 func (db *DB) RevokeRefreshToken(refreshToken string) error {
-	mu := new(sync.Mutex)
-	mu.Lock()
-	defer mu.Unlock()
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
 
 	dbstruct, err := db.loadDB()
 	if err != nil {
@@ -65,10 +60,6 @@ func (db *DB) RevokeRefreshToken(refreshToken string) error {
 }
 
 func (db *DB) GetRefreshToken(refreshToken string) (RefreshToken, error) {
-	mu := new(sync.RWMutex)
-	mu.RLock()
-	defer mu.RUnlock()
-
 	dbstruct, err := db.loadDB()
 	if err != nil {
 		return RefreshToken{}, err
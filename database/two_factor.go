@@ -0,0 +1,236 @@
+package database
+
+import (
+	"chirpy/totp"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// MaxTwoFactorAttempts wrong codes in a row lock two-factor logins for TwoFactorLockout
+	MaxTwoFactorAttempts = 5
+	TwoFactorLockout     = 15 * time.Minute
+)
+
+// TwoFactor is a user's TOTP setup, it is pending until the user confirmed a code from their app
+type TwoFactor struct {
+	Secret    string     `json:"secret"`
+	Enabled   bool       `json:"enabled"`
+	EnabledAt *time.Time `json:"enabled_at,omitempty"`
+	// LastStep is the time step of the last code used, only later codes are accepted
+	LastStep int64 `json:"last_step"`
+	// RecoveryCodes are hashed, each one works once
+	RecoveryCodes  []string   `json:"recovery_codes,omitempty"`
+	FailedAttempts int        `json:"failed_attempts,omitempty"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+}
+
+// HasTwoFactor returns true if the user has to give a code to log in
+func (u User) HasTwoFactor() bool {
+	return u.TwoFactor != nil && u.TwoFactor.Enabled
+}
+
+// StartTwoFactorEnrolment stores a new pending secret for the user, replacing any earlier pending one.
+// It returns 409 if two-factor is already enabled
+func (db *DB) StartTwoFactorEnrolment(userId string, secret string) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if user.HasTwoFactor() {
+		return http.StatusConflict, fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	user.TwoFactor = &TwoFactor{Secret: secret}
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	return http.StatusOK, nil
+}
+
+// ConfirmTwoFactor enables the pending two-factor setup once the user gave a code from their app,
+// with the hashes of the recovery codes they were shown
+func (db *DB) ConfirmTwoFactor(userId string, code string, recoveryCodes []string) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if user.HasTwoFactor() {
+		return http.StatusConflict, fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	if user.TwoFactor == nil {
+		return http.StatusNotFound, fmt.Errorf("two-factor enrolment not started")
+	}
+
+	now := time.Now().UTC()
+	step, ok := totp.Validate(user.TwoFactor.Secret, code, now)
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("invalid code")
+	}
+
+	user.TwoFactor.Enabled = true
+	user.TwoFactor.EnabledAt = &now
+	user.TwoFactor.LastStep = step
+	user.TwoFactor.RecoveryCodes = recoveryCodes
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	return http.StatusOK, nil
+}
+
+// VerifyTwoFactorCode checks a code from the user's app, or the hash of one of their recovery codes, which is then used up.
+// Wrong codes count towards a lockout, a right one resets the count
+func (db *DB) VerifyTwoFactorCode(userId string, code string, recoveryCode string) (User, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return User{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if !user.HasTwoFactor() {
+		return User{}, http.StatusBadRequest, fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	now := time.Now().UTC()
+	twoFactor := user.TwoFactor
+	if twoFactor.LockedUntil != nil && now.Before(*twoFactor.LockedUntil) {
+		return User{}, http.StatusTooManyRequests, fmt.Errorf("too many wrong codes, try again later")
+	}
+
+	valid := false
+	if code != "" {
+		step, ok := totp.Validate(twoFactor.Secret, code, now)
+		if ok && step > twoFactor.LastStep {
+			twoFactor.LastStep = step
+			valid = true
+		}
+	} else if recoveryCode != "" {
+		for i, hash := range twoFactor.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(hash), []byte(recoveryCode)) == 1 {
+				twoFactor.RecoveryCodes = append(twoFactor.RecoveryCodes[:i:i], twoFactor.RecoveryCodes[i+1:]...)
+				valid = true
+				break
+			}
+		}
+	}
+
+	if valid {
+		twoFactor.FailedAttempts = 0
+		twoFactor.LockedUntil = nil
+	} else {
+		twoFactor.FailedAttempts++
+		if twoFactor.FailedAttempts >= MaxTwoFactorAttempts {
+			lockedUntil := now.Add(TwoFactorLockout)
+			twoFactor.FailedAttempts = 0
+			twoFactor.LockedUntil = &lockedUntil
+		}
+	}
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	if !valid {
+		return User{}, http.StatusUnauthorized, fmt.Errorf("invalid code")
+	}
+
+	return user, http.StatusOK, nil
+}
+
+// SetRecoveryCodes replaces the user's recovery codes with new hashes, the old codes stop working
+func (db *DB) SetRecoveryCodes(userId string, recoveryCodes []string) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if !user.HasTwoFactor() {
+		return http.StatusBadRequest, fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	user.TwoFactor.RecoveryCodes = recoveryCodes
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	return http.StatusOK, nil
+}
+
+// DisableTwoFactor removes the user's two-factor setup, pending or enabled
+func (db *DB) DisableTwoFactor(userId string) (int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if user.TwoFactor == nil {
+		return http.StatusNotFound, fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	user.TwoFactor = nil
+	dbstruct.Users[userId] = user
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+	}
+
+	return http.StatusNoContent, nil
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"chirpy/totp"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTwoFactorUser returns a database with a user who has two-factor enabled, and the user's secret
+func newTwoFactorUser(t *testing.T) (*DB, string, string) {
+	t.Helper()
+
+	db, err := NewDBAt(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, _, err := db.CreateUsers("alice@example.com", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.StartTwoFactorEnrolment(user.Id, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.ConfirmTwoFactor(user.Id, stepCode(t, secret, totp.Step(time.Now())), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, user.Id, secret
+}
+
+func stepCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+// wrongCode returns a code that isn't accepted in any step around now
+func wrongCode(t *testing.T, secret string) string {
+	t.Helper()
+
+	accepted := map[string]bool{}
+	current := totp.Step(time.Now())
+	for step := current - totp.Skew - 1; step <= current+totp.Skew+1; step++ {
+		accepted[stepCode(t, secret, step)] = true
+	}
+
+	for i := 0; ; i++ {
+		code := fmt.Sprintf("%0*d", totp.Digits, i)
+		if !accepted[code] {
+			return code
+		}
+	}
+}
+
+func TestVerifyTwoFactorCodeRejectsReplay(t *testing.T) {
+	db, userId, secret := newTwoFactorUser(t)
+
+	user, _, err := db.GetUserById(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := user.TwoFactor.LastStep
+
+	// The code used to enable two-factor can't log in
+	if _, code, err := db.VerifyTwoFactorCode(userId, stepCode(t, secret, used), ""); code != http.StatusUnauthorized {
+		t.Fatalf("replaying the enrolment code = %d, %v, want %d", code, err, http.StatusUnauthorized)
+	}
+
+	next := stepCode(t, secret, used+1)
+	if _, code, err := db.VerifyTwoFactorCode(userId, next, ""); err != nil {
+		t.Fatalf("code of the next step = %d, %v", code, err)
+	}
+
+	if _, code, err := db.VerifyTwoFactorCode(userId, next, ""); code != http.StatusUnauthorized {
+		t.Fatalf("replaying a used code = %d, %v, want %d", code, err, http.StatusUnauthorized)
+	}
+}
+
+func TestVerifyTwoFactorCodeLockout(t *testing.T) {
+	db, userId, secret := newTwoFactorUser(t)
+
+	user, _, err := db.GetUserById(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := stepCode(t, secret, user.TwoFactor.LastStep+1)
+	wrong := wrongCode(t, secret)
+
+	for i := 1; i <= MaxTwoFactorAttempts; i++ {
+		if _, code, err := db.VerifyTwoFactorCode(userId, wrong, ""); code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d = %d, %v, want %d", i, code, err, http.StatusUnauthorized)
+		}
+	}
+
+	// Even a right code is refused while locked
+	if _, code, err := db.VerifyTwoFactorCode(userId, valid, ""); code != http.StatusTooManyRequests {
+		t.Fatalf("code while locked = %d, %v, want %d", code, err, http.StatusTooManyRequests)
+	}
+
+	user, _, err = db.GetUserById(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TwoFactor.LockedUntil == nil || time.Until(*user.TwoFactor.LockedUntil) <= TwoFactorLockout-time.Minute {
+		t.Fatalf("locked until %v, want about %s from now", user.TwoFactor.LockedUntil, TwoFactorLockout)
+	}
+}
+
+func TestVerifyTwoFactorCodeResetsAttempts(t *testing.T) {
+	db, userId, secret := newTwoFactorUser(t)
+
+	user, _, err := db.GetUserById(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := wrongCode(t, secret)
+
+	for i := 1; i < MaxTwoFactorAttempts; i++ {
+		db.VerifyTwoFactorCode(userId, wrong, "")
+	}
+
+	if _, code, err := db.VerifyTwoFactorCode(userId, stepCode(t, secret, user.TwoFactor.LastStep+1), ""); err != nil {
+		t.Fatalf("right code = %d, %v", code, err)
+	}
+
+	// The right code started the count over, so one more wrong code doesn't lock
+	if _, code, _ := db.VerifyTwoFactorCode(userId, wrong, ""); code != http.StatusUnauthorized {
+		t.Fatalf("wrong code after a right one = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -4,15 +4,54 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"net/http"
-	"sync"
 	"time"
 )
 
 type User struct {
-	Id          string `json:"id"`
-	Email       string `json:"email"`
-	Password    []byte `json:"password"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Id       string `json:"id"`
+	Email    string `json:"email"`
+	Password []byte `json:"password"`
+	// EmailVerified is set once the user followed the link sent to Email, a new email has to be verified again
+	EmailVerified bool `json:"email_verified"`
+	IsChirpyRed   bool `json:"is_chirpy_red"`
+	// PinnedChirps holds chirp ids in the order they are shown on the profile
+	PinnedChirps []int `json:"pinned_chirps,omitempty"`
+	// SuspendedUntil is set by an admin, a suspended user can't log in or post chirps
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+	// Role decides what the user may do beyond their own account, see roles.go
+	Role string `json:"role,omitempty"`
+	// NotificationPreferences turns notification types off, a missing type is on
+	NotificationPreferences map[string]bool `json:"notification_preferences,omitempty"`
+	// DeletionScheduledAt is when the account gets deleted, nil unless the user asked for it
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+	Profile
+	// Avatar is the file name of the avatar in the avatar directory, empty if the user has none
+	Avatar string `json:"avatar,omitempty"`
+	// PasswordChangedAt is when the password was last changed, access tokens issued before it are rejected
+	PasswordChangedAt *time.Time `json:"password_changed_at,omitempty"`
+	// TwoFactor is nil until the user starts setting up two-factor authentication, see two_factor.go
+	TwoFactor *TwoFactor `json:"two_factor,omitempty"`
+}
+
+// WantsNotification returns true unless the user turned off notifications of this type
+func (u User) WantsNotification(notificationType string) bool {
+	enabled, ok := u.NotificationPreferences[notificationType]
+	return !ok || enabled
+}
+
+// AllNotificationPreferences returns whether each notification type is on for the user
+func (u User) AllNotificationPreferences() map[string]bool {
+	preferences := map[string]bool{}
+	for _, t := range NotificationTypes {
+		preferences[t] = u.WantsNotification(t)
+	}
+
+	return preferences
+}
+
+// IsSuspended returns true if the user is suspended right now
+func (u User) IsSuspended() bool {
+	return u.SuspendedUntil != nil && time.Now().Before(*u.SuspendedUntil)
 }
 
 type RefreshToken struct {
@@ -23,9 +62,8 @@ type RefreshToken struct {
 
 // CreateUsers creates a new user and saves it to disk
 func (db *DB) CreateUsers(email string, password []byte) (User, int, error) {
-	mu := new(sync.Mutex)
-	mu.Lock()
-	defer mu.Unlock()
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
 
 	dbstruct, err := db.loadDB()
 	if err != nil {
@@ -55,10 +93,6 @@ func (db *DB) CreateUsers(email string, password []byte) (User, int, error) {
 
 // GetUser returns a valid user by email address
 func (db *DB) GetUser(email string) (User, int, error) {
-	mu := new(sync.RWMutex)
-	mu.RLock()
-	defer mu.RUnlock()
-
 	dbstruct, err := db.loadDB()
 	if err != nil {
 		return User{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
@@ -72,23 +106,47 @@ func (db *DB) GetUser(email string) (User, int, error) {
 	return user, http.StatusOK, nil
 }
 
-// UpdateUser returns a valid updated user or http.code and error message if the update failed
-func (db *DB) UpdateUser(id string, newEmail string, newPassword []byte) (User, int, error) {
-	mu := new(sync.Mutex)
-	mu.Lock()
-	defer mu.Unlock()
+// UserPatch holds the account fields to change, nil fields are left as they are
+type UserPatch struct {
+	Email    *string
+	Password []byte
+	Profile  *Profile
+}
+
+// PatchUser changes only the fields set in the patch and keeps everything else about the user
+func (db *DB) PatchUser(id string, patch UserPatch) (User, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
 
 	dbstruct, err := db.loadDB()
 	if err != nil {
 		return User{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
 	}
 
-	// Aight, busted, bla bla, no auth in the db. Whatever man, I aint doing it
-	if _, ok := db.isEmailExist(dbstruct, newEmail); ok {
-		return User{}, http.StatusBadRequest, fmt.Errorf("email is already used")
+	user, ok := dbstruct.Users[id]
+	if !ok {
+		return User{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if patch.Email != nil && *patch.Email != user.Email {
+		if _, ok := db.isEmailExist(dbstruct, *patch.Email); ok {
+			return User{}, http.StatusBadRequest, fmt.Errorf("email is already used")
+		}
+		user.Email = *patch.Email
+		user.EmailVerified = false
+	}
+
+	if patch.Profile != nil {
+		if other, taken := findUserByHandle(dbstruct, patch.Profile.Handle); taken && other.Id != id {
+			return User{}, http.StatusConflict, fmt.Errorf("handle is already taken")
+		}
+		user.Profile = *patch.Profile
+	}
+
+	if patch.Password != nil {
+		setPassword(dbstruct, &user, patch.Password)
 	}
 
-	user := User{Id: id, Email: newEmail, Password: newPassword}
 	dbstruct.Users[id] = user
 
 	err = db.writeDB(dbstruct)
@@ -100,9 +158,8 @@ func (db *DB) UpdateUser(id string, newEmail string, newPassword []byte) (User,
 }
 
 func (db *DB) UpgradeUser(id string) (User, int, error) {
-	mu := new(sync.Mutex)
-	mu.Lock()
-	defer mu.Unlock()
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
 
 	dbstruct, err := db.loadDB()
 	if err != nil {
@@ -125,12 +182,27 @@ func (db *DB) UpgradeUser(id string) (User, int, error) {
 	return user, http.StatusOK, nil
 }
 
+// setPassword changes the user's password, revokes every refresh token of the user
+// and makes the access tokens issued until now stale
+func setPassword(dbstruct DBStruct, user *User, password []byte) {
+	now := time.Now().UTC()
+	user.Password = password
+	user.PasswordChangedAt = &now
+
+	revokeRefreshTokens(dbstruct, user.Id)
+}
+
+// revokeRefreshTokens deletes every refresh token of the user, ending their sessions once the access tokens expire
+func revokeRefreshTokens(dbstruct DBStruct, userId string) {
+	for token, refreshToken := range dbstruct.Tokens {
+		if refreshToken.UserId == userId {
+			delete(dbstruct.Tokens, token)
+		}
+	}
+}
+
 // isEmailExist returns user and true if the given email exists in db
 func (db *DB) isEmailExist(dbstruct DBStruct, email string) (User, bool) {
-	mu := new(sync.Mutex)
-	mu.Lock()
-	defer mu.Unlock()
-
 	users := dbstruct.Users
 	for _, v := range users {
 		if v.Email != email {
@@ -142,3 +214,28 @@ func (db *DB) isEmailExist(dbstruct DBStruct, email string) (User, bool) {
 
 	return User{}, false
 }
+
+// IsChirpyRed returns true if the user has upgraded to Chirpy Red
+func (db *DB) IsChirpyRed(id string) (bool, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return false, fmt.Errorf("error loading database: %v", err)
+	}
+
+	return dbstruct.Users[id].IsChirpyRed, nil
+}
+
+// GetUserById returns the user with the given id
+func (db *DB) GetUserById(id string) (User, int, error) {
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[id]
+	if !ok {
+		return User{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	return user, http.StatusOK, nil
+}
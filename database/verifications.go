@@ -0,0 +1,129 @@
+package database
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SendHistory holds when emails of one kind went out to a user over the last day, for rate limiting
+type SendHistory []time.Time
+
+// NextSendAt returns when another email may be sent, at most one per interval and perDay a day
+func (h SendHistory) NextSendAt(interval time.Duration, perDay int) time.Time {
+	if len(h) == 0 {
+		return time.Time{}
+	}
+
+	next := h[len(h)-1].Add(interval)
+	if len(h) >= perDay {
+		if dayLimit := h[len(h)-perDay].Add(24 * time.Hour); dayLimit.After(next) {
+			next = dayLimit
+		}
+	}
+
+	return next
+}
+
+// recent drops the sends older than a day
+func (h SendHistory) recent(now time.Time) SendHistory {
+	sent := make(SendHistory, 0, len(h)+1)
+	for _, at := range h {
+		if now.Sub(at) < 24*time.Hour {
+			sent = append(sent, at)
+		}
+	}
+
+	return sent
+}
+
+// EmailVerification is the outstanding verification of a user's email, the token is only stored hashed.
+// A new verification replaces the previous one, so only the latest email sent works
+type EmailVerification struct {
+	UserId    string      `json:"user_id"`
+	Email     string      `json:"email"`
+	TokenHash string      `json:"token_hash"`
+	ExpiresAt time.Time   `json:"expires_at"`
+	Sent      SendHistory `json:"sent"`
+}
+
+// CreateEmailVerification starts a verification of the user's current email, valid for ttl.
+// It returns 409 if the email is already verified, and 429 with the previous verification
+// if the last email went out less than interval ago or perDay emails went out over the last day
+func (db *DB) CreateEmailVerification(userId string, tokenHash string, ttl time.Duration, interval time.Duration, perDay int) (EmailVerification, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return EmailVerification{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	user, ok := dbstruct.Users[userId]
+	if !ok {
+		return EmailVerification{}, http.StatusNotFound, fmt.Errorf("user not found")
+	}
+
+	if user.EmailVerified {
+		return EmailVerification{}, http.StatusConflict, fmt.Errorf("email is already verified")
+	}
+
+	now := time.Now().UTC()
+	verification := dbstruct.EmailVerifications[userId]
+
+	// The history survives email changes, so changing the email over and over doesn't get around the limit
+	verification.Sent = verification.Sent.recent(now)
+	if now.Before(verification.Sent.NextSendAt(interval, perDay)) {
+		return verification, http.StatusTooManyRequests, fmt.Errorf("too many verification emails, try again later")
+	}
+
+	verification.UserId = userId
+	verification.Email = user.Email
+	verification.TokenHash = tokenHash
+	verification.ExpiresAt = now.Add(ttl)
+	verification.Sent = append(verification.Sent, now)
+	dbstruct.EmailVerifications[userId] = verification
+
+	err = db.writeDB(dbstruct)
+	if err != nil {
+		return EmailVerification{}, http.StatusInternalServerError, fmt.Errorf("error writing email verification: %v", err)
+	}
+
+	return verification, http.StatusAccepted, nil
+}
+
+// VerifyEmail marks the email of the user the token was sent to as verified. The token is used up,
+// and it no longer works once it expired or the user changed their email since it was sent
+func (db *DB) VerifyEmail(tokenHash string) (User, int, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbstruct, err := db.loadDB()
+	if err != nil {
+		return User{}, http.StatusInternalServerError, fmt.Errorf("error loading database: %v", err)
+	}
+
+	for userId, verification := range dbstruct.EmailVerifications {
+		if verification.TokenHash == "" || verification.TokenHash != tokenHash {
+			continue
+		}
+
+		user, ok := dbstruct.Users[userId]
+		if !ok || user.Email != verification.Email || time.Now().After(verification.ExpiresAt) {
+			return User{}, http.StatusBadRequest, fmt.Errorf("verification token is invalid or has expired")
+		}
+
+		user.EmailVerified = true
+		dbstruct.Users[userId] = user
+		delete(dbstruct.EmailVerifications, userId)
+
+		err = db.writeDB(dbstruct)
+		if err != nil {
+			return User{}, http.StatusInternalServerError, fmt.Errorf("error writing user: %v", err)
+		}
+
+		return user, http.StatusOK, nil
+	}
+
+	return User{}, http.StatusBadRequest, fmt.Errorf("verification token is invalid or has expired")
+}
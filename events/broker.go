@@ -0,0 +1,220 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	ChirpCreated = "chirp.created"
+	ChirpDeleted = "chirp.deleted"
+
+	NotificationsUpdated = "notifications.updated"
+	MessageCreated       = "message.created"
+	ConversationTyping   = "conversation.typing"
+)
+
+// Event is something that happened on chirpy, ids increase by one for every published event
+type Event struct {
+	Id    uint64    `json:"id,omitempty"`
+	Topic string    `json:"topic"`
+	Type  string    `json:"type"`
+	Data  any       `json:"data"`
+	At    time.Time `json:"at"`
+}
+
+// Broker fans published events out to subscribers and keeps a bounded backlog,
+// so a client that reconnects can resume from the last event it saw
+type Broker struct {
+	mu          sync.Mutex
+	nextId      uint64
+	backlog     []Event
+	backlogSize int
+	subscribers map[*Subscription]struct{}
+	closed      bool
+	// active counts the subscriptions not closed by their subscriber yet
+	active sync.WaitGroup
+}
+
+// Subscription receives the events matching its filter until it's closed.
+// A subscriber that can't keep up is dropped instead of slowing down everybody else
+type Subscription struct {
+	broker *Broker
+	filter func(Event) bool
+	events chan Event
+	done   chan struct{}
+	once   sync.Once
+	// closeOnce guards Close, which the subscriber calls even after the broker already ended the subscription
+	closeOnce sync.Once
+	// dropped is set before done is closed when the subscriber fell behind
+	dropped bool
+}
+
+func NewBroker(backlogSize int) *Broker {
+	return &Broker{
+		nextId:      1,
+		backlogSize: backlogSize,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish sends the event to every matching subscriber without blocking
+func (b *Broker) Publish(topic string, eventType string, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := Event{Id: b.nextId, Topic: topic, Type: eventType, Data: data, At: time.Now().UTC()}
+	if b.closed {
+		return event
+	}
+	b.nextId++
+
+	b.backlog = append(b.backlog, event)
+	if len(b.backlog) > b.backlogSize {
+		b.backlog = b.backlog[len(b.backlog)-b.backlogSize:]
+	}
+
+	b.deliver(event)
+
+	return event
+}
+
+// PublishTransient is Publish for events that are worthless once missed, like typing indicators.
+// They get no id and are kept out of the backlog, so they can't push resumable events out of it
+func (b *Broker) PublishTransient(topic string, eventType string, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := Event{Topic: topic, Type: eventType, Data: data, At: time.Now().UTC()}
+	if b.closed {
+		return event
+	}
+
+	b.deliver(event)
+
+	return event
+}
+
+// deliver must be called with b.mu held
+func (b *Broker) deliver(event Event) {
+	for s := range b.subscribers {
+		if !s.filter(event) {
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			// The client's buffer is full, drop the client rather than block the publisher
+			delete(b.subscribers, s)
+			s.dropped = true
+			s.closeDone()
+		}
+	}
+}
+
+// Subscribe registers a subscriber with room for bufferSize pending events.
+// If lastEventId is not 0 the matching events published after it are returned to be replayed first,
+// the bool is false if lastEventId already fell out of the backlog and events were missed
+func (b *Broker) Subscribe(filter func(Event) bool, bufferSize int, lastEventId uint64) (*Subscription, []Event, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := &Subscription{
+		broker: b,
+		filter: filter,
+		events: make(chan Event, bufferSize),
+		done:   make(chan struct{}),
+	}
+
+	if b.closed {
+		// Never counted as active, so closing it must not count it down
+		s.closeOnce.Do(func() {})
+		s.closeDone()
+		return s, nil, true
+	}
+
+	b.subscribers[s] = struct{}{}
+	b.active.Add(1)
+
+	if lastEventId == 0 {
+		return s, nil, true
+	}
+
+	complete := len(b.backlog) == 0 || b.backlog[0].Id <= lastEventId+1
+	replay := make([]Event, 0)
+	for _, e := range b.backlog {
+		if e.Id > lastEventId && filter(e) {
+			replay = append(replay, e)
+		}
+	}
+
+	return s, replay, complete
+}
+
+// Close ends every subscription, it's called when the server shuts down
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subscribers {
+		delete(b.subscribers, s)
+		s.closeDone()
+	}
+}
+
+// Wait blocks until every subscriber closed its subscription after Close, or ctx is done.
+// It lets connections the http server doesn't track, like websockets, say goodbye before the process exits
+func (b *Broker) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		b.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Events is where the subscriber's events arrive
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Done is closed when the subscription ended, because the broker shut down or the subscriber fell behind
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dropped returns true if the subscription ended because the subscriber fell behind, it's only meaningful once Done is closed
+func (s *Subscription) Dropped() bool {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	return s.dropped
+}
+
+// Close unsubscribes, it's safe to call more than once
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	delete(s.broker.subscribers, s)
+	s.closeDone()
+
+	s.closeOnce.Do(func() {
+		s.broker.active.Done()
+	})
+}
+
+func (s *Subscription) closeDone() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
@@ -0,0 +1,246 @@
+package exports
+
+import (
+	"archive/zip"
+	"chirpy/database"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// Retention is how long a finished archive can be downloaded before it's deleted
+	Retention = 7 * 24 * time.Hour
+	queueSize = 100
+)
+
+// Exporter builds data export archives in the background, one zip file per export in dir
+type Exporter struct {
+	db  *database.DB
+	dir string
+
+	queue     chan string
+	stop      chan struct{}
+	stopOnce  sync.Once
+	workersWg sync.WaitGroup
+}
+
+func NewExporter(db *database.DB, dir string) *Exporter {
+	return &Exporter{
+		db:    db,
+		dir:   dir,
+		queue: make(chan string, queueSize),
+		stop:  make(chan struct{}),
+	}
+}
+
+// Enqueue schedules the export to be built. If the queue is full it's picked up again on the next restart
+func (e *Exporter) Enqueue(exportId string) {
+	select {
+	case <-e.stop:
+	case e.queue <- exportId:
+	default:
+		log.Printf("Error queueing export %s: export queue is full", exportId)
+	}
+}
+
+// Run starts the workers, queues the exports left pending by the last run and removes expired archives every hour
+func (e *Exporter) Run(workers int) error {
+	if err := os.MkdirAll(e.dir, 0700); err != nil {
+		return fmt.Errorf("error creating export directory: %v", err)
+	}
+
+	for i := 0; i < workers; i++ {
+		e.workersWg.Add(1)
+		go e.worker()
+	}
+
+	pending, err := e.db.GetPendingDataExports()
+	if err != nil {
+		return err
+	}
+	for _, export := range pending {
+		e.Enqueue(export.Id)
+	}
+
+	e.workersWg.Add(1)
+	go e.cleanup(time.Hour)
+
+	return nil
+}
+
+func (e *Exporter) worker() {
+	defer e.workersWg.Done()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case exportId := <-e.queue:
+			// An export queued twice is only built once
+			export, err := e.findExport(exportId)
+			if err != nil {
+				log.Printf("Error building export %s: %s", exportId, err)
+				continue
+			}
+
+			file, err := e.build(export)
+			if err != nil {
+				log.Printf("Error building export %s: %s", exportId, err)
+			}
+
+			if err := e.db.FinishDataExport(exportId, file, err); err != nil {
+				log.Printf("Error finishing export %s: %s", exportId, err)
+			}
+		}
+	}
+}
+
+func (e *Exporter) cleanup(interval time.Duration) {
+	defer e.workersWg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			removed, err := e.db.RemoveDataExportsBefore(time.Now().Add(-Retention))
+			if err != nil {
+				log.Printf("Error removing expired exports: %s", err)
+				continue
+			}
+
+			for _, export := range removed {
+				e.Remove(export)
+			}
+		}
+	}
+}
+
+// Remove deletes the archive of the export, if it has one
+func (e *Exporter) Remove(export database.DataExport) {
+	if export.File == "" {
+		return
+	}
+
+	if err := os.Remove(export.File); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing export archive %s: %s", export.File, err)
+	}
+}
+
+// build writes the archive to a temporary file first, so a half written archive is never served
+func (e *Exporter) build(export database.DataExport) (string, error) {
+	data, err := e.db.GetUserData(export.UserId)
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(e.dir, "export-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("error creating archive: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeArchive(tmp, export, data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("error writing archive: %v", err)
+	}
+
+	file := filepath.Join(e.dir, export.Id+".zip")
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return "", fmt.Errorf("error writing archive: %v", err)
+	}
+
+	return file, nil
+}
+
+// findExport returns the export if it's still pending
+func (e *Exporter) findExport(exportId string) (database.DataExport, error) {
+	pending, err := e.db.GetPendingDataExports()
+	if err != nil {
+		return database.DataExport{}, err
+	}
+
+	for _, export := range pending {
+		if export.Id == exportId {
+			return export, nil
+		}
+	}
+
+	return database.DataExport{}, fmt.Errorf("export is not pending")
+}
+
+// writeArchive writes one JSON file per part of the data, plus an HTML page to browse it
+func writeArchive(w io.Writer, export database.DataExport, data database.UserData) error {
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", data.Profile},
+		{"chirps.json", data.Chirps},
+		{"poll_votes.json", data.PollVotes},
+		{"bookmarks.json", map[string]any{"bookmarks": data.Bookmarks, "folders": data.BookmarkFolders}},
+		{"sessions.json", data.Sessions},
+		{"access_tokens.json", data.AccessTokens},
+		{"connections.json", map[string]any{
+			"following": data.Following,
+			"followers": data.Followers,
+			"blocks":    data.Blocks,
+			"mutes":     data.Mutes,
+		}},
+	}
+
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return fmt.Errorf("error writing %s: %v", file.name, err)
+		}
+
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.v); err != nil {
+			return fmt.Errorf("error writing %s: %v", file.name, err)
+		}
+	}
+
+	f, err := archive.Create("index.html")
+	if err != nil {
+		return fmt.Errorf("error writing index.html: %v", err)
+	}
+
+	err = indexTemplate.Execute(f, struct {
+		Export database.DataExport
+		Data   database.UserData
+	}{export, data})
+	if err != nil {
+		return fmt.Errorf("error writing index.html: %v", err)
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("error writing archive: %v", err)
+	}
+
+	return nil
+}
+
+// Close stops the workers once their current export is done, queued exports are built on the next run
+func (e *Exporter) Close() {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+	})
+	e.workersWg.Wait()
+}
@@ -0,0 +1,73 @@
+package exports
+
+import (
+	"html/template"
+)
+
+// indexTemplate is the page at the root of an archive, html/template escapes everything the user wrote
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Your Chirpy data</title>
+<style>
+body { font-family: sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; }
+table { border-collapse: collapse; }
+td, th { text-align: left; padding: 0.25rem 1rem 0.25rem 0; }
+.chirp { border-bottom: 1px solid #ddd; padding: 0.5rem 0; white-space: pre-wrap; }
+.meta { color: #666; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>Your Chirpy data</h1>
+<p class="meta">Requested {{.Export.RequestedAt.Format "2006-01-02 15:04 MST"}}. Every section is also in the JSON files next to this page.</p>
+
+<h2>Profile</h2>
+<table>
+<tr><th>Id</th><td>{{.Data.Profile.Id}}</td></tr>
+<tr><th>Email</th><td>{{.Data.Profile.Email}}</td></tr>
+{{with .Data.Profile.Handle}}<tr><th>Handle</th><td>@{{.}}</td></tr>{{end}}
+{{with .Data.Profile.DisplayName}}<tr><th>Display name</th><td>{{.}}</td></tr>{{end}}
+{{with .Data.Profile.Bio}}<tr><th>Bio</th><td>{{.}}</td></tr>{{end}}
+{{with .Data.Profile.Location}}<tr><th>Location</th><td>{{.}}</td></tr>{{end}}
+{{with .Data.Profile.Website}}<tr><th>Website</th><td>{{.}}</td></tr>{{end}}
+<tr><th>Chirpy Red</th><td>{{if .Data.Profile.IsChirpyRed}}yes{{else}}no{{end}}</td></tr>
+{{with .Data.Profile.SuspendedUntil}}<tr><th>Suspended until</th><td>{{.Format "2006-01-02 15:04 MST"}}</td></tr>{{end}}
+</table>
+
+<h2>Chirps ({{len .Data.Chirps}})</h2>
+{{range .Data.Chirps}}<div class="chirp">{{.Body}}
+<div class="meta">#{{.Id}} · {{.Visibility}}{{if not .CreatedAt.IsZero}} · {{.CreatedAt.Format "2006-01-02 15:04 MST"}}{{end}} · {{.Views}} views</div></div>
+{{else}}<p>No chirps.</p>
+{{end}}
+
+<h2>Poll votes ({{len .Data.PollVotes}})</h2>
+{{range .Data.PollVotes}}<p>Chirp #{{.ChirpId}}, option {{.Option}}</p>
+{{else}}<p>No votes.</p>
+{{end}}
+
+<h2>Bookmarks ({{len .Data.Bookmarks}})</h2>
+{{range .Data.Bookmarks}}<p>Chirp #{{.ChirpId}}{{with .FolderId}} in folder {{.}}{{end}}, saved {{.CreatedAt.Format "2006-01-02"}}</p>
+{{else}}<p>No bookmarks.</p>
+{{end}}
+
+<h2>Sessions ({{len .Data.Sessions}})</h2>
+{{range .Data.Sessions}}<p>Signed in, expires {{.ExpiresAt.Format "2006-01-02 15:04 MST"}}</p>
+{{else}}<p>No active sessions.</p>
+{{end}}
+
+<h2>Access tokens ({{len .Data.AccessTokens}})</h2>
+{{range .Data.AccessTokens}}<p>{{.Name}} ({{range $i, $s := .Scopes}}{{if $i}}, {{end}}{{$s}}{{end}}), created {{.CreatedAt.Format "2006-01-02"}}{{with .LastUsedAt}}, last used {{.Format "2006-01-02"}}{{end}}</p>
+{{else}}<p>No access tokens.</p>
+{{end}}
+
+<h2>Connections</h2>
+<table>
+<tr><th>Following</th><td>{{len .Data.Following}}</td></tr>
+<tr><th>Followers</th><td>{{len .Data.Followers}}</td></tr>
+<tr><th>Blocked</th><td>{{len .Data.Blocks}}</td></tr>
+<tr><th>Muted</th><td>{{len .Data.Mutes}}</td></tr>
+</table>
+</body>
+</html>
+`))
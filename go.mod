@@ -8,4 +8,9 @@ require (
 	golang.org/x/crypto v0.26.0
 )
 
-require github.com/google/uuid v1.6.0 // indirect
+require (
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/rivo/uniseg v0.4.7
+	golang.org/x/text v0.17.0
+)
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// PersonalAccessTokenPrefix tells personal access tokens apart from the JWTs handed out on login,
+	// and makes them easy to spot when one is leaked
+	PersonalAccessTokenPrefix = "chirpy_pat_"
+	// MaxAccessTokenNameLength is the longest token name, counted like profile fields
+	MaxAccessTokenNameLength = 50
+)
+
+type CreateAccessTokenRequestBody struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays is optional, tokens without it never expire
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+// AccessTokenResponseBody is a token as listed to its owner, Token is only set in the response that creates it
+type AccessTokenResponseBody struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func accessTokenResponse(token database.PersonalAccessToken) AccessTokenResponseBody {
+	return AccessTokenResponseBody{
+		Id:         token.Id,
+		Name:       token.Name,
+		Scopes:     token.Scopes,
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+	}
+}
+
+// validatePersonalAccessToken looks the token up by its hash and records that it was used
+func (cfg *ApiConfig) validatePersonalAccessToken(tokenString string) (tokenAuth, error) {
+	tokenHash := hashToken(tokenString)
+	token, err := cfg.DB.GetPersonalAccessTokenByHash(tokenHash)
+	if err != nil {
+		log.Printf("Invalid personal access token: %s", err)
+		return tokenAuth{}, fmt.Errorf("invalid token")
+	}
+
+	if err := cfg.DB.TouchPersonalAccessToken(token.Id); err != nil {
+		log.Printf("Error recording token use: %s", err)
+	}
+
+	auth := tokenAuth{
+		UserId:    token.UserId,
+		Personal:  true,
+		Scopes:    token.Scopes,
+		TokenHash: tokenHash,
+	}
+	if token.ExpiresAt != nil {
+		auth.ExpiresAt = *token.ExpiresAt
+	}
+
+	return auth, nil
+}
+
+// MiddlewareScopes declares the scopes a personal access token needs for the route. Access tokens from
+// logging in pass through untouched, routes without this middleware don't take personal access tokens at all
+func (cfg *ApiConfig) MiddlewareScopes(next http.Handler, scopes ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := bearerToken(r)
+		if !strings.HasPrefix(tokenString, PersonalAccessTokenPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		auth, err := cfg.validatePersonalAccessToken(tokenString)
+		if err != nil {
+			helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		if missing := auth.missingScopes(scopes...); len(missing) > 0 {
+			helpers.RespondWithError(w, http.StatusForbidden, "token is missing the scopes "+strings.Join(missing, ", "))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenAuthKey{}, auth)))
+	})
+}
+
+// MiddlewareSession marks a route that needs an access token from logging in, like managing tokens,
+// 2FA, exports and deleting the account. Personal access tokens get a 403 instead of a bare 401
+func (cfg *ApiConfig) MiddlewareSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := bearerToken(r)
+		if !strings.HasPrefix(tokenString, PersonalAccessTokenPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if _, err := cfg.validatePersonalAccessToken(tokenString); err != nil {
+			helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		helpers.RespondWithError(w, http.StatusForbidden, "this endpoint requires a login session, personal access tokens can't be used")
+	})
+}
+
+// validateScopes checks every scope is known and returns them sorted without duplicates
+func validateScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+
+	valid := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !slices.Contains(database.Scopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q, scopes are %s", scope, strings.Join(database.Scopes, ", "))
+		}
+		valid = append(valid, scope)
+	}
+
+	slices.Sort(valid)
+	return slices.Compact(valid), nil
+}
+
+// CreateAccessTokenHandler creates a personal access token, the token itself is only in this response.
+// Creating and managing tokens needs an access token from logging in, a personal access token can't make more
+func (cfg *ApiConfig) CreateAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	body := CreateAccessTokenRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	name := helpers.NormalizeProfileText(body.Name)
+	if name == "" {
+		helpers.RespondWithError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if err := helpers.ValidateProfileText("name", name, MaxAccessTokenNameLength); err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	scopes, err := validateScopes(body.Scopes)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if body.ExpiresInDays < 0 {
+		helpers.RespondWithError(w, http.StatusBadRequest, "expires_in_days can't be negative")
+		return
+	}
+
+	var expiresAt *time.Time
+	if body.ExpiresInDays > 0 {
+		at := time.Now().UTC().AddDate(0, 0, body.ExpiresInDays)
+		expiresAt = &at
+	}
+
+	ran := make([]byte, 32)
+	if _, err := rand.Read(ran); err != nil {
+		log.Printf("Error generating token: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, "error generating token")
+		return
+	}
+	tokenString := PersonalAccessTokenPrefix + hex.EncodeToString(ran)
+
+	token, code, err := cfg.DB.CreatePersonalAccessToken(userId, name, hashToken(tokenString), scopes, expiresAt)
+	if err != nil {
+		log.Printf("Error creating personal access token: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	response := accessTokenResponse(token)
+	response.Token = tokenString
+	helpers.RespondWithJSON(w, code, response)
+}
+
+func (cfg *ApiConfig) GetAccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	tokens, code, err := cfg.DB.GetPersonalAccessTokens(userId)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	response := make([]AccessTokenResponseBody, 0, len(tokens))
+	for _, token := range tokens {
+		response = append(response, accessTokenResponse(token))
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *ApiConfig) RevokeAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	code, err := cfg.DB.RevokePersonalAccessToken(r.PathValue("id"), userId)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	w.WriteHeader(code)
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSessionRoutesRejectPersonalAccessTokens(t *testing.T) {
+	db, err := database.NewDBAt(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ApiConfig{DB: db, JWTSecret: "secret"}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/users/me/tokens", cfg.MiddlewareSession(http.HandlerFunc(cfg.CreateAccessTokenHandler)))
+	mux.Handle("GET /api/users/me/tokens", cfg.MiddlewareSession(http.HandlerFunc(cfg.GetAccessTokensHandler)))
+	mux.Handle("GET /api/users/me", cfg.MiddlewareScopes(http.HandlerFunc(cfg.GetOwnUserHandler), database.ScopeProfileRead))
+
+	user, _, err := db.CreateUsers("alice@example.com", []byte("Passw0rdX"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := helpers.GenerateJWTToken(user, cfg.JWTSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(t, mux, http.MethodPost, "/api/users/me/tokens", accessToken, CreateAccessTokenRequestBody{Name: "cli", Scopes: []string{database.ScopeProfileRead}})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+
+	created := AccessTokenResponseBody{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	// The token works where its scopes allow
+	rec = serve(t, mux, http.MethodGet, "/api/users/me", created.Token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("scoped route status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	for _, route := range []struct{ method, target string }{
+		{http.MethodPost, "/api/users/me/tokens"},
+		{http.MethodGet, "/api/users/me/tokens"},
+	} {
+		rec = serve(t, mux, route.method, route.target, created.Token, CreateAccessTokenRequestBody{Name: "more", Scopes: []string{database.ScopeProfileRead}})
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "requires a login session") {
+			t.Errorf("%s %s with a personal access token = %d %s, want %d", route.method, route.target, rec.Code, rec.Body, http.StatusForbidden)
+		}
+	}
+
+	// An unknown token is still unauthorized
+	rec = serve(t, mux, http.MethodGet, "/api/users/me/tokens", PersonalAccessTokenPrefix+"unknown", nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown token status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
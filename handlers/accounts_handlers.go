@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"chirpy/activitypub"
+	"chirpy/helpers"
+	"context"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"net/http"
+	"time"
+)
+
+type DeleteAccountRequestBody struct {
+	Password string `json:"password"`
+}
+
+type DeleteAccountResponseBody struct {
+	DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+}
+
+// DeleteAccountHandler schedules the caller's account for deletion once the grace period is over,
+// the password is asked again so a stolen access token alone can't do it
+func (cfg *ApiConfig) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	body := DeleteAccountRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, code, err := cfg.DB.GetUserById(userId)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	err = bcrypt.CompareHashAndPassword(user.Password, []byte(body.Password))
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusForbidden, "incorrect password")
+		return
+	}
+
+	user, code, err = cfg.DB.ScheduleUserDeletion(userId, time.Now().Add(cfg.AccountDeletionGrace))
+	if err != nil {
+		log.Printf("Error scheduling account deletion: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, code, DeleteAccountResponseBody{DeletionScheduledAt: *user.DeletionScheduledAt})
+}
+
+func (cfg *ApiConfig) CancelAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	code, err := cfg.DB.CancelUserDeletion(userId)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	w.WriteHeader(code)
+}
+
+// AdminDeleteUserHandler deletes an account right away, without a grace period
+func (cfg *ApiConfig) AdminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	adminId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userId := r.PathValue("id")
+	code, err := cfg.deleteAccount(userId)
+	if err != nil {
+		log.Printf("Error deleting user: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	log.Printf("Admin %s deleted user %s", adminId, userId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteAccount deletes the user, then cleans up what lives outside the database: realtime clients are told
+// the chirps are gone, remote servers that the actor is gone, and export archives and the avatar are removed
+func (cfg *ApiConfig) deleteAccount(userId string) (int, error) {
+	deleted, code, err := cfg.DB.DeleteUser(userId)
+	if err != nil {
+		return code, err
+	}
+
+	for _, chirp := range deleted.Chirps {
+		cfg.publishChirpDeleted(chirp)
+	}
+
+	for _, export := range deleted.Exports {
+		cfg.Exporter.Remove(export)
+	}
+	cfg.removeAvatar(deleted.User.Avatar, "")
+
+	// Without PUBLIC_URL there is no actor id to send the Delete as
+	baseURL, err := cfg.publicURL()
+	if deleted.ActorKey == nil || err != nil {
+		return http.StatusNoContent, nil
+	}
+
+	inboxes := make([]string, 0, len(deleted.RemoteFollowers)+len(deleted.RemoteFollowing))
+	for _, follower := range deleted.RemoteFollowers {
+		inboxes = append(inboxes, follower.Inbox)
+	}
+	for _, following := range deleted.RemoteFollowing {
+		inboxes = append(inboxes, following.Inbox)
+	}
+
+	if len(inboxes) == 0 {
+		return http.StatusNoContent, nil
+	}
+
+	// The key is gone from the database, the signer keeps a copy for the queued deliveries
+	actor := actorURL(baseURL, userId)
+	activity, err := activitypub.NewActivity(actor+"#delete", activitypub.TypeDelete, actor, actor)
+	if err != nil {
+		log.Printf("Error building activity: %s", err)
+		return http.StatusNoContent, nil
+	}
+	activity.To = []string{activitypub.Public}
+
+	signer := activitypub.Signer{KeyId: actor + "#main-key", PrivateKeyPem: deleted.ActorKey.PrivateKeyPem}
+	cfg.Federation.Deliver(activity, inboxes, signer)
+
+	return http.StatusNoContent, nil
+}
+
+// RunAccountDeletions deletes the accounts whose grace period is over, every interval until ctx is done
+func (cfg *ApiConfig) RunAccountDeletions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		due, err := cfg.DB.GetUsersDueForDeletion(time.Now())
+		if err != nil {
+			log.Printf("Error getting accounts to delete: %s", err)
+		}
+
+		for _, userId := range due {
+			if _, err := cfg.deleteAccount(userId); err != nil {
+				log.Printf("Error deleting user %s: %s", userId, err)
+				continue
+			}
+			log.Printf("Deleted user %s after their grace period", userId)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// AnalyticsDays is how far back analytics go for everyone, Chirpy Red members get AnalyticsDaysChirpyRed
+	AnalyticsDays          = 7
+	AnalyticsDaysChirpyRed = int(database.MaxAnalyticsHistory / (24 * time.Hour))
+	// AnalyticsMaxHourlyDays bounds the range of hourly buckets, so a response never holds thousands of them per chirp
+	AnalyticsMaxHourlyDays = 7
+)
+
+type AnalyticsResponseBody struct {
+	database.AuthorAnalytics
+	Bucket string `json:"bucket"`
+	// MaxDays is how far back the user can look, it's larger for Chirpy Red members
+	MaxDays int `json:"max_days"`
+}
+
+// recordViews counts the chirps as seen by whoever made the request. Anonymous viewers are told apart by their address
+func (cfg *ApiConfig) recordViews(r *http.Request, viewerId string, chirps ...database.Chirpy) {
+	if cfg.Views == nil {
+		return
+	}
+
+	viewer := viewerId
+	if viewer == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		viewer = "addr:" + host
+	}
+
+	cfg.Views.RecordViews(viewer, viewerId, chirps...)
+}
+
+// AnalyticsHandler returns how the caller's chirps performed over the last ?days=, in ?bucket=hour or day buckets
+func (cfg *ApiConfig) AnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	offset, limit, err := helpers.ParsePagination(r)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	isChirpyRed, err := cfg.DB.IsChirpyRed(userId)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	maxDays := AnalyticsDays
+	if isChirpyRed {
+		maxDays = AnalyticsDaysChirpyRed
+	}
+
+	days := AnalyticsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 1 {
+			helpers.RespondWithError(w, http.StatusBadRequest, "days must be a positive integer")
+			return
+		}
+	}
+
+	if days > maxDays {
+		if !isChirpyRed {
+			helpers.RespondWithError(w, http.StatusForbidden, fmt.Sprintf("More than %d days of analytics is only available to Chirpy Red members", AnalyticsDays))
+			return
+		}
+		helpers.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("days can't be more than %d", maxDays))
+		return
+	}
+
+	bucket := r.URL.Query().Get("bucket")
+	bucketSize := 24 * time.Hour
+	switch bucket {
+	case "", "day":
+		bucket = "day"
+	case "hour":
+		if days > AnalyticsMaxHourlyDays {
+			helpers.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("hourly buckets are limited to %d days", AnalyticsMaxHourlyDays))
+			return
+		}
+		bucketSize = time.Hour
+	default:
+		helpers.RespondWithError(w, http.StatusBadRequest, "bucket must be hour or day")
+		return
+	}
+
+	// The range is made of whole buckets ending with the current one, e.g. today and the 6 days before for a week
+	to := time.Now()
+	stats, err := cfg.DB.GetAuthorAnalytics(userId, to.Add(-time.Duration(days)*24*time.Hour+bucketSize), to, bucketSize)
+	if err != nil {
+		log.Printf("Error getting analytics: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	stats.Chirps = stats.Chirps[min(offset, len(stats.Chirps)):min(offset+limit, len(stats.Chirps))]
+
+	helpers.RespondWithJSON(w, http.StatusOK, AnalyticsResponseBody{
+		AuthorAnalytics: stats,
+		Bucket:          bucket,
+		MaxDays:         maxDays,
+	})
+}
@@ -1,15 +1,47 @@
 package handlers
 
 import (
+	"chirpy/activitypub"
+	"chirpy/analytics"
 	"chirpy/database"
+	"chirpy/events"
+	"chirpy/exports"
+	"chirpy/mail"
+	"chirpy/moderation"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 type ApiConfig struct {
 	FileServerHits int
 	DB             *database.DB
 	JWTSecret      string
+	// Chirp length limits per plan, see helpers.ChirpLength for how chirps are counted
+	ChirpMaxLength          int
+	ChirpMaxLengthChirpyRed int
+	Moderator               *moderation.Pipeline
+	// Events carries realtime events to streaming clients
+	Events *events.Broker
+	// Views counts chirp views for analytics
+	Views *analytics.Recorder
+	// Exporter builds data export archives
+	Exporter *exports.Exporter
+	// Federation talks to other ActivityPub servers
+	Federation *activitypub.Client
+	// PublicURL is where clients reach the server, e.g. https://chirpy.example.com, used for absolute links and federation ids.
+	// Feeds, federation and emails with links are off without it
+	PublicURL string
+	// AccountDeletionGrace is how long a user can still cancel the deletion of their account
+	AccountDeletionGrace time.Duration
+	// AvatarDir is where uploaded avatars are stored
+	AvatarDir string
+	// Mailer sends account emails like email verification
+	Mailer mail.Mailer
+	// RequireVerifiedEmail stops users from posting chirps until they verified their email
+	RequireVerifiedEmail bool
+	// ReportHideThreshold is how many users have to report a chirp before it's hidden pending review
+	ReportHideThreshold int
 }
 
 func (cfg *ApiConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
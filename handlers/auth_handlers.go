@@ -8,15 +8,21 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 )
 
 type LoginResponseBody struct {
-	Id           string `json:"id"`
-	Email        string `json:"email"`
-	Token        string `json:"token"`
-	RefreshToken string `json:"refresh_token"`
-	IsChirpyRed  bool   `json:"is_chirpy_red"`
+	Id            string `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Token         string `json:"token"`
+	RefreshToken  string `json:"refresh_token"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
+	Role          string `json:"role"`
+	// DeletionScheduledAt is set while the account is scheduled for deletion, so clients can offer to cancel it
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
 }
 
 func (cfg *ApiConfig) authenticateUser(r *http.Request, userRequest *UsersRequestBody) (database.User, int, error) {
@@ -47,21 +53,59 @@ func (cfg *ApiConfig) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	refreshToken, err := helpers.GenerateRefreshToken(user.Id)
+	if user.IsSuspended() {
+		log.Printf("suspended user %s tried to log in", user.Id)
+		helpers.RespondWithError(w, http.StatusForbidden, "your account is suspended until "+user.SuspendedUntil.Format(time.RFC3339))
+		return
+	}
+
+	// With two-factor authentication the password only gets a challenge, the tokens come with the code
+	if user.HasTwoFactor() {
+		cfg.respondWithMFAChallenge(w, user)
+		return
+	}
+
+	cfg.completeLogin(w, user)
+}
+
+// completeLogin starts a session for the authenticated user and responds with its tokens
+func (cfg *ApiConfig) completeLogin(w http.ResponseWriter, user database.User) {
+	accessToken, refreshToken, err := cfg.startSession(w, user)
 	if err != nil {
-		log.Printf("error generating refresh token: %s", err)
-		helpers.RespondWithError(w, code, err.Error())
+		log.Printf("error starting session: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	// Should probably also return its expiredAt
+	responseUser := LoginResponseBody{
+		Id:            user.Id,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Token:         accessToken,
+		RefreshToken:  refreshToken.Token,
+		IsChirpyRed:   user.IsChirpyRed,
+		Role:          user.RoleName(),
+
+		DeletionScheduledAt: user.DeletionScheduledAt,
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, responseUser)
+}
+
+// startSession stores a new refresh token for the user, sets its cookie and the Authorization header,
+// and returns it with a new access token
+func (cfg *ApiConfig) startSession(w http.ResponseWriter, user database.User) (string, database.RefreshToken, error) {
+	refreshToken, err := helpers.GenerateRefreshToken(user.Id)
+	if err != nil {
+		return "", database.RefreshToken{}, fmt.Errorf("error generating refresh token: %s", err)
+	}
+
 	err = cfg.DB.StoreRefreshToken(refreshToken)
 	if err != nil {
-		log.Printf("error storing refresh token: %s", err)
-		helpers.RespondWithError(w, code, err.Error())
-		return
+		return "", database.RefreshToken{}, fmt.Errorf("error storing refresh token: %s", err)
 	}
 
-	// After generating the refresh token
 	http.SetCookie(w, &http.Cookie{
 		Name:     "refresh_token",
 		Value:    refreshToken.Token,
@@ -70,25 +114,14 @@ func (cfg *ApiConfig) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		Expires:  refreshToken.ExpireAt,
 	})
 
-	accessToken, err := helpers.GenerateJWTToken(user.Id, cfg.JWTSecret)
+	accessToken, err := helpers.GenerateJWTToken(user, cfg.JWTSecret)
 	if err != nil {
-		log.Printf("Error generating token: %s", err)
-		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
-		return
+		return "", database.RefreshToken{}, fmt.Errorf("error generating token: %s", err)
 	}
 
 	w.Header().Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-	// Should probably also return its expiredAt
-	responseUser := LoginResponseBody{
-		Id:           user.Id,
-		Email:        user.Email,
-		Token:        accessToken,
-		RefreshToken: refreshToken.Token,
-		IsChirpyRed:  user.IsChirpyRed,
-	}
-
-	helpers.RespondWithJSON(w, http.StatusOK, responseUser)
+	return accessToken, refreshToken, nil
 }
 
 // RefreshHandler Generates a new access token using the refresh token
@@ -119,7 +152,21 @@ func (cfg *ApiConfig) RefreshHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, err := helpers.GenerateJWTToken(refreshToken.UserId, cfg.JWTSecret)
+	// The new access token carries the user's current role
+	user, _, err := cfg.DB.GetUserById(refreshToken.UserId)
+	if err != nil {
+		log.Printf("error getting user: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	if user.IsSuspended() {
+		log.Printf("suspended user %s tried to refresh their token", user.Id)
+		helpers.RespondWithError(w, http.StatusForbidden, "your account is suspended until "+user.SuspendedUntil.Format(time.RFC3339))
+		return
+	}
+
+	accessToken, err := helpers.GenerateJWTToken(user, cfg.JWTSecret)
 	if err != nil {
 		log.Printf("error generating token: %s", err)
 		helpers.RespondWithError(w, http.StatusUnauthorized, "invalid token")
@@ -159,7 +206,7 @@ func (cfg *ApiConfig) RevokeTokenHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (cfg *ApiConfig) validateJWTToken(tokenString string) (*jwt.Token, error) {
-	claims := &jwt.RegisteredClaims{}
+	claims := &helpers.AccessTokenClaims{}
 	mySigningKey := []byte(cfg.JWTSecret)
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(key *jwt.Token) (interface{}, error) {
@@ -184,3 +231,146 @@ func (cfg *ApiConfig) validateJWTToken(tokenString string) (*jwt.Token, error) {
 
 	return token, nil
 }
+
+// tokenAuth is who a bearer token authenticates, and for a personal access token what it may do
+type tokenAuth struct {
+	UserId string
+	// ExpiresAt is zero for personal access tokens that never expire
+	ExpiresAt time.Time
+	// Personal is set for personal access tokens, which may only do what their Scopes allow.
+	// Access tokens from logging in may do anything the user can
+	Personal  bool
+	Scopes    []string
+	TokenHash string
+	// Role and Permissions come from the claims of an access token from logging in,
+	// personal access tokens have no permissions
+	Role        string
+	Permissions []string
+}
+
+// missingScopes returns the scopes the token lacks, none for access tokens from logging in
+func (a tokenAuth) missingScopes(scopes ...string) []string {
+	if !a.Personal {
+		return nil
+	}
+
+	missing := []string{}
+	for _, scope := range scopes {
+		if !slices.Contains(a.Scopes, scope) {
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
+
+// missingPermissions returns the permissions the token lacks
+func (a tokenAuth) missingPermissions(permissions ...string) []string {
+	missing := []string{}
+	for _, permission := range permissions {
+		if !slices.Contains(a.Permissions, permission) {
+			missing = append(missing, permission)
+		}
+	}
+
+	return missing
+}
+
+// tokenAuthKey is the request context key MiddlewareScopes and MiddlewarePermissions store a checked token under
+type tokenAuthKey struct{}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// getUserIdFromRequest validates the bearer token and returns the user id it was issued for.
+// Personal access tokens are only accepted on routes that declare scopes with MiddlewareScopes
+func (cfg *ApiConfig) getUserIdFromRequest(r *http.Request) (string, error) {
+	auth, err := cfg.getAuthFromRequest(r)
+	if err != nil {
+		return "", err
+	}
+
+	return auth.UserId, nil
+}
+
+// getAuthFromRequest returns the validated token of the request, from the middleware if it ran already
+func (cfg *ApiConfig) getAuthFromRequest(r *http.Request) (tokenAuth, error) {
+	if auth, ok := r.Context().Value(tokenAuthKey{}).(tokenAuth); ok {
+		return auth, nil
+	}
+
+	auth, err := cfg.authenticateToken(bearerToken(r))
+	if err != nil {
+		return tokenAuth{}, err
+	}
+
+	if auth.Personal {
+		return tokenAuth{}, fmt.Errorf("personal access tokens can't be used for this endpoint")
+	}
+
+	return auth, nil
+}
+
+// authenticateToken validates an access token from logging in or a personal access token
+func (cfg *ApiConfig) authenticateToken(tokenString string) (tokenAuth, error) {
+	if strings.HasPrefix(tokenString, PersonalAccessTokenPrefix) {
+		return cfg.validatePersonalAccessToken(tokenString)
+	}
+
+	return cfg.validateAccessToken(tokenString)
+}
+
+// validateAccessToken returns the user id the access token from logging in was issued for,
+// when it expires and the role and permissions it carries
+func (cfg *ApiConfig) validateAccessToken(tokenString string) (tokenAuth, error) {
+	token, err := cfg.validateJWTToken(tokenString)
+	if err != nil {
+		return tokenAuth{}, err
+	}
+
+	claims, ok := token.Claims.(*helpers.AccessTokenClaims)
+	if !ok || claims.Subject == "" || claims.ExpiresAt == nil {
+		return tokenAuth{}, fmt.Errorf("invalid token")
+	}
+
+	// Access tokens outlive a deleted account by up to an hour
+	user, _, err := cfg.DB.GetUserById(claims.Subject)
+	if err != nil {
+		return tokenAuth{}, fmt.Errorf("invalid token")
+	}
+
+	if user.IsSuspended() {
+		return tokenAuth{}, fmt.Errorf("your account is suspended until %s", user.SuspendedUntil.Format(time.RFC3339))
+	}
+
+	// Tokens issued before a password change are stale, the response to the change carries new ones.
+	// iat only has second precision, so the change is compared to the second too
+	if user.PasswordChangedAt != nil {
+		if claims.IssuedAt == nil || claims.IssuedAt.Before(user.PasswordChangedAt.Truncate(time.Second)) {
+			return tokenAuth{}, fmt.Errorf("invalid token")
+		}
+	}
+
+	// Tokens carrying another role than the user has now are stale too, refreshing gets one with the new role
+	if claims.Role != user.RoleName() {
+		return tokenAuth{}, fmt.Errorf("invalid token")
+	}
+
+	return tokenAuth{
+		UserId:      claims.Subject,
+		ExpiresAt:   claims.ExpiresAt.Time,
+		Role:        claims.Role,
+		Permissions: claims.Permissions,
+	}, nil
+}
+
+// getOptionalUserId is getUserIdFromRequest for endpoints that also serve anonymous users,
+// it returns an empty id if the request carries no Authorization header at all
+func (cfg *ApiConfig) getOptionalUserId(r *http.Request) (string, error) {
+	if r.Header.Get("Authorization") == "" {
+		return "", nil
+	}
+
+	return cfg.getUserIdFromRequest(r)
+}
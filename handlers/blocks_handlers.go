@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"log"
+	"net/http"
+)
+
+// UserListResponseBody is a page of the caller's blocked or muted users
+type UserListResponseBody struct {
+	Users  []database.UserListEntry `json:"users"`
+	Total  int                      `json:"total"`
+	Offset int                      `json:"offset"`
+	Limit  int                      `json:"limit"`
+}
+
+func (cfg *ApiConfig) BlockHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	code, err := cfg.DB.Block(userId, r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error blocking user: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) UnblockHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	err = cfg.DB.Unblock(userId, r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error unblocking user: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) MuteHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	code, err := cfg.DB.Mute(userId, r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error muting user: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) UnmuteHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	err = cfg.DB.Unmute(userId, r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error unmuting user: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) GetBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithOwnUserList(w, r, cfg.DB.GetBlocks)
+}
+
+func (cfg *ApiConfig) GetMutesHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithOwnUserList(w, r, cfg.DB.GetMutes)
+}
+
+// respondWithOwnUserList responds with a page of the caller's blocked or muted users, nobody else can see these lists
+func (cfg *ApiConfig) respondWithOwnUserList(w http.ResponseWriter, r *http.Request, getList func(string, int, int) ([]database.UserListEntry, int, error)) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	offset, limit, err := helpers.ParsePagination(r)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	users, total, err := getList(userId, offset, limit)
+	if err != nil {
+		log.Printf("Error getting user list: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, UserListResponseBody{
+		Users:  users,
+		Total:  total,
+		Offset: offset,
+		Limit:  limit,
+	})
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type BookmarkRequestBody struct {
+	FolderId string `json:"folder_id"`
+}
+
+type BookmarksResponseBody struct {
+	Bookmarks []database.BookmarkedChirp `json:"bookmarks"`
+	Total     int                        `json:"total"`
+	Offset    int                        `json:"offset"`
+	Limit     int                        `json:"limit"`
+}
+
+type BookmarkFolderRequestBody struct {
+	Name string `json:"name"`
+}
+
+// requireChirpyRed responds with 403 and returns false if the user is not a Chirpy Red member
+func (cfg *ApiConfig) requireChirpyRed(w http.ResponseWriter, userId string) bool {
+	isChirpyRed, err := cfg.DB.IsChirpyRed(userId)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+
+	if !isChirpyRed {
+		helpers.RespondWithError(w, http.StatusForbidden, "This feature is only available to Chirpy Red members")
+		return false
+	}
+
+	return true
+}
+
+func (cfg *ApiConfig) AddBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chirpId, err := strconv.Atoi(r.PathValue("chirpId"))
+	if err != nil {
+		log.Printf("Error: %s", err)
+		helpers.RespondWithError(w, http.StatusBadRequest, "Error getting chirp: "+err.Error())
+		return
+	}
+
+	// The body is optional, a bookmark without a folder needs none
+	body := BookmarkRequestBody{}
+	if r.ContentLength != 0 {
+		err = helpers.RequestBodyValidator(r, &body)
+		if err != nil {
+			helpers.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	if body.FolderId != "" && !cfg.requireChirpyRed(w, userId) {
+		return
+	}
+
+	bookmark, code, err := cfg.DB.AddBookmark(userId, chirpId, body.FolderId)
+	if err != nil {
+		log.Printf("Error adding bookmark: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, code, bookmark)
+}
+
+func (cfg *ApiConfig) RemoveBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chirpId, err := strconv.Atoi(r.PathValue("chirpId"))
+	if err != nil {
+		log.Printf("Error: %s", err)
+		helpers.RespondWithError(w, http.StatusBadRequest, "Error getting chirp: "+err.Error())
+		return
+	}
+
+	err = cfg.DB.RemoveBookmark(userId, chirpId)
+	if err != nil {
+		log.Printf("Error removing bookmark: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) GetBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	offset, limit, err := helpers.ParsePagination(r)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	folderId := r.URL.Query().Get("folder_id")
+
+	bookmarks, total, err := cfg.DB.GetBookmarks(userId, folderId, offset, limit)
+	if err != nil {
+		log.Printf("Error getting bookmarks: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, BookmarksResponseBody{
+		Bookmarks: bookmarks,
+		Total:     total,
+		Offset:    offset,
+		Limit:     limit,
+	})
+}
+
+func (cfg *ApiConfig) CreateBookmarkFolderHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if !cfg.requireChirpyRed(w, userId) {
+		return
+	}
+
+	body := BookmarkFolderRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > 50 {
+		helpers.RespondWithError(w, http.StatusBadRequest, "Folder name must be between 1 and 50 characters")
+		return
+	}
+
+	folder, code, err := cfg.DB.CreateBookmarkFolder(userId, name)
+	if err != nil {
+		log.Printf("Error creating bookmark folder: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, code, folder)
+}
+
+func (cfg *ApiConfig) GetBookmarkFoldersHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	folders, err := cfg.DB.GetBookmarkFolders(userId)
+	if err != nil {
+		log.Printf("Error getting bookmark folders: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, folders)
+}
+
+func (cfg *ApiConfig) DeleteBookmarkFolderHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	code, err := cfg.DB.DeleteBookmarkFolder(userId, r.PathValue("folderId"))
+	if err != nil {
+		log.Printf("Error deleting bookmark folder: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
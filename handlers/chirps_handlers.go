@@ -1,35 +1,51 @@
 package handlers
 
 import (
+	"chirpy/database"
 	"chirpy/helpers"
+	"chirpy/moderation"
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 )
 
+// AuthorChirpsResponseBody is returned when chirps are filtered by author,
+// pinned chirps are listed on top and still show up in chirps
+type AuthorChirpsResponseBody struct {
+	Pinned []database.Chirpy `json:"pinned"`
+	Chirps []database.Chirpy `json:"chirps"`
+}
+
+// ChirpValidationError tells the client why a chirp body was rejected,
+// lengths are counted the same way as helpers.ChirpLength
+type ChirpValidationError struct {
+	Error  string `json:"error"`
+	Code   string `json:"code"`
+	Length int    `json:"length"`
+	Limit  int    `json:"limit"`
+	OverBy int    `json:"over_by,omitempty"`
+}
+
+type ChirpRejectedResponseBody struct {
+	Error   string   `json:"error"`
+	Filters []string `json:"filters"`
+}
+
 type ChirpsRequestBody struct {
-	Body string `json:"body"`
+	Body       string           `json:"body"`
+	Visibility string           `json:"visibility"`
+	Poll       *PollRequestBody `json:"poll"`
 }
 
 func (cfg *ApiConfig) PostChirpsHandler(w http.ResponseWriter, r *http.Request) {
-	requestHeader := r.Header.Get("Authorization")
-	tokenString := strings.TrimPrefix(requestHeader, "Bearer ")
-
-	token, err := cfg.validateJWTToken(tokenString)
+	userId, err := cfg.getUserIdFromRequest(r)
 	if err != nil {
 		log.Printf("Error validating JWT: %s", err)
 		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
 
-	userId, err := token.Claims.GetSubject()
-	if err != nil {
-		log.Printf("Error getting user id: %s", err)
-		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
-		return
-	}
-
 	body := ChirpsRequestBody{}
 	err = helpers.RequestBodyValidator(r, &body)
 	if err != nil {
@@ -38,42 +54,114 @@ func (cfg *ApiConfig) PostChirpsHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if len(body.Body) > 140 {
-		log.Printf("Error request body's length exceed 140")
-		helpers.RespondWithError(w, http.StatusBadRequest, "Chirp is too long")
+	body.Body = helpers.NormalizeChirp(body.Body)
+
+	user, code, err := cfg.DB.GetUserById(userId)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		helpers.RespondWithError(w, code, "Error creating chirp")
+		return
+	}
+
+	if cfg.RequireVerifiedEmail && !user.EmailVerified {
+		helpers.RespondWithError(w, http.StatusForbidden, "Verify your email before posting chirps")
 		return
 	}
 
-	respBody := replaceProfaneWord(body.Body)
+	limit := cfg.ChirpMaxLength
+	if user.IsChirpyRed {
+		limit = cfg.ChirpMaxLengthChirpyRed
+	}
 
-	chirp, err := cfg.DB.CreateChirps(respBody, userId)
+	length := helpers.ChirpLength(body.Body)
+	if length == 0 {
+		helpers.RespondWithJSON(w, http.StatusBadRequest, ChirpValidationError{
+			Error: "Chirp is empty",
+			Code:  "chirp_empty",
+			Limit: limit,
+		})
+		return
+	}
+
+	if length > limit {
+		log.Printf("Error request body's length %d exceed %d", length, limit)
+		helpers.RespondWithJSON(w, http.StatusBadRequest, ChirpValidationError{
+			Error:  "Chirp is too long",
+			Code:   "chirp_too_long",
+			Length: length,
+			Limit:  limit,
+			OverBy: length - limit,
+		})
+		return
+	}
+
+	if body.Visibility == "" {
+		body.Visibility = database.VisibilityPublic
+	}
+
+	if !database.IsValidVisibility(body.Visibility) {
+		log.Printf("Invalid chirp visibility: %s", body.Visibility)
+		helpers.RespondWithError(w, http.StatusBadRequest, "Visibility must be public, followers or private")
+		return
+	}
+
+	var poll *database.Poll
+	if body.Poll != nil {
+		poll, err = validatePoll(*body.Poll)
+		if err != nil {
+			log.Printf("Invalid poll: %s", err)
+			helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	respBody, verdict := cfg.Moderator.Moderate(body.Body)
+	if poll != nil {
+		verdict = verdict.Merge(cfg.moderatePoll(poll))
+	}
+	if verdict.Action == moderation.ActionReject {
+		log.Printf("Chirp rejected by moderation filters: %v", verdict.Filters)
+		helpers.RespondWithJSON(w, http.StatusBadRequest, ChirpRejectedResponseBody{
+			Error:   "Chirp was rejected by moderation",
+			Filters: verdict.Filters,
+		})
+		return
+	}
+
+	var storedVerdict *database.ModerationVerdict
+	if verdict.Action != moderation.ActionAllow {
+		storedVerdict = &database.ModerationVerdict{
+			Action:  string(verdict.Action),
+			Filters: verdict.Filters,
+			At:      time.Now().UTC(),
+		}
+	}
+
+	chirp, mentioned, err := cfg.DB.CreateChirps(respBody, userId, body.Visibility, poll, storedVerdict, helpers.ParseMentions(respBody))
 	if err != nil {
 		log.Printf("Error creating chirp: %s", err)
 		helpers.RespondWithError(w, http.StatusInternalServerError, "Error creating chirp")
 		return
 	}
 
+	for _, mentionedId := range mentioned {
+		cfg.publishNotificationsUpdated(mentionedId)
+	}
+
+	cfg.publishChirpCreated(chirp)
+	cfg.federateChirpCreated(chirp)
+
 	helpers.RespondWithJSON(w, http.StatusCreated, chirp)
 }
 
 func (cfg *ApiConfig) DeleteChirpsHandler(w http.ResponseWriter, r *http.Request) {
-	requestHeader := r.Header.Get("Authorization")
-	tokenString := strings.TrimPrefix(requestHeader, "Bearer ")
-
-	token, err := cfg.validateJWTToken(tokenString)
+	userId, err := cfg.getUserIdFromRequest(r)
 	if err != nil {
 		log.Printf("Error validating JWT: %s", err)
 		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
 
-	userId, err := token.Claims.GetSubject()
-	if err != nil {
-		log.Printf("Error getting user id: %s", err)
-		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
-		return
-	}
-
 	pathValue := r.PathValue("id")
 	id, err := strconv.Atoi(pathValue)
 	if err != nil {
@@ -82,10 +170,10 @@ func (cfg *ApiConfig) DeleteChirpsHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	chirp, err := cfg.DB.GetChirp(id)
+	chirp, code, err := cfg.DB.GetVisibleChirp(id, userId)
 	if err != nil {
 		log.Printf("Error getting chirp: %s", err)
-		helpers.RespondWithError(w, http.StatusBadRequest, "Error getting chirp: "+err.Error())
+		helpers.RespondWithError(w, code, "Error getting chirp: "+err.Error())
 		return
 	}
 
@@ -102,52 +190,66 @@ func (cfg *ApiConfig) DeleteChirpsHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	cfg.publishChirpDeleted(chirp)
+	cfg.federateChirpDeleted(chirp)
+
 	w.WriteHeader(http.StatusNoContent)
 	return
 }
 
-func replaceProfaneWord(s string) string {
-	badWord := []string{"kerfuffle", "sharbert", "fornax"}
-	dirtyS := strings.Split(strings.ToLower(s), " ")
-
-	for _, b := range badWord {
-		for i, d := range dirtyS {
-			if strings.Contains(d, b) {
-				dirtyS[i] = strings.Replace(dirtyS[i], d, "****", -1)
-			}
-		}
+func (cfg *ApiConfig) GetChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	viewerId, err := cfg.getOptionalUserId(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
 	}
 
-	return strings.Join(dirtyS, " ")
-}
-
-func (cfg *ApiConfig) GetChirpsHandler(w http.ResponseWriter, r *http.Request) {
 	authorId := r.URL.Query().Get("author_id")
 	method := r.URL.Query().Get("sort")
 
 	if authorId != "" {
-		chirps, err := cfg.DB.GetChirpByAuthor(authorId, method)
+		chirps, err := cfg.DB.GetChirpByAuthor(authorId, method, viewerId)
 		if err != nil {
 			log.Printf("Error getting chirp: %s", err)
 			helpers.RespondWithError(w, http.StatusInternalServerError, "Error getting chirp: "+err.Error())
 			return
 		}
 
-		helpers.RespondWithJSON(w, http.StatusOK, chirps)
+		pinned, err := cfg.DB.GetPinnedChirps(authorId, viewerId)
+		if err != nil {
+			log.Printf("Error getting pinned chirps: %s", err)
+			helpers.RespondWithError(w, http.StatusInternalServerError, "Error getting chirp: "+err.Error())
+			return
+		}
+
+		cfg.recordViews(r, viewerId, pinned...)
+		cfg.recordViews(r, viewerId, chirps...)
+
+		helpers.RespondWithJSON(w, http.StatusOK, AuthorChirpsResponseBody{Pinned: pinned, Chirps: chirps})
 		return
 	}
 
-	chirps, err := cfg.DB.GetChirps(method)
+	chirps, err := cfg.DB.GetChirps(method, viewerId)
 	if err != nil {
 		log.Printf("Error getting chirp: %s", err)
 		helpers.RespondWithError(w, http.StatusInternalServerError, "Error getting chirp: "+err.Error())
 		return
 	}
 
+	cfg.recordViews(r, viewerId, chirps...)
+
 	helpers.RespondWithJSON(w, http.StatusOK, chirps)
 }
 
 func (cfg *ApiConfig) GetChirpHandler(w http.ResponseWriter, r *http.Request) {
+	viewerId, err := cfg.getOptionalUserId(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
 	pathValue := r.PathValue("id")
 	id, err := strconv.Atoi(pathValue)
 	if err != nil {
@@ -156,12 +258,15 @@ func (cfg *ApiConfig) GetChirpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chirp, err := cfg.DB.GetChirp(id)
+	// Chirps the viewer is not allowed to see are a 404, not a 403
+	chirp, code, err := cfg.DB.GetVisibleChirp(id, viewerId)
 	if err != nil {
 		log.Printf("Error getting chirp: %s", err)
-		helpers.RespondWithError(w, http.StatusBadRequest, "Error getting chirp: "+err.Error())
+		helpers.RespondWithError(w, code, "Error getting chirp: "+err.Error())
 		return
 	}
 
+	cfg.recordViews(r, viewerId, chirp)
+
 	helpers.RespondWithJSON(w, http.StatusOK, chirp)
 }
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/exports"
+	"chirpy/helpers"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ExportDownloadTTL is how long a download link stays valid, polling the export again returns a fresh one
+const ExportDownloadTTL = 15 * time.Minute
+
+type DataExportResponseBody struct {
+	Id          string     `json:"id"`
+	Status      string     `json:"status"`
+	RequestedAt time.Time  `json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	// DownloadURL is set once the export is ready, it can be used without a token until DownloadExpiresAt
+	DownloadURL       string     `json:"download_url,omitempty"`
+	DownloadExpiresAt *time.Time `json:"download_expires_at,omitempty"`
+	// AvailableUntil is when the archive is deleted
+	AvailableUntil *time.Time `json:"available_until,omitempty"`
+}
+
+// exportSignature signs the export id together with the expiry of the link, so neither can be changed
+func (cfg *ApiConfig) exportSignature(exportId string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(cfg.JWTSecret))
+	mac.Write([]byte("export:" + exportId + ":" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (cfg *ApiConfig) exportResponse(export database.DataExport) DataExportResponseBody {
+	response := DataExportResponseBody{
+		Id:          export.Id,
+		Status:      export.Status,
+		RequestedAt: export.RequestedAt,
+		CompletedAt: export.CompletedAt,
+		Error:       export.Error,
+	}
+
+	if export.Status != database.ExportReady {
+		return response
+	}
+
+	availableUntil := export.CompletedAt.Add(exports.Retention)
+	expiresAt := time.Now().Add(ExportDownloadTTL).Truncate(time.Second)
+	if expiresAt.After(availableUntil) {
+		expiresAt = availableUntil
+	}
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("signature", cfg.exportSignature(export.Id, expiresAt.Unix()))
+
+	// Without PUBLIC_URL the link is relative to the server
+	baseURL, _ := cfg.publicURL()
+	response.DownloadURL = baseURL + "/api/exports/" + export.Id + "/download?" + query.Encode()
+	response.DownloadExpiresAt = &expiresAt
+	response.AvailableUntil = &availableUntil
+
+	return response
+}
+
+// RequestDataExportHandler queues an archive of the caller's data, poll GetDataExportHandler until it's ready
+func (cfg *ApiConfig) RequestDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	export, code, err := cfg.DB.CreateDataExport(userId)
+	if err != nil {
+		log.Printf("Error creating export: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	cfg.Exporter.Enqueue(export.Id)
+
+	w.Header().Set("Location", "/api/users/me/exports/"+export.Id)
+	helpers.RespondWithJSON(w, code, cfg.exportResponse(export))
+}
+
+func (cfg *ApiConfig) GetDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	export, code, err := cfg.DB.GetDataExport(r.PathValue("id"), userId)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, cfg.exportResponse(export))
+}
+
+// DownloadDataExportHandler serves the archive to anyone holding a valid signed link, no token is needed
+// so the link can be opened straight from a browser
+func (cfg *ApiConfig) DownloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	exportId := r.PathValue("id")
+
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusForbidden, "invalid download link")
+		return
+	}
+
+	signature := r.URL.Query().Get("signature")
+	if !hmac.Equal([]byte(signature), []byte(cfg.exportSignature(exportId, expires))) {
+		helpers.RespondWithError(w, http.StatusForbidden, "invalid download link")
+		return
+	}
+
+	if time.Now().Unix() > expires {
+		helpers.RespondWithError(w, http.StatusForbidden, "download link has expired")
+		return
+	}
+
+	export, code, err := cfg.DB.GetReadyDataExport(exportId)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export-`+export.RequestedAt.Format("2006-01-02")+`.zip"`)
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeFile(w, r, export.File)
+}
@@ -0,0 +1,752 @@
+package handlers
+
+import (
+	"chirpy/activitypub"
+	"chirpy/database"
+	"chirpy/helpers"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ActivityPub federation: every user is a Person actor at /users/{id} and each public chirp is a Note at /notes/{id}.
+// Ids are built from PublicURL, so it must stay the same once other servers know about us. Federation is off without it
+
+const maxInboxBodySize = 1 << 20
+
+type FollowRemoteRequestBody struct {
+	// Account is the remote account as user@host
+	Account string `json:"account"`
+}
+
+type RemoteNotesResponseBody struct {
+	Notes  []database.RemoteNote `json:"notes"`
+	Total  int                   `json:"total"`
+	Offset int                   `json:"offset"`
+	Limit  int                   `json:"limit"`
+}
+
+func actorURL(baseURL string, userId string) string {
+	return baseURL + "/users/" + userId
+}
+
+func noteURL(baseURL string, chirpId int) string {
+	return fmt.Sprintf("%s/notes/%d", baseURL, chirpId)
+}
+
+// chirpIdFromNoteURL returns the id of the chirp the local note url points to
+func chirpIdFromNoteURL(baseURL string, id string) (int, bool) {
+	rest, ok := strings.CutPrefix(id, baseURL+"/notes/")
+	if !ok {
+		return 0, false
+	}
+
+	chirpId, err := strconv.Atoi(rest)
+	return chirpId, err == nil
+}
+
+// preferredUsername is the handle, or the user id for users who haven't picked one
+func preferredUsername(user database.PublicUser) string {
+	if user.Handle != "" {
+		return user.Handle
+	}
+
+	return user.Id
+}
+
+func respondWithActivityJSON(w http.ResponseWriter, contentType string, payload any) {
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshalling json: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(dat)
+	if err != nil {
+		log.Printf("Error writting data: %s", err)
+	}
+}
+
+// actorKey returns the user's key pair, it's created the first time the user federates
+func (cfg *ApiConfig) actorKey(userId string) (database.ActorKey, error) {
+	key, ok, err := cfg.DB.GetActorKey(userId)
+	if err != nil || ok {
+		return key, err
+	}
+
+	publicPem, privatePem, err := activitypub.GenerateKeyPair()
+	if err != nil {
+		return database.ActorKey{}, err
+	}
+
+	return cfg.DB.StoreActorKey(userId, database.ActorKey{PublicKeyPem: publicPem, PrivateKeyPem: privatePem})
+}
+
+func (cfg *ApiConfig) signer(baseURL string, userId string) (activitypub.Signer, error) {
+	key, err := cfg.actorKey(userId)
+	if err != nil {
+		return activitypub.Signer{}, err
+	}
+
+	return activitypub.Signer{KeyId: actorURL(baseURL, userId) + "#main-key", PrivateKeyPem: key.PrivateKeyPem}, nil
+}
+
+// deliver signs the activity as the user and queues it for the inboxes
+func (cfg *ApiConfig) deliver(baseURL string, userId string, activity activitypub.Activity, inboxes []string) {
+	signer, err := cfg.signer(baseURL, userId)
+	if err != nil {
+		log.Printf("Error getting actor key: %s", err)
+		return
+	}
+
+	cfg.Federation.Deliver(activity, inboxes, signer)
+}
+
+func chirpNote(baseURL string, chirp database.Chirpy) activitypub.Note {
+	actor := actorURL(baseURL, chirp.UserId)
+
+	return activitypub.Note{
+		Id:           noteURL(baseURL, chirp.Id),
+		Type:         activitypub.TypeNote,
+		AttributedTo: actor,
+		Content:      activitypub.TextToHTML(chirp.Body),
+		Published:    chirpTime(chirp),
+		Url:          fmt.Sprintf("%s/api/chirps/%d", baseURL, chirp.Id),
+		To:           []string{activitypub.Public},
+		Cc:           []string{actor + "/followers"},
+	}
+}
+
+func chirpCreateActivity(baseURL string, chirp database.Chirpy) (activitypub.Activity, error) {
+	note := chirpNote(baseURL, chirp)
+
+	activity, err := activitypub.NewActivity(note.Id+"/activity", activitypub.TypeCreate, note.AttributedTo, note)
+	if err != nil {
+		return activitypub.Activity{}, err
+	}
+
+	activity.To = note.To
+	activity.Cc = note.Cc
+	activity.Published = &note.Published
+
+	return activity, nil
+}
+
+// isFederated returns true for chirps shared with other servers, only public chirps anyone can see are
+func isFederated(chirp database.Chirpy) bool {
+	if chirp.Visibility != database.VisibilityPublic {
+		return false
+	}
+
+	return chirp.Moderation == nil || (chirp.Moderation.Action != database.ModerationHeld && chirp.Moderation.Action != database.ModerationHidden)
+}
+
+// remoteFollowerInboxes returns where to deliver the user's activities, nil if nobody remote follows them
+func (cfg *ApiConfig) remoteFollowerInboxes(userId string) []string {
+	followers, err := cfg.DB.GetRemoteFollowers(userId)
+	if err != nil {
+		log.Printf("Error getting remote followers: %s", err)
+		return nil
+	}
+
+	inboxes := make([]string, 0, len(followers))
+	for _, f := range followers {
+		inboxes = append(inboxes, f.Inbox)
+	}
+
+	return inboxes
+}
+
+// federateChirpCreated sends a new public chirp to the author's remote followers
+func (cfg *ApiConfig) federateChirpCreated(chirp database.Chirpy) {
+	if !isFederated(chirp) {
+		return
+	}
+
+	inboxes := cfg.remoteFollowerInboxes(chirp.UserId)
+	if len(inboxes) == 0 {
+		return
+	}
+
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		return
+	}
+
+	activity, err := chirpCreateActivity(baseURL, chirp)
+	if err != nil {
+		log.Printf("Error building activity: %s", err)
+		return
+	}
+
+	cfg.deliver(baseURL, chirp.UserId, activity, inboxes)
+}
+
+// federateChirpDeleted tells the author's remote followers a chirp they received is gone
+func (cfg *ApiConfig) federateChirpDeleted(chirp database.Chirpy) {
+	if !isFederated(chirp) {
+		return
+	}
+
+	inboxes := cfg.remoteFollowerInboxes(chirp.UserId)
+	if len(inboxes) == 0 {
+		return
+	}
+
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		return
+	}
+
+	id := noteURL(baseURL, chirp.Id)
+	actor := actorURL(baseURL, chirp.UserId)
+
+	activity, err := activitypub.NewActivity(id+"#delete", activitypub.TypeDelete, actor, map[string]string{"id": id, "type": "Tombstone"})
+	if err != nil {
+		log.Printf("Error building activity: %s", err)
+		return
+	}
+	activity.To = []string{activitypub.Public}
+
+	cfg.deliver(baseURL, chirp.UserId, activity, inboxes)
+}
+
+// WebFingerHandler resolves acct:<user id>@<host> to the user's actor
+func (cfg *ApiConfig) WebFingerHandler(w http.ResponseWriter, r *http.Request) {
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	resource := r.URL.Query().Get("resource")
+
+	// Accounts are looked up by handle, or by user id for users without one
+	var user database.PublicUser
+	code, err := http.StatusNotFound, fmt.Errorf("user not found")
+	if acct, ok := strings.CutPrefix(resource, "acct:"); ok {
+		name, _, _ := strings.Cut(acct, "@")
+		user, code, err = cfg.DB.GetPublicUserByHandle(name)
+		if code == http.StatusNotFound {
+			user, code, err = cfg.DB.GetPublicUser(name)
+		}
+	} else if userId, ok := strings.CutPrefix(resource, baseURL+"/users/"); ok {
+		user, code, err = cfg.DB.GetPublicUser(userId)
+	}
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	base, _ := url.Parse(baseURL)
+	actor := actorURL(baseURL, user.Id)
+
+	respondWithActivityJSON(w, "application/jrd+json", activitypub.WebFinger{
+		Subject: "acct:" + preferredUsername(user) + "@" + base.Host,
+		Aliases: []string{actor},
+		Links: []activitypub.WebFingerLink{
+			{Rel: "self", Type: activitypub.ContentType, Href: actor},
+		},
+	})
+}
+
+func (cfg *ApiConfig) ActorHandler(w http.ResponseWriter, r *http.Request) {
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	userId := r.PathValue("id")
+
+	user, code, err := cfg.DB.GetPublicUser(userId)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	key, err := cfg.actorKey(userId)
+	if err != nil {
+		log.Printf("Error getting actor key: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	actor := actorURL(baseURL, userId)
+
+	var icon *activitypub.Image
+	if avatar := cfg.avatarURL(user); avatar != "" {
+		icon = &activitypub.Image{Type: "Image", MediaType: mime.TypeByExtension(filepath.Ext(user.Avatar)), Url: avatar}
+	}
+
+	summary := ""
+	if user.Bio != "" {
+		summary = activitypub.TextToHTML(user.Bio)
+	}
+
+	respondWithActivityJSON(w, activitypub.ContentType, activitypub.Actor{
+		Context:           []string{activitypub.ActivityStreamsContext, activitypub.SecurityContext},
+		Id:                actor,
+		Type:              "Person",
+		PreferredUsername: preferredUsername(user),
+		Name:              user.DisplayName,
+		Summary:           summary,
+		Icon:              icon,
+		Url:               baseURL + "/api/chirps?author_id=" + userId,
+		Inbox:             actor + "/inbox",
+		Outbox:            actor + "/outbox",
+		Followers:         actor + "/followers",
+		PublicKey: activitypub.PublicKey{
+			Id:           actor + "#main-key",
+			Owner:        actor,
+			PublicKeyPem: key.PublicKeyPem,
+		},
+	})
+}
+
+// OutboxHandler lists the user's most recent public chirps as Create activities
+func (cfg *ApiConfig) OutboxHandler(w http.ResponseWriter, r *http.Request) {
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	userId := r.PathValue("id")
+
+	if _, code, err := cfg.DB.GetUserById(userId); err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	chirps, err := cfg.DB.GetChirpByAuthor(userId, "desc", "")
+	if err != nil {
+		log.Printf("Error getting chirps: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	outbox := activitypub.OrderedCollection{
+		Context:      activitypub.ActivityStreamsContext,
+		Id:           actorURL(baseURL, userId) + "/outbox",
+		Type:         "OrderedCollection",
+		TotalItems:   len(chirps),
+		OrderedItems: make([]any, 0, min(len(chirps), FeedMaxEntries)),
+	}
+
+	for _, c := range chirps {
+		if len(outbox.OrderedItems) == FeedMaxEntries {
+			break
+		}
+
+		activity, err := chirpCreateActivity(baseURL, c)
+		if err != nil {
+			log.Printf("Error building activity: %s", err)
+			continue
+		}
+		activity.Context = nil
+		outbox.OrderedItems = append(outbox.OrderedItems, activity)
+	}
+
+	respondWithActivityJSON(w, activitypub.ContentType, outbox)
+}
+
+func (cfg *ApiConfig) RemoteFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	userId := r.PathValue("id")
+
+	if _, code, err := cfg.DB.GetUserById(userId); err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	followers, err := cfg.DB.GetRemoteFollowers(userId)
+	if err != nil {
+		log.Printf("Error getting remote followers: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	collection := activitypub.OrderedCollection{
+		Context:      activitypub.ActivityStreamsContext,
+		Id:           actorURL(baseURL, userId) + "/followers",
+		Type:         "OrderedCollection",
+		TotalItems:   len(followers),
+		OrderedItems: make([]any, 0, len(followers)),
+	}
+	for _, f := range followers {
+		collection.OrderedItems = append(collection.OrderedItems, f.ActorId)
+	}
+
+	respondWithActivityJSON(w, activitypub.ContentType, collection)
+}
+
+func (cfg *ApiConfig) NoteHandler(w http.ResponseWriter, r *http.Request) {
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	chirpId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	chirp, _, err := cfg.DB.GetVisibleChirp(chirpId, "")
+	if err != nil || !isFederated(chirp) {
+		helpers.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("chirp with id %v not found", chirpId))
+		return
+	}
+
+	note := chirpNote(baseURL, chirp)
+	note.Context = activitypub.ActivityStreamsContext
+
+	respondWithActivityJSON(w, activitypub.ContentType, note)
+}
+
+// InboxHandler receives activities from other servers, they must be signed by their actor.
+// Activities chirpy has no use for are accepted and dropped
+func (cfg *ApiConfig) InboxHandler(w http.ResponseWriter, r *http.Request) {
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	userId := r.PathValue("id")
+
+	if _, code, err := cfg.DB.GetUserById(userId); err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxInboxBodySize))
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "error reading body")
+		return
+	}
+
+	activity := activitypub.Activity{}
+	if err := json.Unmarshal(body, &activity); err != nil || activity.Actor == "" {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid activity")
+		return
+	}
+
+	if err := cfg.Federation.VerifyRequest(r, body, activity.Actor); err != nil {
+		log.Printf("Error verifying signature: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	code, err := cfg.handleActivity(baseURL, userId, activity)
+	if err != nil {
+		log.Printf("Error handling %s activity: %s", activity.Type, err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *ApiConfig) handleActivity(baseURL string, userId string, activity activitypub.Activity) (int, error) {
+	me := actorURL(baseURL, userId)
+
+	switch activity.Type {
+	case activitypub.TypeFollow:
+		if activity.ObjectId() != me {
+			return http.StatusBadRequest, fmt.Errorf("follow is not for %s", me)
+		}
+
+		remote, err := cfg.Federation.FetchActor(activity.Actor, false)
+		if err != nil {
+			return http.StatusBadGateway, err
+		}
+
+		code, err := cfg.DB.AddRemoteFollower(userId, database.RemoteFollower{ActorId: remote.Id, Inbox: remote.DeliveryInbox(), FollowedAt: time.Now().UTC()})
+		if err != nil {
+			return code, err
+		}
+
+		accept, err := activitypub.NewActivity(me+"#accepts/"+uuid.NewString(), activitypub.TypeAccept, me, activity)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		cfg.deliver(baseURL, userId, accept, []string{remote.Inbox})
+
+	case activitypub.TypeUndo:
+		inner, ok := activity.EmbeddedActivity()
+		if !ok || inner.Actor != activity.Actor {
+			return http.StatusAccepted, nil
+		}
+
+		switch inner.Type {
+		case activitypub.TypeFollow:
+			if err := cfg.DB.RemoveRemoteFollower(userId, activity.Actor); err != nil {
+				return http.StatusInternalServerError, err
+			}
+		case activitypub.TypeLike:
+			if chirpId, ok := chirpIdFromNoteURL(baseURL, inner.ObjectId()); ok {
+				if err := cfg.DB.RemoveRemoteLike(chirpId, activity.Actor); err != nil {
+					return http.StatusInternalServerError, err
+				}
+			}
+		}
+
+	case activitypub.TypeLike:
+		chirpId, ok := chirpIdFromNoteURL(baseURL, activity.ObjectId())
+		if !ok {
+			return http.StatusNotFound, fmt.Errorf("note not found")
+		}
+
+		if code, err := cfg.DB.AddRemoteLike(chirpId, activity.Actor); err != nil {
+			return code, err
+		}
+
+	case activitypub.TypeCreate:
+		note, ok := activity.EmbeddedNote()
+		if !ok {
+			return http.StatusAccepted, nil
+		}
+
+		if note.AttributedTo != activity.Actor {
+			return http.StatusBadRequest, fmt.Errorf("note is not attributed to %s", activity.Actor)
+		}
+
+		return cfg.receiveNote(baseURL, userId, note)
+
+	case activitypub.TypeDelete:
+		if err := cfg.DB.RemoveRemoteNote(userId, activity.ObjectId(), activity.Actor); err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+	case activitypub.TypeAccept, activitypub.TypeReject:
+		followId := activity.ObjectId()
+		if inner, ok := activity.EmbeddedActivity(); ok && inner.Actor != me {
+			return http.StatusAccepted, nil
+		}
+
+		err := cfg.DB.AnswerRemoteFollowing(userId, activity.Actor, followId, activity.Type == activitypub.TypeAccept)
+		if err != nil {
+			log.Printf("Error answering follow: %s", err)
+		}
+	}
+
+	return http.StatusAccepted, nil
+}
+
+// receiveNote keeps a note sent to the user if they follow its author, or if it replies to or mentions them
+func (cfg *ApiConfig) receiveNote(baseURL string, userId string, note activitypub.Note) (int, error) {
+	me := actorURL(baseURL, userId)
+
+	replyTo, isReply := chirpIdFromNoteURL(baseURL, note.InReplyTo)
+	if isReply {
+		chirp, err := cfg.DB.GetChirp(replyTo)
+		isReply = err == nil && chirp.UserId == userId
+	}
+
+	mentioned := false
+	for _, t := range note.Tag {
+		if t.Type == "Mention" && t.Href == me {
+			mentioned = true
+		}
+	}
+
+	following, err := cfg.DB.IsFollowingRemote(userId, note.AttributedTo)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if !following && !isReply && !mentioned {
+		return http.StatusAccepted, nil
+	}
+
+	remoteNote := database.RemoteNote{
+		Id:         note.Id,
+		ActorId:    note.AttributedTo,
+		Content:    activitypub.HTMLToText(note.Content),
+		Url:        note.Url,
+		Published:  note.Published,
+		ReceivedAt: time.Now().UTC(),
+	}
+	if isReply {
+		remoteNote.InReplyToChirpId = replyTo
+	}
+
+	if err := cfg.DB.AddRemoteNote(userId, remoteNote); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusAccepted, nil
+}
+
+// FollowRemoteHandler follows an account on another server, the follow is pending until the server accepts it
+func (cfg *ApiConfig) FollowRemoteHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	body := FollowRemoteRequestBody{}
+	if err := helpers.RequestBodyValidator(r, &body); err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	wf, err := cfg.Federation.WebFinger(body.Account)
+	if err != nil {
+		log.Printf("Error looking up account: %s", err)
+		helpers.RespondWithError(w, http.StatusBadGateway, "error looking up account: "+err.Error())
+		return
+	}
+
+	if wf.ActorURL() == "" || strings.HasPrefix(wf.ActorURL(), baseURL+"/") {
+		helpers.RespondWithError(w, http.StatusBadRequest, "account is not a remote ActivityPub account")
+		return
+	}
+
+	remote, err := cfg.Federation.FetchActor(wf.ActorURL(), false)
+	if err != nil {
+		log.Printf("Error fetching actor: %s", err)
+		helpers.RespondWithError(w, http.StatusBadGateway, "error fetching account: "+err.Error())
+		return
+	}
+
+	me := actorURL(baseURL, userId)
+	follow, err := activitypub.NewActivity(me+"#follows/"+uuid.NewString(), activitypub.TypeFollow, me, remote.Id)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	following := database.RemoteFollowing{
+		ActorId:     remote.Id,
+		Account:     strings.TrimPrefix(body.Account, "@"),
+		Inbox:       remote.Inbox,
+		FollowId:    follow.Id,
+		RequestedAt: time.Now().UTC(),
+	}
+
+	if err := cfg.DB.AddRemoteFollowing(userId, following); err != nil {
+		log.Printf("Error following remote account: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cfg.deliver(baseURL, userId, follow, []string{remote.Inbox})
+
+	helpers.RespondWithJSON(w, http.StatusAccepted, following)
+}
+
+// UnfollowRemoteHandler stops following the remote actor given as ?actor_id=
+func (cfg *ApiConfig) UnfollowRemoteHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	following, code, err := cfg.DB.RemoveRemoteFollowing(userId, r.URL.Query().Get("actor_id"))
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	// Without PUBLIC_URL the follow is only forgotten here, there's no actor to send the Undo as
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	me := actorURL(baseURL, userId)
+
+	undo, err := undoFollowActivity(me, following)
+	if err != nil {
+		log.Printf("Error building activity: %s", err)
+	} else {
+		cfg.deliver(baseURL, userId, undo, []string{following.Inbox})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// undoFollowActivity rebuilds the original Follow, the remote server matches the Undo against its id
+func undoFollowActivity(me string, following database.RemoteFollowing) (activitypub.Activity, error) {
+	follow, err := activitypub.NewActivity(following.FollowId, activitypub.TypeFollow, me, following.ActorId)
+	if err != nil {
+		return activitypub.Activity{}, err
+	}
+	follow.Context = nil
+
+	return activitypub.NewActivity(me+"#undo/"+uuid.NewString(), activitypub.TypeUndo, me, follow)
+}
+
+func (cfg *ApiConfig) GetRemoteFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	following, err := cfg.DB.GetRemoteFollowing(userId)
+	if err != nil {
+		log.Printf("Error getting remote following: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, following)
+}
+
+// GetRemoteNotesHandler lists the notes remote actors sent to the user
+func (cfg *ApiConfig) GetRemoteNotesHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	offset, limit, err := helpers.ParsePagination(r)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	notes, total, err := cfg.DB.GetRemoteNotes(userId, offset, limit)
+	if err != nil {
+		log.Printf("Error getting remote notes: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, RemoteNotesResponseBody{Notes: notes, Total: total, Offset: offset, Limit: limit})
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"bytes"
+	"chirpy/activitypub"
+	"chirpy/database"
+	"chirpy/events"
+	"chirpy/helpers"
+	"chirpy/moderation"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type federationServer struct {
+	cfg    *ApiConfig
+	server *httptest.Server
+}
+
+// newFederationServer starts a server with its own database and the federation routes of main.go
+func newFederationServer(t *testing.T, name string) *federationServer {
+	t.Helper()
+
+	db, err := database.NewDBAt(filepath.Join(t.TempDir(), name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	federation := activitypub.NewClient(true)
+	federation.Run(1)
+
+	cfg := &ApiConfig{
+		DB:             db,
+		JWTSecret:      "secret-" + name,
+		ChirpMaxLength: 140,
+		Moderator:      moderation.NewPipeline(),
+		Events:         events.NewBroker(10),
+		Federation:     federation,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chirps", cfg.PostChirpsHandler)
+	mux.HandleFunc("GET /.well-known/webfinger", cfg.WebFingerHandler)
+	mux.HandleFunc("GET /users/{id}", cfg.ActorHandler)
+	mux.HandleFunc("POST /users/{id}/inbox", cfg.InboxHandler)
+	mux.HandleFunc("POST /api/federation/following", cfg.FollowRemoteHandler)
+	mux.HandleFunc("DELETE /api/federation/following", cfg.UnfollowRemoteHandler)
+
+	server := httptest.NewServer(mux)
+	cfg.PublicURL = server.URL
+
+	t.Cleanup(func() {
+		server.Close()
+		federation.Close()
+		cfg.Events.Close()
+	})
+
+	return &federationServer{cfg: cfg, server: server}
+}
+
+func (s *federationServer) host() string {
+	u, _ := url.Parse(s.server.URL)
+	return u.Host
+}
+
+// createUser returns the new user's id and an access token for them
+func (s *federationServer) createUser(t *testing.T, email string) (string, string) {
+	t.Helper()
+
+	user, _, err := s.cfg.DB.CreateUsers(email, []byte("Passw0rdX"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := helpers.GenerateJWTToken(user, s.cfg.JWTSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user.Id, token
+}
+
+func (s *federationServer) do(t *testing.T, method string, path string, token string, body any) *http.Response {
+	t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		dat, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(dat)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, s.server.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// eventually retries check until it passes, deliveries happen in the background
+func eventually(t *testing.T, what string, check func() (bool, error)) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ok, err := check()
+		if err != nil {
+			t.Fatalf("%s: %s", what, err)
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestFederationFollowCreateUndo(t *testing.T) {
+	local := newFederationServer(t, "local")
+	remote := newFederationServer(t, "remote")
+
+	aliceId, aliceToken := local.createUser(t, "alice@example.com")
+	bobId, bobToken := remote.createUser(t, "bob@example.com")
+
+	aliceActor := actorURL(local.server.URL, aliceId)
+	bobActor := actorURL(remote.server.URL, bobId)
+
+	// WebFinger
+	wf, err := local.cfg.Federation.WebFinger(bobId + "@" + remote.host())
+	if err != nil {
+		t.Fatalf("WebFinger: %s", err)
+	}
+	if wf.ActorURL() != bobActor {
+		t.Fatalf("WebFinger actor = %q, want %q", wf.ActorURL(), bobActor)
+	}
+
+	// Follow, the remote server stores alice as a follower and answers with an Accept
+	resp := local.do(t, http.MethodPost, "/api/federation/following", aliceToken, FollowRemoteRequestBody{Account: "@" + bobId + "@" + remote.host()})
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("follow status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+
+	eventually(t, "the remote follower", func() (bool, error) {
+		followers, err := remote.cfg.DB.GetRemoteFollowers(bobId)
+		return len(followers) == 1 && followers[0].ActorId == aliceActor, err
+	})
+
+	// Accept
+	eventually(t, "the follow to be accepted", func() (bool, error) {
+		following, err := local.cfg.DB.GetRemoteFollowing(aliceId)
+		return len(following) == 1 && following[0].ActorId == bobActor && following[0].Accepted, err
+	})
+
+	// Create, bob's public chirp is delivered to alice
+	resp = remote.do(t, http.MethodPost, "/api/chirps", bobToken, ChirpsRequestBody{Body: "hello from the other side"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("chirp status = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+
+	eventually(t, "the note to be delivered", func() (bool, error) {
+		notes, _, err := local.cfg.DB.GetRemoteNotes(aliceId, 0, 10)
+		return len(notes) == 1 && notes[0].ActorId == bobActor && notes[0].Content == "hello from the other side", err
+	})
+
+	// Undo, the remote server forgets alice
+	resp = local.do(t, http.MethodDelete, "/api/federation/following?actor_id="+url.QueryEscape(bobActor), aliceToken, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unfollow status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	eventually(t, "the follower to be removed", func() (bool, error) {
+		followers, err := remote.cfg.DB.GetRemoteFollowers(bobId)
+		return len(followers) == 0, err
+	})
+
+	following, err := local.cfg.DB.GetRemoteFollowing(aliceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(following) != 0 {
+		t.Fatalf("alice still follows %d accounts", len(following))
+	}
+}
+
+func TestInboxRejectsUnsignedActivity(t *testing.T) {
+	local := newFederationServer(t, "local")
+	aliceId, _ := local.createUser(t, "alice@example.com")
+
+	body := `{"type":"Follow","actor":"` + local.server.URL + `/users/someone","object":"` + actorURL(local.server.URL, aliceId) + `"}`
+	resp, err := http.Post(local.server.URL+"/users/"+aliceId+"/inbox", activitypub.ContentType, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+// Without PUBLIC_URL ids would come from the Host header, which the client picks
+func TestFederationNeedsPublicURL(t *testing.T) {
+	local := newFederationServer(t, "local")
+	aliceId, _ := local.createUser(t, "alice@example.com")
+	local.cfg.PublicURL = ""
+
+	for _, path := range []string{"/.well-known/webfinger?resource=acct:" + aliceId + "@evil.example.com", "/users/" + aliceId} {
+		req, err := http.NewRequest(http.MethodGet, local.server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = "evil.example.com"
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, http.StatusServiceUnavailable)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"bytes"
+	"chirpy/database"
+	"chirpy/helpers"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// FeedMaxEntries is how many of the most recent chirps a feed carries
+	FeedMaxEntries = 50
+	// feedTitleLength is how many characters of the chirp are used as the entry title
+	feedTitleLength = 60
+)
+
+// Feed readers are anonymous, so feeds only ever carry public chirps
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	Uri  string `xml:"uri,omitempty"`
+}
+
+type atomEntry struct {
+	Id        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Link      atomLink    `xml:"link"`
+	Author    atomAuthor  `xml:"author"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string   `xml:"title"`
+	Link          string   `xml:"link"`
+	Description   string   `xml:"description"`
+	LastBuildDate string   `xml:"lastBuildDate"`
+	SelfLink      atomLink `xml:"atom:link"`
+	Items         []rssItem
+}
+
+type rssItem struct {
+	XMLName     xml.Name `xml:"item"`
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        rssGuid  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Id          string `xml:",chardata"`
+}
+
+// feed is what the Atom and RSS renderers share
+type feed struct {
+	title    string
+	selfPath string
+	authorId string
+	chirps   []database.Chirpy
+}
+
+// publicURL returns PublicURL, the base of absolute links and federation ids. It's never guessed from the request:
+// the Host header is up to the client, and links built from it end up in cached feeds, emails and signed activities
+func (cfg *ApiConfig) publicURL() (string, error) {
+	if cfg.PublicURL == "" {
+		return "", fmt.Errorf("PUBLIC_URL is not set")
+	}
+
+	return strings.TrimSuffix(cfg.PublicURL, "/"), nil
+}
+
+// chirpTime is when the chirp was created, chirps from before it was recorded all date from the Unix epoch
+func chirpTime(chirp database.Chirpy) time.Time {
+	if chirp.CreatedAt.IsZero() {
+		return time.Unix(0, 0).UTC()
+	}
+
+	return chirp.CreatedAt
+}
+
+// updated is the creation time of the newest chirp in the feed
+func (f feed) updated() time.Time {
+	updated := time.Unix(0, 0).UTC()
+	for _, c := range f.chirps {
+		if t := chirpTime(c); t.After(updated) {
+			updated = t
+		}
+	}
+
+	return updated
+}
+
+// feedEntryTitle is the start of the chirp on a single line
+func feedEntryTitle(body string) string {
+	title := strings.Join(strings.Fields(body), " ")
+	if utf8.RuneCountInString(title) <= feedTitleLength {
+		return title
+	}
+
+	return string([]rune(title)[:feedTitleLength-1]) + "…"
+}
+
+func (f feed) atom(baseURL string) atomFeed {
+	out := atomFeed{
+		Id:      baseURL + f.selfPath,
+		Title:   f.title,
+		Updated: f.updated().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: baseURL + f.selfPath},
+		},
+		Entries: make([]atomEntry, 0, len(f.chirps)),
+	}
+
+	if f.authorId != "" {
+		out.Author = &atomAuthor{Name: f.authorId, Uri: baseURL + "/api/chirps?author_id=" + f.authorId}
+	}
+
+	for _, c := range f.chirps {
+		link := fmt.Sprintf("%s/api/chirps/%d", baseURL, c.Id)
+		created := chirpTime(c).Format(time.RFC3339)
+
+		out.Entries = append(out.Entries, atomEntry{
+			Id:        link,
+			Title:     feedEntryTitle(c.Body),
+			Updated:   created,
+			Published: created,
+			Link:      atomLink{Rel: "alternate", Href: link},
+			Author:    atomAuthor{Name: c.UserId},
+			Content:   atomContent{Type: "text", Body: c.Body},
+		})
+	}
+
+	return out
+}
+
+func (f feed) rss(baseURL string) rssFeed {
+	out := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         f.title,
+			Link:          baseURL + f.selfPath,
+			Description:   f.title,
+			LastBuildDate: f.updated().Format(time.RFC1123Z),
+			SelfLink:      atomLink{Rel: "self", Type: "application/rss+xml", Href: baseURL + f.selfPath},
+		},
+	}
+
+	for _, c := range f.chirps {
+		link := fmt.Sprintf("%s/api/chirps/%d", baseURL, c.Id)
+
+		out.Channel.Items = append(out.Channel.Items, rssItem{
+			Title:       feedEntryTitle(c.Body),
+			Link:        link,
+			Guid:        rssGuid{IsPermaLink: true, Id: link},
+			PubDate:     chirpTime(c).Format(time.RFC1123Z),
+			Description: c.Body,
+		})
+	}
+
+	return out
+}
+
+// respondWithFeed renders the feed and answers conditional requests with 304 Not Modified.
+// The ETag covers the whole document, so deleted chirps are noticed even though Last-Modified doesn't move
+func (cfg *ApiConfig) respondWithFeed(w http.ResponseWriter, r *http.Request, f feed, format string) {
+	if len(f.chirps) > FeedMaxEntries {
+		f.chirps = f.chirps[:FeedMaxEntries]
+	}
+
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	var doc any
+	contentType := ""
+	switch format {
+	case "atom":
+		doc = f.atom(baseURL)
+		contentType = "application/atom+xml; charset=utf-8"
+	default:
+		doc = f.rss(baseURL)
+		contentType = "application/rss+xml; charset=utf-8"
+	}
+
+	dat, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Printf("Error marshalling feed: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	dat = append([]byte(xml.Header), dat...)
+
+	sum := sha256.Sum256(dat)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=60")
+
+	// ServeContent takes care of If-None-Match, If-Modified-Since and HEAD requests
+	http.ServeContent(w, r, "", f.updated(), bytes.NewReader(dat))
+}
+
+func (cfg *ApiConfig) userFeed(w http.ResponseWriter, r *http.Request, format string) {
+	authorId := r.PathValue("id")
+
+	_, code, err := cfg.DB.GetUserById(authorId)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	chirps, err := cfg.DB.GetChirpByAuthor(authorId, "desc", "")
+	if err != nil {
+		log.Printf("Error getting chirps: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	f := feed{
+		title:    "Chirps by " + authorId,
+		selfPath: "/users/" + authorId + "/feed." + format,
+		authorId: authorId,
+		chirps:   chirps,
+	}
+	cfg.respondWithFeed(w, r, f, format)
+}
+
+func (cfg *ApiConfig) globalFeed(w http.ResponseWriter, r *http.Request, format string) {
+	chirps, err := cfg.DB.GetChirps("desc", "")
+	if err != nil {
+		log.Printf("Error getting chirps: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	f := feed{
+		title:    "Chirpy",
+		selfPath: "/feed." + format,
+		chirps:   chirps,
+	}
+	cfg.respondWithFeed(w, r, f, format)
+}
+
+func (cfg *ApiConfig) UserAtomFeedHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.userFeed(w, r, "atom")
+}
+
+func (cfg *ApiConfig) UserRSSFeedHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.userFeed(w, r, "rss")
+}
+
+func (cfg *ApiConfig) GlobalAtomFeedHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.globalFeed(w, r, "atom")
+}
+
+func (cfg *ApiConfig) GlobalRSSFeedHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.globalFeed(w, r, "rss")
+}
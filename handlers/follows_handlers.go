@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"log"
+	"net/http"
+)
+
+type FollowListResponseBody struct {
+	Users  []database.FollowEntry `json:"users"`
+	Total  int                    `json:"total"`
+	Offset int                    `json:"offset"`
+	Limit  int                    `json:"limit"`
+}
+
+func (cfg *ApiConfig) FollowHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	code, err := cfg.DB.Follow(userId, r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error following user: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	cfg.publishNotificationsUpdated(r.PathValue("id"))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) UnfollowHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	err = cfg.DB.Unfollow(userId, r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error unfollowing user: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) GetFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithFollowList(w, r, cfg.DB.GetFollowers)
+}
+
+func (cfg *ApiConfig) GetFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithFollowList(w, r, cfg.DB.GetFollowing)
+}
+
+// respondWithFollowList responds with a page of either side of the follow graph of the user in the path
+func (cfg *ApiConfig) respondWithFollowList(w http.ResponseWriter, r *http.Request, getList func(string, int, int) ([]database.FollowEntry, int, error)) {
+	offset, limit, err := helpers.ParsePagination(r)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userId := r.PathValue("id")
+	if _, code, err := cfg.DB.GetUserById(userId); err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	users, total, err := getList(userId, offset, limit)
+	if err != nil {
+		log.Printf("Error getting follow list: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, FollowListResponseBody{
+		Users:  users,
+		Total:  total,
+		Offset: offset,
+		Limit:  limit,
+	})
+}
@@ -0,0 +1,539 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/events"
+	"chirpy/helpers"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The gateway is a single WebSocket per client carrying the events of every topic it subscribed to.
+// Frames are JSON in both directions, see GatewayClientMessage and GatewayServerMessage
+
+const (
+	gatewayWriteWait    = 10 * time.Second
+	gatewayPongWait     = 60 * time.Second
+	gatewayPingInterval = gatewayPongWait * 9 / 10
+	// gatewayAuthCheckInterval is how often the access token is checked again
+	gatewayAuthCheckInterval = 5 * time.Second
+	// gatewayReauthWarning is how long before the access token expires the client is asked for a new one
+	gatewayReauthWarning = time.Minute
+	// gatewayTypingInterval limits how often a client announces it's typing in the same conversation
+	gatewayTypingInterval = 3 * time.Second
+
+	gatewayMaxMessageSize = 4096
+	gatewayMaxTopics      = 100
+	// gatewayClientBuffer is how many events a client can fall behind before it's disconnected
+	gatewayClientBuffer = 64
+	// gatewayReplyBuffer is how many replies to the client's own requests can be pending
+	gatewayReplyBuffer = 16
+)
+
+// Close codes in the 4000-4999 range are free for applications to use
+const (
+	GatewayCloseTokenExpired = 4001
+	GatewayCloseTooSlow      = 4008
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// GatewayClientMessage is a frame sent by the client, Type is one of auth, subscribe, unsubscribe or typing
+type GatewayClientMessage struct {
+	Type string `json:"type"`
+	// Ref is echoed back in the reply so the client can match it to its request
+	Ref            string `json:"ref,omitempty"`
+	Topic          string `json:"topic,omitempty"`
+	Token          string `json:"token,omitempty"`
+	ConversationId string `json:"conversation_id,omitempty"`
+}
+
+// GatewayServerMessage is a frame sent to the client
+type GatewayServerMessage struct {
+	Type      string        `json:"type"`
+	Ref       string        `json:"ref,omitempty"`
+	Topic     string        `json:"topic,omitempty"`
+	Topics    []string      `json:"topics,omitempty"`
+	UserId    string        `json:"user_id,omitempty"`
+	ExpiresAt *time.Time    `json:"expires_at,omitempty"`
+	Event     *GatewayEvent `json:"event,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// GatewayEvent is an event as the client sees it, Data is already filtered for the client
+type GatewayEvent struct {
+	Id   uint64    `json:"id,omitempty"`
+	Type string    `json:"type"`
+	Data any       `json:"data"`
+	At   time.Time `json:"at"`
+}
+
+// NotificationsUpdatedEvent tells the user's devices to refresh their notifications badge
+type NotificationsUpdatedEvent struct {
+	UnreadCount int                    `json:"unread_count"`
+	Latest      *database.Notification `json:"latest,omitempty"`
+}
+
+type MessageCreatedEvent struct {
+	ConversationId string           `json:"conversation_id"`
+	Message        database.Message `json:"message"`
+	// blocked are the users blocked either way with the sender when the event was published
+	blocked map[string]bool
+}
+
+type TypingEvent struct {
+	ConversationId string `json:"conversation_id"`
+	UserId         string `json:"user_id"`
+	blocked        map[string]bool
+}
+
+func notificationsTopic(userId string) string {
+	return "notifications:" + userId
+}
+
+func conversationTopic(conversationId string) string {
+	return "conversation:" + conversationId
+}
+
+// publishNotificationsUpdated announces the user's unread count, it's called after anything that changes it
+func (cfg *ApiConfig) publishNotificationsUpdated(userId string) {
+	page, _, unread, err := cfg.DB.GetNotifications(userId, false, 0, 1)
+	if err != nil {
+		log.Printf("Error getting notifications: %s", err)
+		return
+	}
+
+	data := NotificationsUpdatedEvent{UnreadCount: unread}
+	if len(page) > 0 {
+		data.Latest = &page[0]
+	}
+
+	cfg.Events.Publish(notificationsTopic(userId), events.NotificationsUpdated, data)
+}
+
+func (cfg *ApiConfig) publishMessageCreated(conversationId string, message database.Message) {
+	blocked, err := cfg.DB.GetBlockedEither(message.SenderId)
+	if err != nil {
+		log.Printf("Error getting blocks: %s", err)
+		return
+	}
+
+	cfg.Events.Publish(conversationTopic(conversationId), events.MessageCreated, MessageCreatedEvent{ConversationId: conversationId, Message: message, blocked: blocked})
+}
+
+// gatewayClient is the state of one connection.
+// The handler goroutine is the only one writing to conn, the read loop only reads from it
+type gatewayClient struct {
+	cfg    *ApiConfig
+	conn   *websocket.Conn
+	userId string
+
+	mu     sync.Mutex
+	topics map[string]struct{}
+	// auth is the token the client authenticated with, a personal access token's scopes limit the topics
+	auth tokenAuth
+	// token is the raw token auth came from, it's validated again on every auth check
+	token  string
+	warned bool
+
+	send     chan GatewayServerMessage
+	readDone chan struct{}
+	// closeCode is set by the read loop before readDone is closed, 0 if the client went away by itself
+	closeCode   int
+	closeReason string
+
+	// lastTyping is only used by the read loop
+	lastTyping map[string]time.Time
+}
+
+// GatewayHandler upgrades to a WebSocket authenticated with the access token or a personal access token.
+// Browsers can't set headers on a WebSocket, so the token can also be given as ?access_token=
+func (cfg *ApiConfig) GatewayHandler(w http.ResponseWriter, r *http.Request) {
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		tokenString = r.URL.Query().Get("access_token")
+	}
+
+	auth, err := cfg.authenticateToken(tokenString)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	// The upgrader already answered the request if it fails
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Error upgrading to websocket: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	c := &gatewayClient{
+		cfg:        cfg,
+		conn:       conn,
+		userId:     auth.UserId,
+		topics:     map[string]struct{}{},
+		auth:       auth,
+		token:      tokenString,
+		send:       make(chan GatewayServerMessage, gatewayReplyBuffer),
+		readDone:   make(chan struct{}),
+		lastTyping: map[string]time.Time{},
+	}
+
+	sub, _, _ := cfg.Events.Subscribe(func(e events.Event) bool {
+		return len(c.matchingTopics(e)) > 0
+	}, gatewayClientBuffer, 0)
+	defer sub.Close()
+
+	go c.readLoop()
+
+	if !c.write(GatewayServerMessage{Type: "ready", UserId: auth.UserId, ExpiresAt: expiresAtOrNil(auth.ExpiresAt)}) {
+		return
+	}
+
+	ping := time.NewTicker(gatewayPingInterval)
+	defer ping.Stop()
+
+	authCheck := time.NewTicker(gatewayAuthCheckInterval)
+	defer authCheck.Stop()
+
+	for {
+		select {
+		case <-c.readDone:
+			if c.closeCode != 0 {
+				c.close(c.closeCode, c.closeReason)
+			}
+			return
+		case <-sub.Done():
+			if sub.Dropped() {
+				c.close(GatewayCloseTooSlow, "client is too slow")
+			} else {
+				c.close(websocket.CloseGoingAway, "server is shutting down")
+			}
+			return
+		case m := <-c.send:
+			if !c.write(m) {
+				return
+			}
+		case e := <-sub.Events():
+			m, ok := c.eventMessage(e)
+			if ok && !c.write(m) {
+				return
+			}
+		case <-ping.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(gatewayWriteWait))
+			if err != nil {
+				return
+			}
+		case <-authCheck.C:
+			if !c.checkAuth() {
+				return
+			}
+		}
+	}
+}
+
+// expiresAtOrNil leaves the expiry out for personal access tokens that never expire
+func expiresAtOrNil(expiresAt time.Time) *time.Time {
+	if expiresAt.IsZero() {
+		return nil
+	}
+
+	return &expiresAt
+}
+
+// checkAuth asks the client to re-authenticate shortly before its token expires, and disconnects it once
+// the token expired or stopped being valid: revoked, the user suspended, their password or role changed.
+// It returns false once the connection is closed
+func (c *gatewayClient) checkAuth() bool {
+	c.mu.Lock()
+	token := c.token
+	expiresAt := c.auth.ExpiresAt
+	warn := !expiresAt.IsZero() && !c.warned && time.Now().Add(gatewayReauthWarning).After(expiresAt)
+	if warn {
+		c.warned = true
+	}
+	c.mu.Unlock()
+
+	if !expiresAt.IsZero() && time.Now().After(expiresAt) {
+		c.close(GatewayCloseTokenExpired, "access token expired")
+		return false
+	}
+
+	if _, err := c.cfg.authenticateToken(token); err != nil {
+		log.Printf("Closing gateway of user %s: %s", c.userId, err)
+		c.close(GatewayCloseTokenExpired, "access token is no longer valid")
+		return false
+	}
+
+	if warn {
+		return c.write(GatewayServerMessage{Type: "reauth_required", ExpiresAt: &expiresAt})
+	}
+
+	return true
+}
+
+func (c *gatewayClient) write(m GatewayServerMessage) bool {
+	c.conn.SetWriteDeadline(time.Now().Add(gatewayWriteWait))
+	return c.conn.WriteJSON(m) == nil
+}
+
+func (c *gatewayClient) close(code int, reason string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(gatewayWriteWait))
+}
+
+// readLoop handles the client's frames until the connection is closed
+func (c *gatewayClient) readLoop() {
+	defer close(c.readDone)
+
+	c.conn.SetReadLimit(gatewayMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(gatewayPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(gatewayPongWait))
+	})
+
+	for {
+		_, dat, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(gatewayPongWait))
+
+		msg := GatewayClientMessage{}
+		reply := GatewayServerMessage{}
+		if err := json.Unmarshal(dat, &msg); err != nil {
+			reply = GatewayServerMessage{Type: "error", Error: "invalid message: " + err.Error()}
+		} else {
+			reply = c.handleMessage(msg)
+		}
+
+		select {
+		case c.send <- reply:
+		default:
+			// The client sends requests faster than it reads the replies
+			c.closeCode = GatewayCloseTooSlow
+			c.closeReason = "client is too slow"
+			return
+		}
+	}
+}
+
+func (c *gatewayClient) handleMessage(msg GatewayClientMessage) GatewayServerMessage {
+	var reply GatewayServerMessage
+	var err error
+
+	switch msg.Type {
+	case "auth":
+		reply, err = c.reauthenticate(msg.Token)
+	case "subscribe":
+		reply, err = c.subscribe(msg.Topic)
+	case "unsubscribe":
+		reply = c.unsubscribe(msg.Topic)
+	case "typing":
+		reply, err = c.typing(msg.ConversationId)
+	default:
+		err = fmt.Errorf("unknown message type %q", msg.Type)
+	}
+
+	if err != nil {
+		reply = GatewayServerMessage{Type: "error", Error: err.Error()}
+	}
+	reply.Ref = msg.Ref
+
+	return reply
+}
+
+// reauthenticate swaps the connection's token for a fresh one of the same user
+func (c *gatewayClient) reauthenticate(tokenString string) (GatewayServerMessage, error) {
+	auth, err := c.cfg.authenticateToken(tokenString)
+	if err != nil {
+		return GatewayServerMessage{}, err
+	}
+
+	if auth.UserId != c.userId {
+		return GatewayServerMessage{}, fmt.Errorf("token belongs to another user")
+	}
+
+	c.mu.Lock()
+	c.auth = auth
+	c.token = tokenString
+	c.warned = false
+	c.mu.Unlock()
+
+	return GatewayServerMessage{Type: "authenticated", UserId: auth.UserId, ExpiresAt: expiresAtOrNil(auth.ExpiresAt)}, nil
+}
+
+// subscribe checks the client is allowed to follow the topic, topics are
+// author:<user id>, thread:<chirp id>, conversation:<conversation id> and notifications
+func (c *gatewayClient) subscribe(topic string) (GatewayServerMessage, error) {
+	kind, id, _ := strings.Cut(topic, ":")
+
+	if err := c.requireScope(topicScopes[kind]); err != nil {
+		return GatewayServerMessage{}, err
+	}
+
+	switch kind {
+	case "author":
+		if _, _, err := c.cfg.DB.GetUserById(id); err != nil {
+			return GatewayServerMessage{}, fmt.Errorf("user not found")
+		}
+	case "thread":
+		chirpId, err := strconv.Atoi(id)
+		if err != nil {
+			return GatewayServerMessage{}, fmt.Errorf("invalid chirp id")
+		}
+
+		if _, _, err := c.cfg.DB.GetVisibleChirp(chirpId, c.userId); err != nil {
+			return GatewayServerMessage{}, err
+		}
+	case "conversation":
+		if _, _, err := c.cfg.DB.GetConversation(id, c.userId); err != nil {
+			return GatewayServerMessage{}, err
+		}
+	case "notifications":
+		if id != "" {
+			return GatewayServerMessage{}, fmt.Errorf("you can only subscribe to your own notifications")
+		}
+	default:
+		return GatewayServerMessage{}, fmt.Errorf("unknown topic %q", topic)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.topics[topic]; !ok && len(c.topics) >= gatewayMaxTopics {
+		return GatewayServerMessage{}, fmt.Errorf("you can't subscribe to more than %d topics", gatewayMaxTopics)
+	}
+	c.topics[topic] = struct{}{}
+
+	return GatewayServerMessage{Type: "subscribed", Topic: topic}, nil
+}
+
+// topicScopes are the scopes a personal access token needs to subscribe to each kind of topic
+var topicScopes = map[string]string{
+	"author":        database.ScopeChirpsRead,
+	"thread":        database.ScopeChirpsRead,
+	"conversation":  database.ScopeMessagesRead,
+	"notifications": database.ScopeNotificationsRead,
+}
+
+// requireScope fails if the client authenticated with a personal access token that lacks the scope
+func (c *gatewayClient) requireScope(scope string) error {
+	if scope == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	missing := c.auth.missingScopes(scope)
+	c.mu.Unlock()
+
+	if len(missing) > 0 {
+		return fmt.Errorf("token is missing the scopes %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func (c *gatewayClient) unsubscribe(topic string) GatewayServerMessage {
+	c.mu.Lock()
+	delete(c.topics, topic)
+	c.mu.Unlock()
+
+	return GatewayServerMessage{Type: "unsubscribed", Topic: topic}
+}
+
+// typing tells the other participants the client is typing, it's not stored anywhere
+func (c *gatewayClient) typing(conversationId string) (GatewayServerMessage, error) {
+	if err := c.requireScope(database.ScopeMessagesWrite); err != nil {
+		return GatewayServerMessage{}, err
+	}
+
+	if _, _, err := c.cfg.DB.GetConversation(conversationId, c.userId); err != nil {
+		return GatewayServerMessage{}, err
+	}
+
+	if time.Since(c.lastTyping[conversationId]) >= gatewayTypingInterval {
+		blocked, err := c.cfg.DB.GetBlockedEither(c.userId)
+		if err != nil {
+			return GatewayServerMessage{}, err
+		}
+
+		c.lastTyping[conversationId] = time.Now()
+		c.cfg.Events.PublishTransient(conversationTopic(conversationId), events.ConversationTyping, TypingEvent{ConversationId: conversationId, UserId: c.userId, blocked: blocked})
+	}
+
+	return GatewayServerMessage{Type: "ok"}, nil
+}
+
+// matchingTopics returns the client's topics the event belongs to.
+// It's called by the broker as the subscription filter, so it must not block
+func (c *gatewayClient) matchingTopics(e events.Event) []string {
+	candidates := make([]string, 0, 2)
+
+	switch {
+	case e.Topic == TopicChirps:
+		if data, ok := e.Data.(ChirpEvent); ok {
+			candidates = append(candidates, "author:"+data.Chirp.UserId, "thread:"+strconv.Itoa(data.Chirp.Id))
+		}
+	case e.Topic == notificationsTopic(c.userId):
+		candidates = append(candidates, "notifications")
+	case strings.HasPrefix(e.Topic, "conversation:"):
+		candidates = append(candidates, e.Topic)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	topics := make([]string, 0, len(candidates))
+	for _, t := range candidates {
+		if _, ok := c.topics[t]; ok {
+			topics = append(topics, t)
+		}
+	}
+
+	return topics
+}
+
+// eventMessage turns the event into the frame for this client, false if the client must not see it
+func (c *gatewayClient) eventMessage(e events.Event) (GatewayServerMessage, bool) {
+	topics := c.matchingTopics(e)
+	if len(topics) == 0 {
+		return GatewayServerMessage{}, false
+	}
+
+	data := e.Data
+
+	// Messages and typing from users blocked either way are left out, like in the message history
+	switch d := e.Data.(type) {
+	case ChirpEvent:
+		var ok bool
+		if data, ok = c.cfg.canSeeChirpEvent(e, c.userId); !ok {
+			return GatewayServerMessage{}, false
+		}
+	case MessageCreatedEvent:
+		if d.blocked[c.userId] {
+			return GatewayServerMessage{}, false
+		}
+	case TypingEvent:
+		if d.UserId == c.userId || d.blocked[c.userId] {
+			return GatewayServerMessage{}, false
+		}
+	}
+
+	return GatewayServerMessage{
+		Type:   "event",
+		Topics: topics,
+		Event:  &GatewayEvent{Id: e.Id, Type: e.Type, Data: data, At: e.At},
+	}, true
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const maxMessageLength = 1000
+
+type ConversationRequestBody struct {
+	ParticipantIds []string `json:"participant_ids"`
+}
+
+type MessageRequestBody struct {
+	Body string `json:"body"`
+}
+
+type ReadRequestBody struct {
+	MessageId int `json:"message_id"`
+}
+
+type ConversationsResponseBody struct {
+	Conversations []database.ConversationSummary `json:"conversations"`
+	UnreadCount   int                            `json:"unread_count"`
+}
+
+type MessagesResponseBody struct {
+	Messages   []database.Message `json:"messages"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+func (cfg *ApiConfig) CreateConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	body := ConversationRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	conversation, code, err := cfg.DB.CreateConversation(userId, body.ParticipantIds)
+	if err != nil {
+		log.Printf("Error creating conversation: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, code, conversation)
+}
+
+func (cfg *ApiConfig) GetConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	conversations, err := cfg.DB.GetConversations(userId)
+	if err != nil {
+		log.Printf("Error getting conversations: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	unread := 0
+	for _, c := range conversations {
+		unread += c.UnreadCount
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, ConversationsResponseBody{Conversations: conversations, UnreadCount: unread})
+}
+
+func (cfg *ApiConfig) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	body := MessageRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	body.Body = helpers.NormalizeChirp(body.Body)
+	length := helpers.ChirpLength(body.Body)
+	if length == 0 || length > maxMessageLength {
+		helpers.RespondWithError(w, http.StatusBadRequest, "Message must be between 1 and "+strconv.Itoa(maxMessageLength)+" characters")
+		return
+	}
+
+	message, code, err := cfg.DB.SendMessage(r.PathValue("id"), userId, body.Body)
+	if err != nil {
+		log.Printf("Error sending message: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	cfg.publishMessageCreated(r.PathValue("id"), message)
+
+	helpers.RespondWithJSON(w, code, message)
+}
+
+func (cfg *ApiConfig) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	_, limit, err := helpers.ParsePagination(r)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	before := 0
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		before, err = strconv.Atoi(cursor)
+		if err != nil || before < 1 {
+			helpers.RespondWithError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+	}
+
+	messages, code, err := cfg.DB.GetMessages(r.PathValue("id"), userId, before, limit)
+	if err != nil {
+		log.Printf("Error getting messages: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	response := MessagesResponseBody{Messages: messages}
+	if len(messages) == limit && messages[len(messages)-1].Id > 1 {
+		response.NextCursor = strconv.Itoa(messages[len(messages)-1].Id)
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *ApiConfig) MarkConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	// The body is optional, without one the whole conversation is marked read
+	body := ReadRequestBody{}
+	if r.ContentLength != 0 {
+		err = helpers.RequestBodyValidator(r, &body)
+		if err != nil {
+			helpers.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	conversation, code, err := cfg.DB.MarkConversationRead(r.PathValue("id"), userId, body.MessageId)
+	if err != nil {
+		log.Printf("Error marking conversation read: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, code, conversation)
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type NotificationResponseBody struct {
+	database.Notification
+	Summary string `json:"summary"`
+}
+
+type NotificationsResponseBody struct {
+	Notifications []NotificationResponseBody `json:"notifications"`
+	UnreadCount   int                        `json:"unread_count"`
+	Total         int                        `json:"total"`
+	Offset        int                        `json:"offset"`
+	Limit         int                        `json:"limit"`
+}
+
+func (cfg *ApiConfig) GetNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	offset, limit, err := helpers.ParsePagination(r)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	notifications, total, unread, err := cfg.DB.GetNotifications(userId, unreadOnly, offset, limit)
+	if err != nil {
+		log.Printf("Error getting notifications: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := NotificationsResponseBody{
+		Notifications: make([]NotificationResponseBody, 0, len(notifications)),
+		UnreadCount:   unread,
+		Total:         total,
+		Offset:        offset,
+		Limit:         limit,
+	}
+	for _, n := range notifications {
+		response.Notifications = append(response.Notifications, NotificationResponseBody{Notification: n, Summary: n.Summary()})
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *ApiConfig) MarkNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.markNotificationsRead(w, r, r.PathValue("id"))
+}
+
+func (cfg *ApiConfig) MarkAllNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.markNotificationsRead(w, r, "")
+}
+
+func (cfg *ApiConfig) markNotificationsRead(w http.ResponseWriter, r *http.Request, notificationId string) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	code, err := cfg.DB.MarkNotificationsRead(userId, notificationId)
+	if err != nil {
+		log.Printf("Error marking notifications read: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	cfg.publishNotificationsUpdated(userId)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) GetNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	user, code, err := cfg.DB.GetUserById(userId)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, user.AllNotificationPreferences())
+}
+
+// UpdateNotificationPreferencesHandler takes a map of notification type to on/off, types left out are unchanged
+func (cfg *ApiConfig) UpdateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	preferences := map[string]bool{}
+	err = helpers.RequestBodyValidator(r, &preferences)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	for t := range preferences {
+		if !database.IsValidNotificationType(t) {
+			helpers.RespondWithError(w, http.StatusBadRequest, "Notification type must be one of "+strings.Join(database.NotificationTypes, ", "))
+			return
+		}
+	}
+
+	updated, code, err := cfg.DB.UpdateNotificationPreferences(userId, preferences)
+	if err != nil {
+		log.Printf("Error updating notification preferences: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, code, updated)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"chirpy/helpers"
+	"chirpy/mail"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// PasswordResetTTL is how long the token in a password reset email works
+	PasswordResetTTL = 30 * time.Minute
+	// PasswordResetEmailInterval and PasswordResetEmailsPerDay limit how often reset emails go to one account
+	PasswordResetEmailInterval = time.Minute
+	PasswordResetEmailsPerDay  = 5
+)
+
+type ForgotPasswordRequestBody struct {
+	Email string `json:"email"`
+}
+
+type ForgotPasswordResponseBody struct {
+	Message string `json:"message"`
+}
+
+type ResetPasswordRequestBody struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPasswordHandler emails a password reset token. The response is the same whether or not
+// an account has the email, and the email is sent after responding so the timing doesn't tell either
+func (cfg *ApiConfig) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	body := ForgotPasswordRequestBody{}
+	err := helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	email := strings.TrimSpace(body.Email)
+	if !helpers.IsValidEmail(email) {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid email")
+		return
+	}
+
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		log.Printf("Error sending password reset: %s", err)
+		helpers.RespondWithError(w, http.StatusServiceUnavailable, "password reset is not available")
+		return
+	}
+
+	go cfg.sendPasswordReset(baseURL, email)
+
+	helpers.RespondWithJSON(w, http.StatusAccepted, ForgotPasswordResponseBody{
+		Message: "If an account uses this email, an email to reset the password is on its way",
+	})
+}
+
+// sendPasswordReset emails a reset token to the account with the email, if there is one.
+// Errors are only logged since the caller has been answered already
+func (cfg *ApiConfig) sendPasswordReset(baseURL string, email string) {
+	token, tokenHash, err := newEmailToken()
+	if err != nil {
+		log.Printf("Error generating token: %s", err)
+		return
+	}
+
+	reset, code, err := cfg.DB.CreatePasswordReset(email, tokenHash, PasswordResetTTL, PasswordResetEmailInterval, PasswordResetEmailsPerDay)
+	if err != nil {
+		if code != http.StatusNotFound {
+			log.Printf("Error creating password reset: %s", err)
+		}
+		return
+	}
+
+	err = cfg.Mailer.Send(mail.Message{
+		To:      reset.Email,
+		Subject: "Reset your Chirpy password",
+		Body: "Hi,\n\n" +
+			"Someone asked to reset the password of your Chirpy account. Post the token below with your new password to " +
+			baseURL + "/api/password/reset to reset it:\n\n" +
+			token + "\n\n" +
+			fmt.Sprintf("The token works once, for %d minutes. If you didn't ask for this, you can ignore this email and your password stays the same.\n", int(PasswordResetTTL.Minutes())),
+	})
+	if err != nil {
+		log.Printf("Error sending password reset email: %s", err)
+	}
+}
+
+// ResetPasswordHandler sets a new password with the token from a reset email,
+// every session of the user is signed out and they are told by email
+func (cfg *ApiConfig) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	body := ResetPasswordRequestBody{}
+	err := helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if body.Token == "" {
+		helpers.RespondWithError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	err = helpers.ValidatePassword(body.Password)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid password: %s", err))
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Failed to hash password: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, "error resetting password")
+		return
+	}
+
+	user, code, err := cfg.DB.ResetPassword(hashToken(body.Token), hashedPassword)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	err = cfg.Mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy password was changed",
+		Body: "Hi,\n\n" +
+			"The password of your Chirpy account was just reset, and every device was signed out.\n\n" +
+			"If this wasn't you, reset your password again right away.\n",
+	})
+	if err != nil {
+		log.Printf("Error sending password changed email: %s", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"chirpy/helpers"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type PinsResponseBody struct {
+	PinnedChirps []int `json:"pinned_chirps"`
+}
+
+func (cfg *ApiConfig) PinChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error: %s", err)
+		helpers.RespondWithError(w, http.StatusBadRequest, "Error getting chirp: "+err.Error())
+		return
+	}
+
+	user, code, err := cfg.DB.PinChirp(userId, id)
+	if err != nil {
+		log.Printf("Error pinning chirp: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, PinsResponseBody{PinnedChirps: pinnedOrEmpty(user.PinnedChirps)})
+}
+
+func (cfg *ApiConfig) UnpinChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error: %s", err)
+		helpers.RespondWithError(w, http.StatusBadRequest, "Error getting chirp: "+err.Error())
+		return
+	}
+
+	user, code, err := cfg.DB.UnpinChirp(userId, id)
+	if err != nil {
+		log.Printf("Error unpinning chirp: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, PinsResponseBody{PinnedChirps: pinnedOrEmpty(user.PinnedChirps)})
+}
+
+// pinnedOrEmpty makes sure we respond with [] instead of null
+func pinnedOrEmpty(pinned []int) []int {
+	if pinned == nil {
+		return []int{}
+	}
+
+	return pinned
+}
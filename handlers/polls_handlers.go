@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"chirpy/moderation"
+	"fmt"
+	"github.com/rivo/uniseg"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxPollOptionLength = 25
+	minPollDuration     = 5 * time.Minute
+	maxPollDuration     = 7 * 24 * time.Hour
+)
+
+type PollRequestBody struct {
+	Options  []string  `json:"options"`
+	ClosesAt time.Time `json:"closes_at"`
+}
+
+type VoteRequestBody struct {
+	OptionId *int `json:"option_id"`
+}
+
+// validatePoll checks the poll attached to a new chirp and turns it into a database.Poll
+func validatePoll(pollRequest PollRequestBody) (*database.Poll, error) {
+	if len(pollRequest.Options) < database.MinPollOptions || len(pollRequest.Options) > database.MaxPollOptions {
+		return nil, fmt.Errorf("a poll must have between %d and %d options", database.MinPollOptions, database.MaxPollOptions)
+	}
+
+	seen := map[string]bool{}
+	options := make([]string, 0, len(pollRequest.Options))
+	for _, option := range pollRequest.Options {
+		option = helpers.NormalizeChirp(option)
+		// Counted in graphemes like chirps, so options in any script get the same room
+		if option == "" || uniseg.GraphemeClusterCount(option) > maxPollOptionLength {
+			return nil, fmt.Errorf("poll options must be between 1 and %d characters", maxPollOptionLength)
+		}
+
+		if seen[strings.ToLower(option)] {
+			return nil, fmt.Errorf("poll options must be unique")
+		}
+
+		seen[strings.ToLower(option)] = true
+		options = append(options, option)
+	}
+
+	duration := time.Until(pollRequest.ClosesAt)
+	if duration < minPollDuration || duration > maxPollDuration {
+		return nil, fmt.Errorf("a poll must close between %s and %s from now", minPollDuration, maxPollDuration)
+	}
+
+	return database.NewPoll(options, pollRequest.ClosesAt), nil
+}
+
+// moderatePoll runs every option through the moderation pipeline like the chirp body, masking them in place.
+// The verdict covers all the options
+func (cfg *ApiConfig) moderatePoll(poll *database.Poll) moderation.Verdict {
+	verdict := moderation.Verdict{Action: moderation.ActionAllow, Filters: []string{}}
+
+	for i, option := range poll.Options {
+		masked, optionVerdict := cfg.Moderator.Moderate(option.Text)
+		poll.Options[i].Text = masked
+		verdict = verdict.Merge(optionVerdict)
+	}
+
+	return verdict
+}
+
+func (cfg *ApiConfig) VotePollHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error: %s", err)
+		helpers.RespondWithError(w, http.StatusBadRequest, "Error getting chirp: "+err.Error())
+		return
+	}
+
+	body := VoteRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil || body.OptionId == nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	chirp, code, err := cfg.DB.VotePoll(id, userId, *body.OptionId)
+	if err != nil {
+		log.Printf("Error voting: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, code, chirp)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/events"
+	"chirpy/helpers"
+	"chirpy/moderation"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPostChirpModeratesPollOptions(t *testing.T) {
+	db, err := database.NewDBAt(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ApiConfig{
+		DB:             db,
+		JWTSecret:      "secret",
+		ChirpMaxLength: 140,
+		Moderator: moderation.NewPipeline(
+			moderation.NewWordListFilter("profanity", moderation.ActionMask, []string{"fornax"}),
+			moderation.NewLinkBlocklistFilter("links", moderation.ActionReject, []string{"spam.example.com"}),
+		),
+		Events: events.NewBroker(10),
+	}
+	t.Cleanup(cfg.Events.Close)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chirps", cfg.PostChirpsHandler)
+
+	user, _, err := db.CreateUsers("alice@example.com", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := helpers.GenerateJWTToken(user, cfg.JWTSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closesAt := time.Now().Add(time.Hour)
+
+	rec := serve(t, mux, http.MethodPost, "/api/chirps", token, ChirpsRequestBody{
+		Body: "Which one?",
+		Poll: &PollRequestBody{Options: []string{"for‌nax", "  kept​  "}, ClosesAt: closesAt},
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+
+	chirp := database.Chirpy{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &chirp); err != nil {
+		t.Fatal(err)
+	}
+	if chirp.Poll == nil || len(chirp.Poll.Options) != 2 {
+		t.Fatalf("poll = %+v", chirp.Poll)
+	}
+	if got := chirp.Poll.Options[0].Text; got != "****" {
+		t.Errorf("option 0 = %q, want it masked", got)
+	}
+	if got := chirp.Poll.Options[1].Text; got != "kept" {
+		t.Errorf("option 1 = %q, want it normalised to %q", got, "kept")
+	}
+
+	rec = serve(t, mux, http.MethodPost, "/api/chirps", token, ChirpsRequestBody{
+		Body: "Which one?",
+		Poll: &PollRequestBody{Options: []string{"https://spam.example.com", "no"}, ClosesAt: closesAt},
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("blocked link in an option = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"bytes"
+	"chirpy/database"
+	"chirpy/helpers"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxAvatarSize is the largest avatar upload in bytes
+	MaxAvatarSize = 2 << 20
+	// MaxAvatarDimension is the largest width or height of an avatar in pixels
+	MaxAvatarDimension = 2048
+)
+
+// OwnUserResponseBody is the caller's own account, the only view of a user that includes the email
+type OwnUserResponseBody struct {
+	database.PublicUser
+	Email               string     `json:"email"`
+	EmailVerified       bool       `json:"email_verified"`
+	TwoFactorEnabled    bool       `json:"two_factor_enabled"`
+	Role                string     `json:"role"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+// avatarURL points at the avatar with the hash of the image as a version, so it can be cached until it changes
+func (cfg *ApiConfig) avatarURL(user database.PublicUser) string {
+	if user.Avatar == "" {
+		return ""
+	}
+
+	// Without PUBLIC_URL the link is relative to the server
+	baseURL, _ := cfg.publicURL()
+
+	version := strings.TrimPrefix(strings.TrimSuffix(user.Avatar, filepath.Ext(user.Avatar)), user.Id+"-")
+	return baseURL + "/api/users/" + user.Id + "/avatar?v=" + version
+}
+
+func (cfg *ApiConfig) respondWithPublicUser(w http.ResponseWriter, r *http.Request, user database.PublicUser) {
+	user.AvatarURL = cfg.avatarURL(user)
+	helpers.RespondWithJSON(w, http.StatusOK, user)
+}
+
+func (cfg *ApiConfig) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, code, err := cfg.DB.GetPublicUser(r.PathValue("id"))
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	cfg.respondWithPublicUser(w, r, user)
+}
+
+func (cfg *ApiConfig) GetUserByHandleHandler(w http.ResponseWriter, r *http.Request) {
+	user, code, err := cfg.DB.GetPublicUserByHandle(strings.TrimPrefix(r.PathValue("handle"), "@"))
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	cfg.respondWithPublicUser(w, r, user)
+}
+
+// UserSubresourceHandler serves GET /api/users/{id}/{name}. The mux rejects /api/users/by-handle/{handle}
+// next to /api/users/{id}/followers since both match /api/users/by-handle/followers, so they share a pattern
+func (cfg *ApiConfig) UserSubresourceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("id") == "by-handle" {
+		r.SetPathValue("handle", r.PathValue("name"))
+		cfg.GetUserByHandleHandler(w, r)
+		return
+	}
+
+	switch r.PathValue("name") {
+	case "followers":
+		cfg.GetFollowersHandler(w, r)
+	case "following":
+		cfg.GetFollowingHandler(w, r)
+	case "avatar":
+		cfg.GetAvatarHandler(w, r)
+	default:
+		helpers.RespondWithError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (cfg *ApiConfig) GetOwnUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	cfg.respondWithOwnUser(w, r, userId)
+}
+
+func (cfg *ApiConfig) respondWithOwnUser(w http.ResponseWriter, r *http.Request, userId string) {
+	user, code, err := cfg.ownUser(r, userId)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, user)
+}
+
+func (cfg *ApiConfig) ownUser(r *http.Request, userId string) (OwnUserResponseBody, int, error) {
+	user, code, err := cfg.DB.GetUserById(userId)
+	if err != nil {
+		return OwnUserResponseBody{}, code, err
+	}
+
+	public, code, err := cfg.DB.GetPublicUser(userId)
+	if err != nil {
+		return OwnUserResponseBody{}, code, err
+	}
+	public.AvatarURL = cfg.avatarURL(public)
+
+	return OwnUserResponseBody{
+		PublicUser:          public,
+		Email:               user.Email,
+		EmailVerified:       user.EmailVerified,
+		TwoFactorEnabled:    user.HasTwoFactor(),
+		Role:                user.RoleName(),
+		DeletionScheduledAt: user.DeletionScheduledAt,
+	}, http.StatusOK, nil
+}
+
+// validateProfile normalizes the profile fields and checks them against the limits
+func validateProfile(profile *database.Profile) error {
+	profile.Handle = strings.TrimPrefix(strings.TrimSpace(profile.Handle), "@")
+	profile.DisplayName = helpers.NormalizeProfileText(profile.DisplayName)
+	profile.Bio = helpers.NormalizeChirp(profile.Bio)
+	profile.Location = helpers.NormalizeProfileText(profile.Location)
+	profile.Website = strings.TrimSpace(profile.Website)
+
+	if profile.Handle != "" {
+		if err := helpers.ValidateHandle(profile.Handle); err != nil {
+			return err
+		}
+	}
+
+	if err := helpers.ValidateProfileText("display name", profile.DisplayName, helpers.MaxDisplayNameLength); err != nil {
+		return err
+	}
+	if err := helpers.ValidateProfileText("bio", profile.Bio, helpers.MaxBioLength); err != nil {
+		return err
+	}
+	if err := helpers.ValidateProfileText("location", profile.Location, helpers.MaxLocationLength); err != nil {
+		return err
+	}
+
+	if profile.Website != "" {
+		if err := helpers.ValidateWebsite(profile.Website); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateProfileHandler replaces the caller's profile, fields left out are cleared
+func (cfg *ApiConfig) UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	profile := database.Profile{}
+	err = helpers.RequestBodyValidator(r, &profile)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := validateProfile(&profile); err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	_, code, err := cfg.DB.UpdateProfile(userId, profile)
+	if err != nil {
+		log.Printf("Error updating profile: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	cfg.respondWithOwnUser(w, r, userId)
+}
+
+// readAvatar reads the image from a multipart form field named avatar, or from the raw request body
+func readAvatar(r *http.Request) ([]byte, error) {
+	var src io.Reader = r.Body
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("avatar")
+		if err != nil {
+			return nil, fmt.Errorf("avatar file is missing: %v", err)
+		}
+		defer file.Close()
+		src = file
+	}
+
+	return io.ReadAll(src)
+}
+
+// UploadAvatarHandler stores a PNG, JPEG or GIF avatar for the caller, replacing the previous one
+func (cfg *ApiConfig) UploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxAvatarSize+1024)
+	dat, err := readAvatar(r)
+	if err != nil {
+		if maxErr := (*http.MaxBytesError)(nil); errors.As(err, &maxErr) {
+			helpers.RespondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("avatar can't be larger than %d bytes", MaxAvatarSize))
+			return
+		}
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(dat) > MaxAvatarSize {
+		helpers.RespondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("avatar can't be larger than %d bytes", MaxAvatarSize))
+		return
+	}
+
+	// The image has to decode as what it claims to be, the extension is taken from the decoder and not the upload
+	config, format, err := image.DecodeConfig(bytes.NewReader(dat))
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "avatar must be a PNG, JPEG or GIF image")
+		return
+	}
+
+	if config.Width > MaxAvatarDimension || config.Height > MaxAvatarDimension {
+		helpers.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("avatar can't be larger than %dx%d pixels", MaxAvatarDimension, MaxAvatarDimension))
+		return
+	}
+
+	if err := os.MkdirAll(cfg.AvatarDir, 0755); err != nil {
+		log.Printf("Error creating avatar directory: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, "error storing avatar")
+		return
+	}
+
+	sum := sha256.Sum256(dat)
+	name := userId + "-" + hex.EncodeToString(sum[:8]) + "." + format
+	if err := os.WriteFile(filepath.Join(cfg.AvatarDir, name), dat, 0644); err != nil {
+		log.Printf("Error writing avatar: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, "error storing avatar")
+		return
+	}
+
+	previous, code, err := cfg.DB.SetAvatar(userId, name)
+	if err != nil {
+		log.Printf("Error setting avatar: %s", err)
+		os.Remove(filepath.Join(cfg.AvatarDir, name))
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+	cfg.removeAvatar(previous, name)
+
+	cfg.respondWithOwnUser(w, r, userId)
+}
+
+func (cfg *ApiConfig) DeleteAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	previous, code, err := cfg.DB.SetAvatar(userId, "")
+	if err != nil {
+		log.Printf("Error removing avatar: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+	cfg.removeAvatar(previous, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeAvatar deletes an avatar file that is no longer used, unless the same image was uploaded again
+func (cfg *ApiConfig) removeAvatar(name string, current string) {
+	if name == "" || name == current {
+		return
+	}
+
+	if err := os.Remove(filepath.Join(cfg.AvatarDir, name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing avatar %s: %s", name, err)
+	}
+}
+
+func (cfg *ApiConfig) GetAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user, code, err := cfg.DB.GetPublicUser(r.PathValue("id"))
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	if user.Avatar == "" {
+		helpers.RespondWithError(w, http.StatusNotFound, "user has no avatar")
+		return
+	}
+
+	// Avatar urls carry the version, a new avatar gets a new url
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(w, r, filepath.Join(cfg.AvatarDir, user.Avatar))
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxSuspendDays is the longest suspension, longer ones would overflow the duration and end in the past
+const MaxSuspendDays = 3650
+
+type ReportRequestBody struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+type ModerationActionRequestBody struct {
+	Action      string `json:"action"`
+	Note        string `json:"note"`
+	SuspendDays int    `json:"suspend_days"`
+}
+
+func (cfg *ApiConfig) ReportChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error: %s", err)
+		helpers.RespondWithError(w, http.StatusBadRequest, "Error getting chirp: "+err.Error())
+		return
+	}
+
+	body := ReportRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !database.IsValidReportReason(body.Reason) {
+		helpers.RespondWithError(w, http.StatusBadRequest, "Reason must be one of "+strings.Join(database.ReportReasons, ", "))
+		return
+	}
+
+	if len(body.Details) > 500 {
+		helpers.RespondWithError(w, http.StatusBadRequest, "Details must be at most 500 characters")
+		return
+	}
+
+	report, code, err := cfg.DB.ReportChirp(id, userId, body.Reason, body.Details, cfg.ReportHideThreshold)
+	if err != nil {
+		log.Printf("Error reporting chirp: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, code, report)
+}
+
+// GetModerationQueueHandler lists moderation cases, main.go only lets moderators and admins reach it
+func (cfg *ApiConfig) GetModerationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = database.CaseOpen
+	case "all":
+		status = ""
+	case database.CaseOpen, database.CaseResolved:
+	default:
+		helpers.RespondWithError(w, http.StatusBadRequest, "Status must be open, resolved or all")
+		return
+	}
+
+	queue, err := cfg.DB.GetModerationQueue(status)
+	if err != nil {
+		log.Printf("Error getting moderation queue: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, queue)
+}
+
+func (cfg *ApiConfig) ModerationActionHandler(w http.ResponseWriter, r *http.Request) {
+	moderator, err := cfg.getAuthFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chirpId, err := strconv.Atoi(r.PathValue("chirpId"))
+	if err != nil {
+		log.Printf("Error: %s", err)
+		helpers.RespondWithError(w, http.StatusBadRequest, "Error getting chirp: "+err.Error())
+		return
+	}
+
+	body := ModerationActionRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	suspendFor := time.Duration(0)
+	if body.Action == database.AdminActionSuspend {
+		if body.SuspendDays < 1 || body.SuspendDays > MaxSuspendDays {
+			helpers.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("suspend_days must be between 1 and %d", MaxSuspendDays))
+			return
+		}
+		suspendFor = time.Duration(body.SuspendDays) * 24 * time.Hour
+	}
+
+	// Kept to announce the deletion, the chirp is gone once the case is resolved
+	chirp, chirpErr := cfg.DB.GetChirp(chirpId)
+
+	c, code, err := cfg.DB.ResolveModerationCase(chirpId, moderator.UserId, moderator.Role, body.Action, body.Note, suspendFor)
+	if err != nil {
+		log.Printf("Error resolving moderation case: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	if body.Action == database.AdminActionDelete && chirpErr == nil {
+		cfg.publishChirpDeleted(chirp)
+		cfg.federateChirpDeleted(chirp)
+	}
+
+	log.Printf("Moderator %s took action %s on chirp %d", moderator.UserId, body.Action, chirpId)
+	helpers.RespondWithJSON(w, code, c)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type SetRoleRequestBody struct {
+	Role string `json:"role"`
+}
+
+type RoleResponseBody struct {
+	UserId      string   `json:"user_id"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// MiddlewarePermissions declares the permissions a route needs, they come from the role in the access token.
+// Personal access tokens carry no permissions, so they never get past it
+func (cfg *ApiConfig) MiddlewarePermissions(next http.Handler, permissions ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, err := cfg.authenticateToken(bearerToken(r))
+		if err != nil {
+			log.Printf("Error validating JWT: %s", err)
+			helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		if missing := auth.missingPermissions(permissions...); len(missing) > 0 {
+			log.Printf("User %s tried to access %s without the permissions %s", auth.UserId, r.URL.Path, strings.Join(missing, ", "))
+			helpers.RespondWithError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenAuthKey{}, auth)))
+	})
+}
+
+// SetUserRoleHandler gives a user another role, admins can't change their own so there's always one left
+func (cfg *ApiConfig) SetUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	adminId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	body := SetRoleRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	userId := r.PathValue("id")
+	if userId == adminId {
+		helpers.RespondWithError(w, http.StatusBadRequest, "you can't change your own role")
+		return
+	}
+
+	user, code, err := cfg.DB.SetUserRole(userId, body.Role)
+	if err != nil {
+		log.Printf("Error setting role: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	log.Printf("Admin %s gave user %s the role %s", adminId, userId, user.RoleName())
+	helpers.RespondWithJSON(w, http.StatusOK, RoleResponseBody{
+		UserId:      user.Id,
+		Role:        user.RoleName(),
+		Permissions: database.RolePermissions(user.RoleName()),
+	})
+}
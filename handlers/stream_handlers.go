@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/events"
+	"chirpy/helpers"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// TopicChirps is the broker topic every chirp event is published on
+	TopicChirps = "chirps"
+
+	streamHeartbeatInterval = 15 * time.Second
+	// streamAuthCheckInterval is how often the token of an authenticated stream is checked again
+	streamAuthCheckInterval = 5 * time.Second
+	// streamClientBuffer is how many events a slow client can fall behind before it's disconnected
+	streamClientBuffer = 64
+)
+
+// ChirpDeletedEvent is the payload of a chirp.deleted event
+type ChirpDeletedEvent struct {
+	Id     int    `json:"id"`
+	UserId string `json:"user_id"`
+}
+
+// ChirpEvent is the data of the events published on TopicChirps, the audience is worked out once
+// when the event is published, so it can be checked against every client without loading the database
+type ChirpEvent struct {
+	Chirp    database.Chirpy
+	audience database.ChirpAudience
+}
+
+// publishChirpCreated announces a new chirp, chirps held by moderation are never announced
+func (cfg *ApiConfig) publishChirpCreated(chirp database.Chirpy) {
+	if chirp.Moderation != nil && chirp.Moderation.Action == database.ModerationHeld {
+		return
+	}
+
+	cfg.publishChirpEvent(events.ChirpCreated, chirp)
+}
+
+// publishChirpDeleted announces a deleted chirp, the chirp is kept so visibility can still be checked
+func (cfg *ApiConfig) publishChirpDeleted(chirp database.Chirpy) {
+	cfg.publishChirpEvent(events.ChirpDeleted, chirp)
+}
+
+func (cfg *ApiConfig) publishChirpEvent(eventType string, chirp database.Chirpy) {
+	audience, err := cfg.DB.GetChirpAudience(chirp)
+	if err != nil {
+		log.Printf("Error getting chirp audience: %s", err)
+		return
+	}
+
+	cfg.Events.Publish(TopicChirps, eventType, ChirpEvent{Chirp: chirp, audience: audience})
+}
+
+// canSeeChirpEvent checks the chirp an event carries against the same visibility rules as the read endpoints
+func (cfg *ApiConfig) canSeeChirpEvent(event events.Event, viewerId string) (any, bool) {
+	data, ok := event.Data.(ChirpEvent)
+	if !ok || !data.audience.CanView(viewerId) {
+		return nil, false
+	}
+
+	if event.Type == events.ChirpDeleted {
+		return ChirpDeletedEvent{Id: data.Chirp.Id, UserId: data.Chirp.UserId}, true
+	}
+
+	return data.Chirp, true
+}
+
+// StreamChirpsHandler streams created and deleted chirps as Server-Sent Events.
+// It can be filtered with ?author_id= and resumes after the Last-Event-ID header while the event is still in the backlog
+func (cfg *ApiConfig) StreamChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	viewerId, err := cfg.getOptionalUserId(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		helpers.RespondWithError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	lastEventId := uint64(0)
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		lastEventId, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			helpers.RespondWithError(w, http.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+	}
+
+	authorId := r.URL.Query().Get("author_id")
+	filter := func(e events.Event) bool {
+		if e.Topic != TopicChirps {
+			return false
+		}
+
+		data, ok := e.Data.(ChirpEvent)
+		return ok && (authorId == "" || data.Chirp.UserId == authorId)
+	}
+
+	sub, replay, complete := cfg.Events.Subscribe(filter, streamClientBuffer, lastEventId)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Events were missed while the client was away, it has to reload the chirps it shows
+	if !complete {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+
+	for _, e := range replay {
+		if !cfg.writeChirpEvent(w, e, viewerId) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	authCheck := time.NewTicker(streamAuthCheckInterval)
+	defer authCheck.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.Done():
+			return
+		case <-authCheck.C:
+			// The stream ends once the token stops being valid, reconnecting with it then fails
+			if viewerId == "" {
+				continue
+			}
+			if _, err := cfg.authenticateToken(bearerToken(r)); err != nil {
+				log.Printf("Closing stream of user %s: %s", viewerId, err)
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e := <-sub.Events():
+			if !cfg.writeChirpEvent(w, e, viewerId) {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeChirpEvent writes one SSE event if the viewer may see it, it returns false once the client is gone
+func (cfg *ApiConfig) writeChirpEvent(w http.ResponseWriter, e events.Event, viewerId string) bool {
+	data, ok := cfg.canSeeChirpEvent(e, viewerId)
+	if !ok {
+		return true
+	}
+
+	dat, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error marshalling event: %s", err)
+		return true
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, e.Type, dat)
+	return err == nil
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type TimelineResponseBody struct {
+	Chirps []database.Chirpy `json:"chirps"`
+	// NextCursor is passed back as ?cursor= to get the next page, empty when there are no more chirps
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// TimelineHandler returns chirps from the users the caller follows plus their own, newest first
+func (cfg *ApiConfig) TimelineHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	_, limit, err := helpers.ParsePagination(r)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	before := 0
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		before, err = strconv.Atoi(cursor)
+		if err != nil || before < 1 {
+			helpers.RespondWithError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+	}
+
+	chirps, next, err := cfg.DB.GetTimeline(userId, before, limit)
+	if err != nil {
+		log.Printf("Error getting timeline: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cfg.recordViews(r, userId, chirps...)
+
+	response := TimelineResponseBody{Chirps: chirps}
+	if next != 0 {
+		response.NextCursor = strconv.Itoa(next)
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, response)
+}
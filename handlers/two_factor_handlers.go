@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"chirpy/totp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// MFATokenTTL is how long a user has to give their code after the password
+	MFATokenTTL = 5 * time.Minute
+	// RecoveryCodeCount recovery codes are handed out when two-factor is enabled
+	RecoveryCodeCount = 10
+	// TOTPIssuer is the name authenticator apps show next to the code
+	TOTPIssuer = "Chirpy"
+)
+
+type MFAChallengeResponseBody struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type LoginMFARequestBody struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TwoFactorRequestBody struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TwoFactorEnrolmentResponseBody struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type RecoveryCodesResponseBody struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// newRecoveryCodes returns codes to show the user once, and their hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	for i := 0; i < RecoveryCodeCount; i++ {
+		ran := make([]byte, 5)
+		if _, err := rand.Read(ran); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(ran))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes, so the code can be typed however it was written down
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if code == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkPassword asks for the password again before changing how the user logs in,
+// so a stolen access token alone can't do it
+func (cfg *ApiConfig) checkPassword(userId string, password string) (database.User, int, error) {
+	user, code, err := cfg.DB.GetUserById(userId)
+	if err != nil {
+		return database.User{}, code, err
+	}
+
+	err = bcrypt.CompareHashAndPassword(user.Password, []byte(password))
+	if err != nil {
+		return database.User{}, http.StatusForbidden, fmt.Errorf("incorrect password")
+	}
+
+	return user, http.StatusOK, nil
+}
+
+func (cfg *ApiConfig) respondWithMFAChallenge(w http.ResponseWriter, user database.User) {
+	mfaToken, err := helpers.GenerateMFAToken(user.Id, cfg.JWTSecret, MFATokenTTL)
+	if err != nil {
+		log.Printf("Error generating MFA token: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, MFAChallengeResponseBody{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+		ExpiresAt:   time.Now().UTC().Add(MFATokenTTL),
+	})
+}
+
+// validateMFAToken returns the user id an MFA challenge token was issued for
+func (cfg *ApiConfig) validateMFAToken(tokenString string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(key *jwt.Token) (interface{}, error) {
+		return []byte(cfg.JWTSecret), nil
+	}, jwt.WithIssuer(helpers.MFATokenIssuer), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid || claims.Subject == "" {
+		return "", fmt.Errorf("invalid MFA token")
+	}
+
+	return claims.Subject, nil
+}
+
+// LoginMFAHandler is the second login step for users with two-factor authentication,
+// it takes the challenge token from LoginHandler and a code from the app or a recovery code
+func (cfg *ApiConfig) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	body := LoginMFARequestBody{}
+	err := helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	userId, err := cfg.validateMFAToken(body.MFAToken)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if body.Code == "" && body.RecoveryCode == "" {
+		helpers.RespondWithError(w, http.StatusBadRequest, "code or recovery_code is required")
+		return
+	}
+
+	user, code, err := cfg.DB.VerifyTwoFactorCode(userId, body.Code, hashRecoveryCode(body.RecoveryCode))
+	if err != nil {
+		log.Printf("error verifying two-factor code of user %s: %s", userId, err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	if user.IsSuspended() {
+		log.Printf("suspended user %s tried to log in", user.Id)
+		helpers.RespondWithError(w, http.StatusForbidden, "your account is suspended until "+user.SuspendedUntil.Format(time.RFC3339))
+		return
+	}
+
+	cfg.completeLogin(w, user)
+}
+
+// StartTwoFactorHandler generates a secret for the caller's authenticator app,
+// two-factor is only enabled once a code from the app is confirmed
+func (cfg *ApiConfig) StartTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	body := TwoFactorRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, code, err := cfg.checkPassword(userId, body.Password)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, "error generating secret")
+		return
+	}
+
+	code, err = cfg.DB.StartTwoFactorEnrolment(userId, secret)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, TwoFactorEnrolmentResponseBody{
+		Secret: secret,
+		URI:    totp.URI(TOTPIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactorHandler enables two-factor with a code from the app, and responds with
+// the recovery codes, which are never shown again
+func (cfg *ApiConfig) ConfirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	body := TwoFactorRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, "error generating recovery codes")
+		return
+	}
+
+	code, err := cfg.DB.ConfirmTwoFactor(userId, body.Code, hashes)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, RecoveryCodesResponseBody{RecoveryCodes: codes})
+}
+
+// RegenerateRecoveryCodesHandler replaces the caller's recovery codes, it takes a code from the app
+func (cfg *ApiConfig) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	body := TwoFactorRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if body.Code == "" {
+		helpers.RespondWithError(w, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	_, code, err := cfg.DB.VerifyTwoFactorCode(userId, body.Code, "")
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %s", err)
+		helpers.RespondWithError(w, http.StatusInternalServerError, "error generating recovery codes")
+		return
+	}
+
+	code, err = cfg.DB.SetRecoveryCodes(userId, hashes)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	helpers.RespondWithJSON(w, http.StatusOK, RecoveryCodesResponseBody{RecoveryCodes: codes})
+}
+
+// DisableTwoFactorHandler turns two-factor off, it takes the password and a code from the app or a recovery code.
+// A pending setup that was never confirmed only needs the password
+func (cfg *ApiConfig) DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	body := TwoFactorRequestBody{}
+	err = helpers.RequestBodyValidator(r, &body)
+	if err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, code, err := cfg.checkPassword(userId, body.Password)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	if user.HasTwoFactor() {
+		if body.Code == "" && body.RecoveryCode == "" {
+			helpers.RespondWithError(w, http.StatusBadRequest, "code or recovery_code is required")
+			return
+		}
+
+		_, code, err := cfg.DB.VerifyTwoFactorCode(userId, body.Code, hashRecoveryCode(body.RecoveryCode))
+		if err != nil {
+			helpers.RespondWithError(w, code, err.Error())
+			return
+		}
+	}
+
+	code, err = cfg.DB.DisableTwoFactor(userId)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	w.WriteHeader(code)
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"chirpy/database"
 	"chirpy/helpers"
+	"encoding/json"
 	"fmt"
 	"golang.org/x/crypto/bcrypt"
 	"log"
@@ -16,9 +18,10 @@ type UsersRequestBody struct {
 }
 
 type UsersResponseBody struct {
-	Id          string `json:"id"`
-	Email       string `json:"email"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Id            string `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
 }
 
 func (cfg *ApiConfig) validateNewUsers(r *http.Request, userRequest *UsersRequestBody) (int, error) {
@@ -61,59 +64,174 @@ func (cfg *ApiConfig) RegisterUsersHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// The account works without a verified email, so a failed email is only logged and can be sent again
+	if _, _, err := cfg.sendEmailVerification(createdUser.Id); err != nil {
+		log.Printf("Error sending email verification: %s", err)
+	}
+
 	responseUser := UsersResponseBody{
-		Id:          createdUser.Id,
-		Email:       createdUser.Email,
-		IsChirpyRed: createdUser.IsChirpyRed,
+		Id:            createdUser.Id,
+		Email:         createdUser.Email,
+		EmailVerified: createdUser.EmailVerified,
+		IsChirpyRed:   createdUser.IsChirpyRed,
 	}
 
 	helpers.RespondWithJSON(w, code, responseUser)
 }
 
-func (cfg *ApiConfig) UpdateUsersHandler(w http.ResponseWriter, r *http.Request) {
-	requestHeader := r.Header.Get("Authorization")
-	// Takes access token btw
-	tokenString := strings.TrimPrefix(requestHeader, "Bearer ")
+// UpdateOwnUserResponseBody carries new tokens when the password changed, the old ones stop working
+type UpdateOwnUserResponseBody struct {
+	OwnUserResponseBody
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// patchString decodes a merge-patch value that is a string or null, null clears the field
+func patchString(field string, raw json.RawMessage) (string, error) {
+	var value *string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("%s must be a string or null", field)
+	}
+
+	if value == nil {
+		return "", nil
+	}
+
+	return *value, nil
+}
 
-	token, err := cfg.validateJWTToken(tokenString)
+// UpdateOwnUserHandler applies a JSON merge patch (RFC 7396) to the caller's account: fields left out are kept,
+// profile fields set to null are cleared. Changing the email or password needs the current password
+func (cfg *ApiConfig) UpdateOwnUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
 	if err != nil {
-		log.Printf(err.Error())
+		log.Printf("Error validating JWT: %s", err)
 		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
 
-	userId, err := token.Claims.GetSubject()
+	fields := map[string]json.RawMessage{}
+	err = helpers.RequestBodyValidator(r, &fields)
 	if err != nil {
-		log.Printf("Invalid token: %s", err)
-		helpers.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
+		helpers.RespondWithError(w, http.StatusBadRequest, "request body must be a JSON object")
 		return
 	}
 
-	userUpdateRequest := UsersRequestBody{}
-	code, err := cfg.validateNewUsers(r, &userUpdateRequest)
+	user, code, err := cfg.DB.GetUserById(userId)
 	if err != nil {
 		helpers.RespondWithError(w, code, err.Error())
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userUpdateRequest.Password), bcrypt.DefaultCost)
-	if err != nil {
-		log.Printf("Failed to hash password: %s", err)
-		helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
-		return
+	patch := database.UserPatch{}
+	profile := user.Profile
+	profileFields := map[string]*string{
+		"handle":       &profile.Handle,
+		"display_name": &profile.DisplayName,
+		"bio":          &profile.Bio,
+		"location":     &profile.Location,
+		"website":      &profile.Website,
+	}
+	var newPassword, currentPassword string
+
+	for field, raw := range fields {
+		target, isProfileField := profileFields[field]
+		if !isProfileField && field != "email" && field != "password" && field != "current_password" {
+			helpers.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("unknown field %q", field))
+			return
+		}
+
+		value, err := patchString(field, raw)
+		if err != nil {
+			helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		switch field {
+		case "email":
+			value = strings.TrimSpace(value)
+			if !helpers.IsValidEmail(value) {
+				helpers.RespondWithError(w, http.StatusBadRequest, "invalid email")
+				return
+			}
+			if value != user.Email {
+				patch.Email = &value
+			}
+		case "password":
+			if err := helpers.ValidatePassword(value); err != nil {
+				helpers.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid password: %s", err))
+				return
+			}
+			newPassword = value
+		case "current_password":
+			currentPassword = value
+		default:
+			*target = value
+			patch.Profile = &profile
+		}
 	}
 
-	user, code, err := cfg.DB.UpdateUser(userId, userUpdateRequest.Email, hashedPassword)
+	if patch.Email != nil || newPassword != "" {
+		if currentPassword == "" {
+			helpers.RespondWithError(w, http.StatusBadRequest, "current_password is required to change the email or password")
+			return
+		}
+
+		if err := bcrypt.CompareHashAndPassword(user.Password, []byte(currentPassword)); err != nil {
+			helpers.RespondWithError(w, http.StatusForbidden, "incorrect password")
+			return
+		}
+	}
+
+	if newPassword != "" {
+		patch.Password, err = bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Failed to hash password: %s", err)
+			helpers.RespondWithError(w, http.StatusInternalServerError, "error updating password")
+			return
+		}
+	}
+
+	if patch.Profile != nil {
+		if err := validateProfile(patch.Profile); err != nil {
+			helpers.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	user, code, err = cfg.DB.PatchUser(userId, patch)
 	if err != nil {
 		log.Printf("Error updating user: %s", err)
 		helpers.RespondWithError(w, code, err.Error())
 		return
 	}
 
-	userResponse := UsersResponseBody{
-		Id:    user.Id,
-		Email: user.Email,
+	// A new email has to be verified again
+	if patch.Email != nil {
+		if _, _, err := cfg.sendEmailVerification(userId); err != nil {
+			log.Printf("Error sending email verification: %s", err)
+		}
+	}
+
+	response := UpdateOwnUserResponseBody{}
+
+	// Every session of the user was revoked with the old password, the caller gets a new one
+	if patch.Password != nil {
+		accessToken, refreshToken, err := cfg.startSession(w, user)
+		if err != nil {
+			log.Printf("error starting session: %s", err)
+			helpers.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		response.Token = accessToken
+		response.RefreshToken = refreshToken.Token
+	}
+
+	response.OwnUserResponseBody, code, err = cfg.ownUser(r, userId)
+	if err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
 	}
 
-	helpers.RespondWithJSON(w, code, userResponse)
+	helpers.RespondWithJSON(w, http.StatusOK, response)
 }
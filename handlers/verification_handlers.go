@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"chirpy/database"
+	"chirpy/helpers"
+	"chirpy/mail"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// EmailVerificationTTL is how long the link in a verification email works
+	EmailVerificationTTL = 24 * time.Hour
+	// VerificationEmailInterval and VerificationEmailsPerDay limit how often a user can have the email sent
+	VerificationEmailInterval = time.Minute
+	VerificationEmailsPerDay  = 5
+)
+
+type VerifyEmailRequestBody struct {
+	Token string `json:"token"`
+}
+
+// newEmailToken returns a random token for an email link and the hash of it that is stored
+func newEmailToken() (string, string, error) {
+	ran := make([]byte, 32)
+	if _, err := rand.Read(ran); err != nil {
+		return "", "", err
+	}
+
+	token := hex.EncodeToString(ran)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendEmailVerification emails the user a link to verify their current email.
+// On 429 the returned verification tells when the next email can be sent
+func (cfg *ApiConfig) sendEmailVerification(userId string) (database.EmailVerification, int, error) {
+	baseURL, err := cfg.publicURL()
+	if err != nil {
+		return database.EmailVerification{}, http.StatusServiceUnavailable, err
+	}
+
+	token, tokenHash, err := newEmailToken()
+	if err != nil {
+		return database.EmailVerification{}, http.StatusInternalServerError, fmt.Errorf("error generating token: %v", err)
+	}
+
+	verification, code, err := cfg.DB.CreateEmailVerification(userId, tokenHash, EmailVerificationTTL, VerificationEmailInterval, VerificationEmailsPerDay)
+	if err != nil {
+		return verification, code, err
+	}
+
+	link := baseURL + "/api/email/verify?token=" + url.QueryEscape(token)
+	err = cfg.Mailer.Send(mail.Message{
+		To:      verification.Email,
+		Subject: "Verify your Chirpy email",
+		Body: "Hi,\n\n" +
+			"Open this link to verify the email of your Chirpy account:\n\n" +
+			link + "\n\n" +
+			fmt.Sprintf("The link works for %d hours. If you didn't sign up for Chirpy, you can ignore this email.\n", int(EmailVerificationTTL.Hours())),
+	})
+	if err != nil {
+		return verification, http.StatusBadGateway, fmt.Errorf("error sending verification email: %v", err)
+	}
+
+	return verification, http.StatusAccepted, nil
+}
+
+// ResendEmailVerificationHandler sends a new verification email, the link in any earlier one stops working
+func (cfg *ApiConfig) ResendEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.getUserIdFromRequest(r)
+	if err != nil {
+		log.Printf("Error validating JWT: %s", err)
+		helpers.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	verification, code, err := cfg.sendEmailVerification(userId)
+	if err != nil {
+		if code == http.StatusTooManyRequests {
+			retryAfter := time.Until(verification.Sent.NextSendAt(VerificationEmailInterval, VerificationEmailsPerDay))
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		}
+		log.Printf("Error sending email verification: %s", err)
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// confirmEmailTemplate is the page the link in a verification email opens. Opening it changes nothing,
+// link previews and mail scanners follow links too, the button posts the token to verify the email
+var confirmEmailTemplate = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Verify your Chirpy email</title>
+</head>
+<body>
+<h1>Verify your Chirpy email</h1>
+<p id="message">Press the button to verify the email of your Chirpy account.</p>
+<button id="verify" data-token="{{.}}">Verify email</button>
+<script>
+const button = document.getElementById("verify");
+button.addEventListener("click", async () => {
+	button.disabled = true;
+	const resp = await fetch("/api/email/verify", {
+		method: "POST",
+		headers: {"Content-Type": "application/json"},
+		body: JSON.stringify({token: button.dataset.token}),
+	});
+	document.getElementById("message").textContent = resp.ok
+		? "Your email is verified, you can close this page."
+		: "The link is invalid or has expired, ask for a new verification email.";
+	button.hidden = true;
+});
+</script>
+</body>
+</html>
+`))
+
+// ConfirmEmailHandler shows the page to confirm the email verification, the token stays in the page
+func (cfg *ApiConfig) ConfirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		helpers.RespondWithError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(http.StatusOK)
+
+	if err := confirmEmailTemplate.Execute(w, token); err != nil {
+		log.Printf("Error writing confirmation page: %s", err)
+	}
+}
+
+// VerifyEmailHandler verifies the email with the token from the link in the email
+func (cfg *ApiConfig) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	body := VerifyEmailRequestBody{}
+	if err := helpers.RequestBodyValidator(r, &body); err != nil {
+		helpers.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if body.Token == "" {
+		helpers.RespondWithError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	if _, code, err := cfg.DB.VerifyEmail(hashToken(body.Token)); err != nil {
+		helpers.RespondWithError(w, code, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"bytes"
+	"chirpy/database"
+	"chirpy/helpers"
+	"chirpy/mail"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newVerificationConfig(t *testing.T) (*ApiConfig, *mail.MemoryOutbox, http.Handler) {
+	t.Helper()
+
+	db, err := database.NewDBAt(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := mail.NewMemoryOutbox()
+	cfg := &ApiConfig{
+		DB:        db,
+		JWTSecret: "secret",
+		Mailer:    outbox,
+		PublicURL: "https://chirpy.example.com",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/users", cfg.RegisterUsersHandler)
+	mux.HandleFunc("POST /api/users/me/email/verification", cfg.ResendEmailVerificationHandler)
+	mux.HandleFunc("GET /api/email/verify", cfg.ConfirmEmailHandler)
+	mux.HandleFunc("POST /api/email/verify", cfg.VerifyEmailHandler)
+
+	return cfg, outbox, mux
+}
+
+func serve(t *testing.T, handler http.Handler, method string, target string, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var dat []byte
+	if body != nil {
+		var err error
+		if dat, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, target, bytes.NewReader(dat))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// verificationToken takes the token out of the link in the latest verification email to the address
+func verificationToken(t *testing.T, outbox *mail.MemoryOutbox, to string) string {
+	t.Helper()
+
+	msg, ok := outbox.Last(to)
+	if !ok {
+		t.Fatalf("no email was sent to %s", to)
+	}
+
+	const prefix = "https://chirpy.example.com/api/email/verify?token="
+	start := strings.Index(msg.Body, prefix)
+	if start < 0 {
+		t.Fatalf("email has no verification link:\n%s", msg.Body)
+	}
+
+	link, _, _ := strings.Cut(msg.Body[start:], "\n")
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u.Query().Get("token")
+}
+
+func TestEmailVerification(t *testing.T) {
+	cfg, outbox, mux := newVerificationConfig(t)
+
+	rec := serve(t, mux, http.MethodPost, "/api/users", "", UsersRequestBody{Email: "alice@example.com", Password: "Passw0rdX"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+
+	registered := UsersResponseBody{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &registered); err != nil {
+		t.Fatal(err)
+	}
+	if registered.EmailVerified {
+		t.Fatal("new user's email is verified")
+	}
+
+	token := verificationToken(t, outbox, "alice@example.com")
+
+	// The email was just sent, a new one has to wait
+	user, _, err := cfg.DB.GetUserById(registered.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := helpers.GenerateJWTToken(user, cfg.JWTSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = serve(t, mux, http.MethodPost, "/api/users/me/email/verification", accessToken, nil)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("resend status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("429 has no Retry-After header")
+	}
+	if n := len(outbox.Messages()); n != 1 {
+		t.Fatalf("%d emails were sent, want 1", n)
+	}
+
+	// Opening the link only shows the confirmation page
+	rec = serve(t, mux, http.MethodGet, "/api/email/verify?token="+url.QueryEscape(token), "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("confirmation page status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), token) || strings.Contains(rec.Body.String(), "alice@example.com") {
+		t.Errorf("confirmation page should hold the token and nothing about the account:\n%s", rec.Body)
+	}
+
+	user, _, err = cfg.DB.GetUserById(registered.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.EmailVerified {
+		t.Fatal("opening the link verified the email")
+	}
+
+	rec = serve(t, mux, http.MethodPost, "/api/email/verify", "", VerifyEmailRequestBody{Token: token})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("verify status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("verify responded with a body: %s", rec.Body)
+	}
+
+	user, _, err = cfg.DB.GetUserById(registered.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.EmailVerified {
+		t.Fatal("email is not verified")
+	}
+
+	// The token is used up
+	rec = serve(t, mux, http.MethodPost, "/api/email/verify", "", VerifyEmailRequestBody{Token: token})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("second verify status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestEmailVerificationExpired(t *testing.T) {
+	cfg, _, mux := newVerificationConfig(t)
+
+	user, _, err := cfg.DB.CreateUsers("bob@example.com", []byte("Passw0rdX"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, tokenHash, err := newEmailToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cfg.DB.CreateEmailVerification(user.Id, tokenHash, -time.Minute, VerificationEmailInterval, VerificationEmailsPerDay); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(t, mux, http.MethodPost, "/api/email/verify", "", VerifyEmailRequestBody{Token: token})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("verify status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	user, _, err = cfg.DB.GetUserById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.EmailVerified {
+		t.Fatal("an expired token verified the email")
+	}
+}
+
+func TestEmailVerificationNeedsPublicURL(t *testing.T) {
+	cfg, outbox, mux := newVerificationConfig(t)
+	cfg.PublicURL = ""
+
+	rec := serve(t, mux, http.MethodPost, "/api/users", "", UsersRequestBody{Email: "carol@example.com", Password: "Passw0rdX"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+
+	registered := UsersResponseBody{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &registered); err != nil {
+		t.Fatal(err)
+	}
+
+	user, _, err := cfg.DB.GetUserById(registered.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := helpers.GenerateJWTToken(user, cfg.JWTSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = serve(t, mux, http.MethodPost, "/api/users/me/email/verification", accessToken, nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("resend status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	if n := len(outbox.Messages()); n != 0 {
+		t.Fatalf("%d emails were sent without PUBLIC_URL", n)
+	}
+}
@@ -0,0 +1,115 @@
+package helpers
+
+import (
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// URLWeight is how many characters a link counts for, no matter how long it is
+const URLWeight = 23
+
+var urlRegex = regexp.MustCompile(`https?://[^\s]+`)
+
+// chirpNormalizers run in order on every chirp body before it is measured or stored
+var chirpNormalizers = []func(string) string{
+	norm.NFC.String,
+	stripInvisible,
+	strings.TrimSpace,
+}
+
+// NormalizeChirp runs the chirp body through the normalisation pipeline
+func NormalizeChirp(s string) string {
+	for _, normalize := range chirpNormalizers {
+		s = normalize(s)
+	}
+
+	return s
+}
+
+// ChirpLength counts what the user sees: grapheme clusters instead of bytes,
+// so an emoji counts as one, and every link counts as URLWeight
+func ChirpLength(s string) int {
+	length := 0
+	last := 0
+
+	for _, loc := range urlRegex.FindAllStringIndex(s, -1) {
+		length += uniseg.GraphemeClusterCount(s[last:loc[0]]) + URLWeight
+		last = loc[1]
+	}
+
+	return length + uniseg.GraphemeClusterCount(s[last:])
+}
+
+// joinerScripts are the scripts where ZWNJ and ZWJ change how letters are written. Anywhere else a joiner
+// between letters only splits a word for the moderation filters while it looks the same
+var joinerScripts = []*unicode.RangeTable{
+	unicode.Arabic, unicode.Syriac, unicode.Nko, unicode.Mongolian,
+	unicode.Devanagari, unicode.Bengali, unicode.Gurmukhi, unicode.Gujarati, unicode.Oriya,
+	unicode.Tamil, unicode.Telugu, unicode.Kannada, unicode.Malayalam, unicode.Sinhala,
+	unicode.Myanmar, unicode.Khmer,
+}
+
+// stripInvisible removes control and zero-width characters, except line breaks and tabs.
+// Joiners and bidi marks are part of how some scripts are written: ZWNJ and ZWJ between letters
+// of joinerScripts (Persian, Indic scripts), ZWJ in emoji sequences, LRM and RLM in mixed direction text.
+// They are kept inside the text and only dropped at the edges or when repeated
+func stripInvisible(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	runes := []rune(s)
+	var prev rune
+	for i, r := range runes {
+		var next rune
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case r == '\n' || r == '\t':
+		case r == '\u200c' || r == '\u200d':
+			if next == r || !keepJoiner(r, prev, next) {
+				continue
+			}
+		case r == '\u200e' || r == '\u200f':
+			if next == r || prev == 0 || next == 0 || unicode.IsSpace(next) {
+				continue
+			}
+		case unicode.IsControl(r), isZeroWidth(r):
+			continue
+		}
+
+		b.WriteRune(r)
+		prev = r
+	}
+
+	return b.String()
+}
+
+// keepJoiner returns true if the joiner sits between two letters of a script that needs it,
+// or for ZWJ inside an emoji sequence
+func keepJoiner(joiner rune, prev rune, next rune) bool {
+	if unicode.In(prev, joinerScripts...) && unicode.In(next, joinerScripts...) {
+		return true
+	}
+
+	return joiner == '\u200d' && isEmojiPart(prev) && next != 0
+}
+
+func isZeroWidth(r rune) bool {
+	switch r {
+	case '\u200b', '\u2060', '\u180e', '\ufeff':
+		return true
+	}
+
+	// Bidi embeddings/isolates are invisible and can be used to spoof text
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// isEmojiPart returns true for runes that can sit right before a joiner in an emoji sequence
+func isEmojiPart(r rune) bool {
+	return unicode.Is(unicode.So, r) || unicode.Is(unicode.Sk, r) || r == '\ufe0f'
+}
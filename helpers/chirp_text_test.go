@@ -0,0 +1,48 @@
+package helpers
+
+import (
+	"chirpy/moderation"
+	"testing"
+)
+
+func TestNormalizeChirpJoiners(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"zwnj between latin letters", "for‌nax", "fornax"},
+		{"zwj between latin letters", "sharb‍ert", "sharbert"},
+		{"zwnj in persian", "می‌خواهم", "می‌خواهم"},
+		{"zwj in devanagari", "क्‍ष", "क्‍ष"},
+		{"zwnj between scripts", "م‌x", "مx"},
+		{"zwj in an emoji sequence", "\U0001F469‍\U0001F4BB", "\U0001F469‍\U0001F4BB"},
+		{"joiner at the edges", "‌hello‍", "hello"},
+		{"repeated joiner", "م‌‌خ", "م‌خ"},
+		{"rlm inside text", "abc‏א", "abc‏א"},
+		{"rlm before a space", "abc‏ def", "abc def"},
+		{"zero width space", "for​nax", "fornax"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeChirp(tt.in); got != tt.want {
+				t.Errorf("NormalizeChirp(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// A joiner must not split a word so the word list filter misses it
+func TestNormalizeChirpJoinerCantDodgeWordList(t *testing.T) {
+	pipeline := moderation.NewPipeline(
+		moderation.NewWordListFilter("profanity", moderation.ActionMask, []string{"fornax"}),
+	)
+
+	for _, body := range []string{"for‌nax", "for‍nax", "for​nax", "for⁠nax"} {
+		masked, verdict := pipeline.Moderate(NormalizeChirp(body))
+		if verdict.Action != moderation.ActionMask {
+			t.Errorf("%q was not masked: %q, %s", body, masked, verdict.Action)
+		}
+	}
+}
@@ -30,18 +30,52 @@ func GenerateRefreshToken(userId string) (database.RefreshToken, error) {
 	return refreshToken, nil
 }
 
-func GenerateJWTToken(userId string, secretKey string) (string, error) {
+// MFATokenIssuer sets MFA challenge tokens apart, so one can't be used as an access token
+const MFATokenIssuer = "chirpy-mfa"
+
+// GenerateMFAToken returns the token a user with two-factor authentication gets for their password,
+// it's only good for the second login step and expires after ttl
+func GenerateMFAToken(userId string, secretKey string, ttl time.Duration) (string, error) {
+	now := time.Now().UTC()
+	claims := &jwt.RegisteredClaims{
+		Issuer:    MFATokenIssuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		Subject:   userId,
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	ss, err := token.SignedString([]byte(secretKey))
+	if err != nil {
+		return "", fmt.Errorf("error while signing token: %s", err)
+	}
+
+	return ss, nil
+}
+
+// AccessTokenClaims are the claims of an access token, the role and its permissions come on top of the registered claims
+type AccessTokenClaims struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+	jwt.RegisteredClaims
+}
+
+func GenerateJWTToken(user database.User, secretKey string) (string, error) {
 	mySigningKey := []byte(secretKey)
 
 	// JWT will expire after 1 hour
 	timeout := time.Now().UTC().Add(time.Hour * 1)
 
-	claims := &jwt.RegisteredClaims{
-		Issuer:   "chirpy",
-		IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
+	claims := &AccessTokenClaims{
+		Role:        user.RoleName(),
+		Permissions: database.RolePermissions(user.RoleName()),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   "chirpy",
+			IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
 
-		Subject:   userId,
-		ExpiresAt: jwt.NewNumericDate(timeout),
+			Subject:   user.Id,
+			ExpiresAt: jwt.NewNumericDate(timeout),
+		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -0,0 +1,36 @@
+package helpers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// ParsePagination reads the offset and limit query parameters,
+// limit defaults to DefaultPageLimit and is capped at MaxPageLimit
+func ParsePagination(r *http.Request) (int, int, error) {
+	offset, limit := 0, DefaultPageLimit
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, errors.New("limit must be a positive integer")
+		}
+		limit = min(n, MaxPageLimit)
+	}
+
+	return offset, limit, nil
+}
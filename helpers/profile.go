@@ -0,0 +1,93 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rivo/uniseg"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Profile field limits, counted in grapheme clusters like chirps
+const (
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 160
+	MaxLocationLength    = 30
+	MaxWebsiteLength     = 100
+)
+
+var handleRegex = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// mentionRegex matches @handle, but not the @ inside an email address
+var mentionRegex = regexp.MustCompile(`(?:^|[^A-Za-z0-9_@])@([A-Za-z0-9_]+)`)
+
+// MaxMentions is how many users one chirp can notify, so a chirp can't be used to ping everyone
+const MaxMentions = 10
+
+// reservedHandles would be mistaken for a route or for a deleted account
+var reservedHandles = []string{"me", "admin", "administrator", "root", "chirpy", "deleted", "support"}
+
+// ValidateHandle checks a handle is 3 to 30 letters, digits or underscores and isn't reserved
+func ValidateHandle(handle string) error {
+	if !handleRegex.MatchString(handle) {
+		return errors.New("handle must be 3 to 30 letters, digits or underscores")
+	}
+
+	if slices.Contains(reservedHandles, strings.ToLower(handle)) {
+		return errors.New("handle is reserved")
+	}
+
+	return nil
+}
+
+// ParseMentions returns the handles mentioned in a chirp, each once, in the order they first appear
+func ParseMentions(body string) []string {
+	handles := []string{}
+	seen := map[string]bool{}
+
+	for _, match := range mentionRegex.FindAllStringSubmatch(body, -1) {
+		handle := match[1]
+		if !handleRegex.MatchString(handle) || seen[strings.ToLower(handle)] {
+			continue
+		}
+
+		seen[strings.ToLower(handle)] = true
+		handles = append(handles, handle)
+		if len(handles) == MaxMentions {
+			break
+		}
+	}
+
+	return handles
+}
+
+// NormalizeProfileText cleans a single line profile field the way chirps are cleaned,
+// and collapses line breaks and runs of spaces into single spaces
+func NormalizeProfileText(s string) string {
+	return strings.Join(strings.Fields(NormalizeChirp(s)), " ")
+}
+
+// ValidateProfileText checks the field isn't longer than max grapheme clusters
+func ValidateProfileText(field string, s string, max int) error {
+	if uniseg.GraphemeClusterCount(s) > max {
+		return fmt.Errorf("%s can't be longer than %d characters", field, max)
+	}
+
+	return nil
+}
+
+// ValidateWebsite checks the website is an absolute http or https url
+func ValidateWebsite(website string) error {
+	if len(website) > MaxWebsiteLength {
+		return fmt.Errorf("website can't be longer than %d characters", MaxWebsiteLength)
+	}
+
+	u, err := url.Parse(website)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("website must be an http or https url")
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileOutbox writes every message to an .eml file in dir instead of sending it, for local development
+type FileOutbox struct {
+	dir  string
+	from string
+
+	mu sync.Mutex
+	n  int
+}
+
+func NewFileOutbox(dir string, from string) *FileOutbox {
+	return &FileOutbox{dir: dir, from: from}
+}
+
+func (o *FileOutbox) Send(msg Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now()
+	}
+
+	dat, err := format(o.from, msg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(o.dir, 0700); err != nil {
+		return fmt.Errorf("error creating outbox: %v", err)
+	}
+
+	// The counter keeps two messages sent in the same nanosecond apart
+	o.mu.Lock()
+	o.n++
+	name := fmt.Sprintf("%d-%d.eml", msg.SentAt.UnixNano(), o.n)
+	o.mu.Unlock()
+
+	if err := os.WriteFile(filepath.Join(o.dir, name), dat, 0600); err != nil {
+		return fmt.Errorf("error writing message: %v", err)
+	}
+
+	return nil
+}
+
+// MemoryOutbox keeps sent messages in memory, for tests
+type MemoryOutbox struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryOutbox() *MemoryOutbox {
+	return &MemoryOutbox{}
+}
+
+func (o *MemoryOutbox) Send(msg Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now()
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first
+func (o *MemoryOutbox) Messages() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]Message{}, o.messages...)
+}
+
+// Last returns the latest message sent to the address
+func (o *MemoryOutbox) Last(to string) (Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i := len(o.messages) - 1; i >= 0; i-- {
+		if o.messages[i].To == to {
+			return o.messages[i], true
+		}
+	}
+
+	return Message{}, false
+}
@@ -1,13 +1,26 @@
 package main
 
 import (
+	"chirpy/activitypub"
+	"chirpy/analytics"
 	"chirpy/database"
+	"chirpy/events"
+	"chirpy/exports"
 	"chirpy/handlers"
 	"chirpy/helpers"
+	"chirpy/mail"
+	"chirpy/moderation"
+	"context"
+	"errors"
 	"github.com/joho/godotenv"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -27,10 +40,68 @@ func main() {
 		log.Printf("Error connecting to database: %v", err)
 	}
 
+	// CHIRPY_ADMIN_IDS makes users admins on startup, so a new server has someone to hand out roles
+	if db != nil {
+		unknownAdmins, err := db.BootstrapAdmins(strings.Split(os.Getenv("CHIRPY_ADMIN_IDS"), ","))
+		if err != nil {
+			log.Printf("Error bootstrapping admins: %v", err)
+		}
+		for _, id := range unknownAdmins {
+			log.Printf("CHIRPY_ADMIN_IDS lists user %s, who doesn't exist", id)
+		}
+	}
+
+	moderator, err := moderation.Load(getEnv("MODERATION_CONFIG", "moderation.json"))
+	if err != nil {
+		// Fall back to the word list chirpy always had, so chirps are never left unfiltered
+		log.Printf("Error loading moderation config, using the default word list: %v", err)
+		moderator = moderation.NewPipeline(
+			moderation.NewWordListFilter("profanity", moderation.ActionMask, []string{"kerfuffle", "sharbert", "fornax"}),
+		)
+	}
+	go moderator.Watch(5 * time.Second)
+
+	// FEDERATION_ALLOW_HTTP lets two local instances federate without TLS, never set it in production
+	federation := activitypub.NewClient(os.Getenv("FEDERATION_ALLOW_HTTP") == "true")
+	federation.Run(getEnvInt("FEDERATION_WORKERS", 4))
+
+	views := analytics.NewRecorder(db)
+	go views.Run(time.Duration(getEnvInt("VIEW_FLUSH_SECONDS", 10)) * time.Second)
+
+	exporter := exports.NewExporter(db, getEnv("EXPORT_DIR", "data_exports"))
+	if err := exporter.Run(getEnvInt("EXPORT_WORKERS", 1)); err != nil {
+		log.Printf("Error starting exporter: %v", err)
+	}
+
+	// Without an SMTP server emails are written to an outbox directory, for local development
+	mailFrom := getEnv("MAIL_FROM", "Chirpy <no-reply@localhost>")
+	var mailer mail.Mailer = mail.NewFileOutbox(getEnv("MAIL_OUTBOX_DIR", "mail_outbox"), mailFrom)
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		mailer = mail.NewSMTPMailer(addr, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), mailFrom)
+	}
+
+	// Absolute links and federation ids are only built from PUBLIC_URL, never from the request
+	if os.Getenv("PUBLIC_URL") == "" {
+		log.Printf("PUBLIC_URL is not set, feeds, federation and verification and password reset emails are off")
+	}
+
 	config := handlers.ApiConfig{
-		FileServerHits: 0,
-		DB:             db,
-		JWTSecret:      JwtSecret,
+		FileServerHits:          0,
+		DB:                      db,
+		JWTSecret:               JwtSecret,
+		ChirpMaxLength:          getEnvInt("CHIRP_MAX_LENGTH", 140),
+		ChirpMaxLengthChirpyRed: getEnvInt("CHIRP_MAX_LENGTH_CHIRPY_RED", 280),
+		Moderator:               moderator,
+		Events:                  events.NewBroker(getEnvInt("EVENT_BACKLOG_SIZE", 1000)),
+		ReportHideThreshold:     getEnvInt("REPORT_HIDE_THRESHOLD", 5),
+		AccountDeletionGrace:    time.Duration(getEnvInt("ACCOUNT_DELETION_GRACE_DAYS", 30)) * 24 * time.Hour,
+		PublicURL:               os.Getenv("PUBLIC_URL"),
+		AvatarDir:               getEnv("AVATAR_DIR", "avatars"),
+		Mailer:                  mailer,
+		RequireVerifiedEmail:    os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+		Federation:              federation,
+		Views:                   views,
+		Exporter:                exporter,
 	}
 
 	mux.Handle("/app", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.StripPrefix("/app", http.FileServer(http.Dir("./"))))))
@@ -44,21 +115,101 @@ func main() {
 		}
 	}))))
 
-	mux.HandleFunc("GET /admin/metrics", func(w http.ResponseWriter, r *http.Request) {
-		logger.MiddlewareLogger(http.HandlerFunc(config.MetricsHandler)).ServeHTTP(w, r)
-	})
+	// Admin routes declare the permissions they need, the roles that grant them are in database/roles.go
+	mux.Handle("GET /admin/metrics", logger.MiddlewareLogger(config.MiddlewarePermissions(http.HandlerFunc(config.MetricsHandler), database.PermissionMetricsRead)))
+	mux.Handle("GET /api/reset", logger.MiddlewareLogger(config.MiddlewarePermissions(http.HandlerFunc(config.ResetMetrics), database.PermissionMetricsReset)))
+
+	mux.Handle("GET /admin/moderation/queue", logger.MiddlewareLogger(config.MiddlewarePermissions(http.HandlerFunc(config.GetModerationQueueHandler), database.PermissionModerationRead)))
+	mux.Handle("POST /admin/moderation/queue/{chirpId}/actions", logger.MiddlewareLogger(config.MiddlewarePermissions(http.HandlerFunc(config.ModerationActionHandler), database.PermissionModerationWrite)))
+	mux.Handle("DELETE /admin/users/{id}", logger.MiddlewareLogger(config.MiddlewarePermissions(http.HandlerFunc(config.AdminDeleteUserHandler), database.PermissionUsersDelete)))
+	mux.Handle("PUT /admin/users/{id}/role", logger.MiddlewareLogger(config.MiddlewarePermissions(http.HandlerFunc(config.SetUserRoleHandler), database.PermissionRolesWrite)))
 
-	mux.HandleFunc("GET /api/reset", config.ResetMetrics)
+	mux.Handle("POST /api/chirps", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.PostChirpsHandler), database.ScopeChirpsWrite))))
+	mux.Handle("GET /api/chirps", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetChirpsHandler), database.ScopeChirpsRead))))
+	mux.Handle("GET /.well-known/webfinger", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.WebFingerHandler))))
+	mux.Handle("GET /users/{id}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.ActorHandler))))
+	mux.Handle("GET /users/{id}/outbox", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.OutboxHandler))))
+	mux.Handle("GET /users/{id}/followers", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.RemoteFollowersHandler))))
+	mux.Handle("POST /users/{id}/inbox", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.InboxHandler))))
+	mux.Handle("GET /notes/{id}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.NoteHandler))))
+	mux.Handle("GET /api/federation/following", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetRemoteFollowingHandler), database.ScopeSocialRead))))
+	mux.Handle("POST /api/federation/following", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.FollowRemoteHandler), database.ScopeSocialWrite))))
+	mux.Handle("DELETE /api/federation/following", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.UnfollowRemoteHandler), database.ScopeSocialWrite))))
+	mux.Handle("GET /api/federation/notes", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetRemoteNotesHandler), database.ScopeChirpsRead))))
+	mux.Handle("GET /feed.atom", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.GlobalAtomFeedHandler))))
+	mux.Handle("GET /feed.rss", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.GlobalRSSFeedHandler))))
+	mux.Handle("GET /users/{id}/feed.atom", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.UserAtomFeedHandler))))
+	mux.Handle("GET /users/{id}/feed.rss", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.UserRSSFeedHandler))))
+	mux.Handle("GET /api/gateway", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.GatewayHandler))))
+	mux.Handle("GET /api/chirps/stream", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.StreamChirpsHandler), database.ScopeChirpsRead))))
+	mux.Handle("GET /api/chirps/{id}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetChirpHandler), database.ScopeChirpsRead))))
+	mux.Handle("DELETE /api/chirps/{id}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.DeleteChirpsHandler), database.ScopeChirpsWrite))))
+	mux.Handle("POST /api/chirps/{id}/pin", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.PinChirpHandler), database.ScopeChirpsWrite))))
+	mux.Handle("POST /api/chirps/{id}/report", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.ReportChirpHandler), database.ScopeChirpsWrite))))
+	mux.Handle("POST /api/chirps/{id}/vote", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.VotePollHandler), database.ScopeChirpsWrite))))
+	mux.Handle("DELETE /api/chirps/{id}/pin", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.UnpinChirpHandler), database.ScopeChirpsWrite))))
 
-	mux.Handle("POST /api/chirps", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.PostChirpsHandler))))
-	mux.Handle("GET /api/chirps", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.GetChirpsHandler))))
-	mux.Handle("GET /api/chirps/{id}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.GetChirpHandler))))
-	mux.Handle("DELETE /api/chirps/{id}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.DeleteChirpsHandler))))
+	mux.Handle("GET /api/bookmarks", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetBookmarksHandler), database.ScopeBookmarksRead))))
+	mux.Handle("POST /api/bookmarks/{chirpId}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.AddBookmarkHandler), database.ScopeBookmarksWrite))))
+	mux.Handle("DELETE /api/bookmarks/{chirpId}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.RemoveBookmarkHandler), database.ScopeBookmarksWrite))))
+	mux.Handle("GET /api/bookmarks/folders", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetBookmarkFoldersHandler), database.ScopeBookmarksRead))))
+	mux.Handle("POST /api/bookmarks/folders", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.CreateBookmarkFolderHandler), database.ScopeBookmarksWrite))))
+	mux.Handle("DELETE /api/bookmarks/folders/{folderId}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.DeleteBookmarkFolderHandler), database.ScopeBookmarksWrite))))
 
 	mux.Handle("POST /api/users", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.RegisterUsersHandler))))
-	mux.Handle("PUT /api/users", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.UpdateUsersHandler))))
+	mux.Handle("GET /api/users/me", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetOwnUserHandler), database.ScopeProfileRead))))
+	mux.Handle("PATCH /api/users/me", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.UpdateOwnUserHandler), database.ScopeProfileWrite))))
+	mux.Handle("GET /api/users/{id}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.GetUserHandler))))
+	mux.Handle("PUT /api/users/me/profile", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.UpdateProfileHandler), database.ScopeProfileWrite))))
+	mux.Handle("PUT /api/users/me/avatar", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.UploadAvatarHandler), database.ScopeProfileWrite))))
+	mux.Handle("DELETE /api/users/me/avatar", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.DeleteAvatarHandler), database.ScopeProfileWrite))))
+	mux.Handle("POST /api/password/forgot", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.ForgotPasswordHandler))))
+	mux.Handle("POST /api/password/reset", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.ResetPasswordHandler))))
+	mux.Handle("POST /api/users/me/2fa", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareSession(http.HandlerFunc(config.StartTwoFactorHandler)))))
+	mux.Handle("POST /api/users/me/2fa/confirm", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareSession(http.HandlerFunc(config.ConfirmTwoFactorHandler)))))
+	mux.Handle("POST /api/users/me/2fa/recovery-codes", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareSession(http.HandlerFunc(config.RegenerateRecoveryCodesHandler)))))
+	mux.Handle("DELETE /api/users/me/2fa", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareSession(http.HandlerFunc(config.DisableTwoFactorHandler)))))
+	mux.Handle("POST /api/users/me/email/verification", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareSession(http.HandlerFunc(config.ResendEmailVerificationHandler)))))
+	mux.Handle("GET /api/email/verify", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.ConfirmEmailHandler))))
+	mux.Handle("POST /api/email/verify", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.VerifyEmailHandler))))
+	mux.Handle("DELETE /api/users/me", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareSession(http.HandlerFunc(config.DeleteAccountHandler)))))
+	mux.Handle("DELETE /api/users/me/deletion", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareSession(http.HandlerFunc(config.CancelAccountDeletionHandler)))))
+	mux.Handle("POST /api/users/me/exports", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareSession(http.HandlerFunc(config.RequestDataExportHandler)))))
+	mux.Handle("GET /api/users/me/exports/{id}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareSession(http.HandlerFunc(config.GetDataExportHandler)))))
+	mux.Handle("GET /api/exports/{id}/download", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.DownloadDataExportHandler))))
+	mux.Handle("GET /api/users/me/analytics", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.AnalyticsHandler), database.ScopeProfileRead))))
+	mux.Handle("POST /api/users/me/tokens", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareSession(http.HandlerFunc(config.CreateAccessTokenHandler)))))
+	mux.Handle("GET /api/users/me/tokens", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareSession(http.HandlerFunc(config.GetAccessTokensHandler)))))
+	mux.Handle("DELETE /api/users/me/tokens/{id}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareSession(http.HandlerFunc(config.RevokeAccessTokenHandler)))))
+
+	mux.Handle("POST /api/users/{id}/follow", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.FollowHandler), database.ScopeSocialWrite))))
+	mux.Handle("DELETE /api/users/{id}/follow", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.UnfollowHandler), database.ScopeSocialWrite))))
+	// followers, following, avatar and by-handle/{handle}, see UserSubresourceHandler
+	mux.Handle("GET /api/users/{id}/{name}", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.UserSubresourceHandler))))
+
+	mux.Handle("POST /api/users/{id}/block", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.BlockHandler), database.ScopeSocialWrite))))
+	mux.Handle("DELETE /api/users/{id}/block", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.UnblockHandler), database.ScopeSocialWrite))))
+	mux.Handle("POST /api/users/{id}/mute", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.MuteHandler), database.ScopeSocialWrite))))
+	mux.Handle("DELETE /api/users/{id}/mute", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.UnmuteHandler), database.ScopeSocialWrite))))
+	mux.Handle("GET /api/blocks", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetBlocksHandler), database.ScopeSocialRead))))
+	mux.Handle("GET /api/mutes", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetMutesHandler), database.ScopeSocialRead))))
+
+	mux.Handle("GET /api/timeline", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.TimelineHandler), database.ScopeChirpsRead))))
+
+	mux.Handle("GET /api/conversations", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetConversationsHandler), database.ScopeMessagesRead))))
+	mux.Handle("POST /api/conversations", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.CreateConversationHandler), database.ScopeMessagesWrite))))
+	mux.Handle("GET /api/conversations/{id}/messages", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetMessagesHandler), database.ScopeMessagesRead))))
+	mux.Handle("POST /api/conversations/{id}/messages", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.SendMessageHandler), database.ScopeMessagesWrite))))
+	mux.Handle("POST /api/conversations/{id}/read", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.MarkConversationReadHandler), database.ScopeMessagesWrite))))
+
+	mux.Handle("GET /api/notifications", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetNotificationsHandler), database.ScopeNotificationsRead))))
+	mux.Handle("POST /api/notifications/{id}/read", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.MarkNotificationReadHandler), database.ScopeNotificationsWrite))))
+	mux.Handle("POST /api/notifications/read-all", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.MarkAllNotificationsReadHandler), database.ScopeNotificationsWrite))))
+	mux.Handle("GET /api/notifications/preferences", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.GetNotificationPreferencesHandler), database.ScopeNotificationsRead))))
+	mux.Handle("PUT /api/notifications/preferences", config.MiddlewareMetricsInc(logger.MiddlewareLogger(config.MiddlewareScopes(http.HandlerFunc(config.UpdateNotificationPreferencesHandler), database.ScopeNotificationsWrite))))
 
 	mux.Handle("POST /api/login", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.LoginHandler))))
+	mux.Handle("POST /api/login/mfa", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.LoginMFAHandler))))
 
 	mux.Handle("POST /api/refresh", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.RefreshHandler))))
 	mux.Handle("POST /api/revoke", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.RevokeTokenHandler))))
@@ -66,12 +217,67 @@ func main() {
 	mux.Handle("POST /api/polka/webhooks", config.MiddlewareMetricsInc(logger.MiddlewareLogger(http.HandlerFunc(config.PolkaHandler))))
 
 	server := &http.Server{
-		Addr:    ":8080",
+		Addr:    ":" + getEnv("PORT", "8080"),
 		Handler: mux,
 	}
 
+	// Streaming and gateway clients never finish on their own, closing the broker ends them on shutdown
+	server.RegisterOnShutdown(config.Events.Close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go config.RunAccountDeletions(ctx, time.Hour)
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down server: %s", err)
+		}
+
+		if err := config.Events.Wait(shutdownCtx); err != nil {
+			log.Printf("Error waiting for streaming clients: %s", err)
+		}
+
+		federation.Close()
+		exporter.Close()
+
+		if err := views.Close(); err != nil {
+			log.Printf("Error flushing chirp views: %s", err)
+		}
+	}()
+
 	err = server.ListenAndServe()
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Error starting server: %s", err)
 		return
 	}
+
+	<-shutdownDone
+}
+
+// getEnv reads a string from the environment, falling back if it is unset
+func getEnv(key string, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
+
+// getEnvInt reads an integer from the environment, falling back if it is unset or invalid
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+
+	return value
 }
@@ -0,0 +1,190 @@
+package moderation
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const mask = "****"
+
+// Filter inspects a chirp body, it returns the (possibly masked) body and whether it matched.
+// What happens on a match is decided by the action configured for the filter
+type Filter interface {
+	Name() string
+	Action() Action
+	Apply(body string) (string, bool)
+}
+
+type baseFilter struct {
+	name   string
+	action Action
+}
+
+func (f baseFilter) Name() string {
+	return f.name
+}
+
+func (f baseFilter) Action() Action {
+	return f.action
+}
+
+// WordListFilter masks whole words containing a listed word, matching is case-insensitive
+// but the rest of the chirp is left untouched, and punctuation around a word is kept
+type WordListFilter struct {
+	baseFilter
+	words []string
+}
+
+var wordRegex = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+func NewWordListFilter(name string, action Action, words []string) *WordListFilter {
+	lowered := make([]string, 0, len(words))
+	for _, w := range words {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if w != "" {
+			lowered = append(lowered, w)
+		}
+	}
+
+	return &WordListFilter{baseFilter: baseFilter{name: name, action: action}, words: lowered}
+}
+
+func (f *WordListFilter) Apply(body string) (string, bool) {
+	matched := false
+	masked := wordRegex.ReplaceAllStringFunc(body, func(word string) string {
+		lower := strings.ToLower(word)
+		for _, w := range f.words {
+			if strings.Contains(lower, w) {
+				matched = true
+				return mask
+			}
+		}
+		return word
+	})
+
+	return masked, matched
+}
+
+// RegexFilter matches any of its patterns, masking replaces the matched text
+type RegexFilter struct {
+	baseFilter
+	patterns []*regexp.Regexp
+}
+
+func NewRegexFilter(name string, action Action, patterns []string) (*RegexFilter, error) {
+	filter := RegexFilter{baseFilter: baseFilter{name: name, action: action}}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in filter %s: %v", p, name, err)
+		}
+		filter.patterns = append(filter.patterns, re)
+	}
+
+	return &filter, nil
+}
+
+func (f *RegexFilter) Apply(body string) (string, bool) {
+	matched := false
+	for _, re := range f.patterns {
+		if re.MatchString(body) {
+			matched = true
+			body = re.ReplaceAllString(body, mask)
+		}
+	}
+
+	return body, matched
+}
+
+// LinkBlocklistFilter matches links to a blocked domain or any of its subdomains
+type LinkBlocklistFilter struct {
+	baseFilter
+	domains []string
+}
+
+var linkRegex = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s]+`)
+
+func NewLinkBlocklistFilter(name string, action Action, domains []string) *LinkBlocklistFilter {
+	lowered := make([]string, 0, len(domains))
+	for _, d := range domains {
+		lowered = append(lowered, strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), "www."))
+	}
+
+	return &LinkBlocklistFilter{baseFilter: baseFilter{name: name, action: action}, domains: lowered}
+}
+
+func (f *LinkBlocklistFilter) Apply(body string) (string, bool) {
+	matched := false
+	masked := linkRegex.ReplaceAllStringFunc(body, func(link string) string {
+		// The scheme is only added to parse bare www. links, links that aren't blocked are left as written
+		parsed := link
+		if !strings.Contains(parsed, "://") {
+			parsed = "http://" + parsed
+		}
+
+		u, err := url.Parse(parsed)
+		if err != nil {
+			return link
+		}
+
+		host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+		for _, d := range f.domains {
+			if host == d || strings.HasSuffix(host, "."+d) {
+				matched = true
+				return mask
+			}
+		}
+		return link
+	})
+
+	if !matched {
+		return body, false
+	}
+
+	return masked, true
+}
+
+// RepeatedCharFilter matches runs of the same character longer than maxRepeat,
+// masking shortens the run to maxRepeat
+type RepeatedCharFilter struct {
+	baseFilter
+	maxRepeat int
+}
+
+func NewRepeatedCharFilter(name string, action Action, maxRepeat int) (*RepeatedCharFilter, error) {
+	if maxRepeat < 1 {
+		return nil, fmt.Errorf("max_repeat must be positive in filter %s", name)
+	}
+
+	return &RepeatedCharFilter{baseFilter: baseFilter{name: name, action: action}, maxRepeat: maxRepeat}, nil
+}
+
+func (f *RepeatedCharFilter) Apply(body string) (string, bool) {
+	var b strings.Builder
+	matched := false
+
+	var prev rune
+	run := 0
+	for _, r := range body {
+		if r == prev {
+			run++
+		} else {
+			prev, run = r, 1
+		}
+
+		if run > f.maxRepeat {
+			matched = true
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	if !matched {
+		return body, false
+	}
+
+	return b.String(), true
+}
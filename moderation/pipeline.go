@@ -0,0 +1,257 @@
+package moderation
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Action string
+
+const (
+	ActionAllow  Action = "allow"
+	ActionMask   Action = "mask"
+	ActionHold   Action = "hold"
+	ActionReject Action = "reject"
+)
+
+// severity is used to pick the strongest action when several filters match
+var severity = map[Action]int{
+	ActionAllow:  0,
+	ActionMask:   1,
+	ActionHold:   2,
+	ActionReject: 3,
+}
+
+// Verdict is the outcome of running a chirp through the pipeline
+type Verdict struct {
+	Action Action
+	// Filters are the names of the filters that matched, in pipeline order
+	Filters []string
+}
+
+// Merge combines the verdicts of several texts of the same chirp: the strongest action, and every filter that matched
+func (v Verdict) Merge(other Verdict) Verdict {
+	if severity[other.Action] > severity[v.Action] {
+		v.Action = other.Action
+	}
+
+	filters := append([]string{}, v.Filters...)
+	for _, name := range other.Filters {
+		if !slices.Contains(filters, name) {
+			filters = append(filters, name)
+		}
+	}
+	v.Filters = filters
+
+	return v
+}
+
+// Pipeline runs chirps through an ordered list of filters.
+// The filters can be reloaded while the server is running, only chirps posted afterwards see the new filters
+type Pipeline struct {
+	path    string
+	mu      sync.RWMutex
+	filters []Filter
+	// files are the config file and every word list it references, with their last modification time
+	files map[string]time.Time
+}
+
+type config struct {
+	Filters []filterConfig `json:"filters"`
+}
+
+type filterConfig struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Action Action `json:"action"`
+
+	WordListFile string   `json:"word_list_file"`
+	Patterns     []string `json:"patterns"`
+	Domains      []string `json:"domains"`
+	MaxRepeat    int      `json:"max_repeat"`
+}
+
+// NewPipeline creates a pipeline from the given filters, it can't be reloaded
+func NewPipeline(filters ...Filter) *Pipeline {
+	return &Pipeline{filters: filters, files: map[string]time.Time{}}
+}
+
+// Load creates a pipeline from a JSON config file, word list paths are relative to the config file
+func Load(path string) (*Pipeline, error) {
+	p := Pipeline{path: path}
+
+	err := p.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// Reload reads the config file and word lists again, the current filters are kept if anything is invalid
+func (p *Pipeline) Reload() error {
+	if p.path == "" {
+		return nil
+	}
+
+	files := map[string]time.Time{}
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("error reading moderation config: %v", err)
+	}
+	files[p.path] = info.ModTime()
+
+	dat, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("error reading moderation config: %v", err)
+	}
+
+	cfg := config{}
+	err = json.Unmarshal(dat, &cfg)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling moderation config: %v", err)
+	}
+
+	filters := make([]Filter, 0, len(cfg.Filters))
+	for _, fc := range cfg.Filters {
+		if _, ok := severity[fc.Action]; !ok || fc.Action == ActionAllow {
+			return fmt.Errorf("invalid action %q in filter %s", fc.Action, fc.Name)
+		}
+
+		var filter Filter
+		switch fc.Type {
+		case "word_list":
+			wordListPath := filepath.Join(filepath.Dir(p.path), fc.WordListFile)
+
+			words, modTime, err := readWordList(wordListPath)
+			if err != nil {
+				return err
+			}
+			files[wordListPath] = modTime
+
+			filter = NewWordListFilter(fc.Name, fc.Action, words)
+		case "regex":
+			filter, err = NewRegexFilter(fc.Name, fc.Action, fc.Patterns)
+		case "link_blocklist":
+			filter = NewLinkBlocklistFilter(fc.Name, fc.Action, fc.Domains)
+		case "repeated_chars":
+			filter, err = NewRepeatedCharFilter(fc.Name, fc.Action, fc.MaxRepeat)
+		default:
+			err = fmt.Errorf("unknown filter type %q", fc.Type)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		filters = append(filters, filter)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.filters = filters
+	p.files = files
+
+	return nil
+}
+
+// Watch reloads the pipeline whenever the config file or a word list changes, it never returns
+func (p *Pipeline) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !p.changed() {
+			continue
+		}
+
+		err := p.Reload()
+		if err != nil {
+			log.Printf("Error reloading moderation pipeline, keeping the previous filters: %s", err)
+			continue
+		}
+
+		log.Printf("Moderation pipeline reloaded from %s", p.path)
+	}
+}
+
+// changed returns true if any watched file was modified since the last reload
+func (p *Pipeline) changed() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for path, modTime := range p.files {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Moderate runs the body through every filter in order. Masking filters rewrite the body
+// for the filters after them; the verdict action is the strongest action of all matching filters
+func (p *Pipeline) Moderate(body string) (string, Verdict) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	verdict := Verdict{Action: ActionAllow, Filters: []string{}}
+
+	for _, filter := range p.filters {
+		masked, matched := filter.Apply(body)
+		if !matched {
+			continue
+		}
+
+		verdict.Filters = append(verdict.Filters, filter.Name())
+		if severity[filter.Action()] > severity[verdict.Action] {
+			verdict.Action = filter.Action()
+		}
+
+		if filter.Action() == ActionMask {
+			body = masked
+		}
+	}
+
+	return body, verdict
+}
+
+// readWordList reads one word per line, blank lines and lines starting with # are skipped
+func readWordList(path string) ([]string, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error opening word list: %v", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error reading word list: %v", err)
+	}
+
+	words := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, time.Time{}, fmt.Errorf("error reading word list: %v", err)
+	}
+
+	return words, info.ModTime(), nil
+}
@@ -0,0 +1,70 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238 appendix B, they are 8 digits long so only the last Digits are compared
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %s", tt.unix, err)
+		}
+
+		want := tt.code[len(tt.code)-Digits:]
+		if got != want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := rfcSecret
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	for _, step := range []int64{current - Skew, current, current + Skew} {
+		code, err := Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		matched, ok := Validate(secret, code[:3]+" "+code[3:], now)
+		if !ok || matched != step {
+			t.Errorf("Validate of the code for step %d = %d, %v", step, matched, ok)
+		}
+	}
+
+	for _, step := range []int64{current - Skew - 1, current + Skew + 1} {
+		code, err := Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := Validate(secret, code, now); ok {
+			t.Errorf("the code for step %d was accepted at step %d", step, current)
+		}
+	}
+
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("a code with too few digits was accepted")
+	}
+}